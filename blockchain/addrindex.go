@@ -0,0 +1,351 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+var (
+	addrIndexPrefix = []byte("ad-")
+	// set while the optional address index is kept
+	addrIndexKey = []byte("addrindexed")
+)
+
+// ErrNoAddressIndex is returned when asking the address index for something while it is not kept
+var ErrNoAddressIndex = errors.New("The address index is not enabled")
+
+// Outpoint struct - one output of a transaction
+type Outpoint struct {
+	ID  []byte
+	Out int
+}
+
+// AddressOutput struct - an output paying an address, with its locking script as it is in the transaction
+type AddressOutput struct {
+	Out    int
+	Value  int
+	Script []byte
+}
+
+// AddressTx struct - a main chain transaction that pays to or spends from an address
+type AddressTx struct {
+	TxID      []byte
+	BlockHash []byte
+	Height    int
+	Received  int             // total paid to the address
+	Sent      int             // total of the address's outputs spent
+	Outputs   []AddressOutput // the outputs paying the address
+	Spends    []Outpoint      // the address's outputs that are spent
+}
+
+// UnspentOutput struct - an unspent output and where it is
+type UnspentOutput struct {
+	Outpoint
+	Output TxOutput
+}
+
+// Serialize function
+func (atx AddressTx) Serialize() []byte {
+	var buff bytes.Buffer
+
+	encode := gob.NewEncoder(&buff)
+	Handle(encode.Encode(atx))
+
+	return buff.Bytes()
+}
+
+// DeserializeAddressTx function
+func DeserializeAddressTx(data []byte) (AddressTx, error) {
+	var atx AddressTx
+
+	decode := gob.NewDecoder(bytes.NewReader(data))
+	err := decode.Decode(&atx)
+
+	return atx, err
+}
+
+// addrIndexKeyFor function - entries sort by address, then height, then transaction
+func addrIndexKeyFor(pubKeyHash []byte, height int, txID []byte) []byte {
+	key := append(append([]byte{}, addrIndexPrefix...), pubKeyHash...)
+
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+	key = append(key, h[:]...)
+
+	return append(key, txID...)
+}
+
+// addressEntries function - the address index entries for a block's transactions, by key. The outputs its
+// inputs spend are looked up through the transaction index, so the block must be indexed there.
+func addressEntries(txn StoreTx, block *Block) (map[string]*AddressTx, error) {
+	entries := make(map[string]*AddressTx)
+
+	for _, tx := range block.Transactions {
+		entry := func(pubKeyHash []byte) *AddressTx {
+			key := string(addrIndexKeyFor(pubKeyHash, block.Height, tx.ID))
+			if _, ok := entries[key]; !ok {
+				entries[key] = &AddressTx{TxID: tx.ID, BlockHash: block.Hash, Height: block.Height}
+			}
+			return entries[key]
+		}
+
+		for outIdx, out := range tx.Outputs {
+			pubKeyHash := out.AddressHash()
+			if pubKeyHash == nil {
+				continue
+			}
+			e := entry(pubKeyHash)
+			e.Received += out.Value
+			e.Outputs = append(e.Outputs, AddressOutput{outIdx, out.Value, out.Script})
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			prevTX, err := findTransaction(txn, in.ID)
+			if err != nil {
+				return nil, err
+			}
+			if in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+				return nil, ErrMissingInput
+			}
+			prevOut := prevTX.Outputs[in.Out]
+			pubKeyHash := prevOut.AddressHash()
+			if pubKeyHash == nil {
+				continue
+			}
+
+			e := entry(pubKeyHash)
+			e.Sent += prevOut.Value
+			e.Spends = append(e.Spends, Outpoint{in.ID, in.Out})
+		}
+	}
+
+	return entries, nil
+}
+
+// indexAddresses function
+func indexAddresses(txn StoreTx, block *Block) error {
+	entries, err := addressEntries(txn, block)
+	if err != nil {
+		return err
+	}
+
+	for key, e := range entries {
+		if err := txn.Set([]byte(key), e.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexAddresses function
+func unindexAddresses(txn StoreTx, block *Block) error {
+	entries, err := addressEntries(txn, block)
+	if err != nil {
+		return err
+	}
+
+	for key := range entries {
+		if err := txn.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addrIndexEnabled function
+func addrIndexEnabled(txn StoreTx) (bool, error) {
+	_, err := txn.Get(addrIndexKey)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// AddressIndexEnabled function
+func (chain *BlockChain) AddressIndexEnabled() (bool, error) {
+	var enabled bool
+
+	err := chain.Store.View(func(txn StoreTx) error {
+		var err error
+		enabled, err = addrIndexEnabled(txn)
+		return err
+	})
+
+	return enabled, err
+}
+
+// ReindexAddresses function - builds the address index from the main chain and keeps it from then on. Returns
+// ErrHistoryNotVerified on a chain started from a UTXO snapshot whose history is missing.
+func (chain *BlockChain) ReindexAddresses() error {
+	verified, err := chain.historyVerified()
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return ErrHistoryNotVerified
+	}
+
+	if err := chain.DropAddressIndex(); err != nil {
+		return err
+	}
+
+	iter := chain.Iterator()
+
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		err = chain.Store.Update(func(txn StoreTx) error {
+			return indexAddresses(txn, block)
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return chain.Store.Update(func(txn StoreTx) error {
+		return txn.Set(addrIndexKey, []byte{1})
+	})
+}
+
+// DropAddressIndex function - deletes the address index and stops keeping it
+func (chain *BlockChain) DropAddressIndex() error {
+	err := chain.Store.Update(func(txn StoreTx) error {
+		return txn.Delete(addrIndexKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	return deleteByPrefix(chain.Store, addrIndexPrefix)
+}
+
+// addressTxs function - calls fn with each of the address's entries, newest first, until it returns false
+func (chain *BlockChain) addressTxs(pubKeyHash []byte, fn func(atx AddressTx) bool) error {
+	prefix := append(append([]byte{}, addrIndexPrefix...), pubKeyHash...)
+
+	return chain.Store.View(func(txn StoreTx) error {
+		enabled, err := addrIndexEnabled(txn)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			return ErrNoAddressIndex
+		}
+
+		return txn.Iterate(prefix, true, func(_, v []byte) error {
+			atx, err := DeserializeAddressTx(v)
+			if err != nil {
+				return err
+			}
+			if !fn(atx) {
+				return ErrStopIteration
+			}
+			return nil
+		})
+	})
+}
+
+// GetAddressHistory function - the address's transactions, newest first. skip and limit page through them,
+// a limit of 0 returns everything after skip.
+func (chain *BlockChain) GetAddressHistory(pubKeyHash []byte, skip, limit int) ([]AddressTx, error) {
+	var history []AddressTx
+
+	err := chain.addressTxs(pubKeyHash, func(atx AddressTx) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+		history = append(history, atx)
+
+		return limit <= 0 || len(history) < limit
+	})
+
+	return history, err
+}
+
+// FindAddressUTXOs function - the address's unspent outputs, worked out from its history in the address index
+// rather than by reading the whole UTXO set
+func (chain *BlockChain) FindAddressUTXOs(pubKeyHash []byte) ([]UnspentOutput, error) {
+	var UTXOs []UnspentOutput
+	spent := make(map[string]bool)
+
+	err := chain.addressTxs(pubKeyHash, func(atx AddressTx) bool {
+		for _, op := range atx.Spends {
+			spent[fmt.Sprintf("%x:%d", op.ID, op.Out)] = true
+		}
+		for _, out := range atx.Outputs {
+			UTXOs = append(UTXOs, UnspentOutput{Outpoint{atx.TxID, out.Out}, TxOutput{out.Value, out.Script}})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	unspent := UTXOs[:0]
+	for _, utxo := range UTXOs {
+		if !spent[fmt.Sprintf("%x:%d", utxo.ID, utxo.Out)] {
+			unspent = append(unspent, utxo)
+		}
+	}
+
+	return unspent, nil
+}
+
+// GetBalance function - the value of the address's outputs that can be spent in the next block, and of its
+// coinbase outputs that are still maturing. Uses the address index when it is enabled.
+func (chain *BlockChain) GetBalance(pubKeyHash []byte) (int, int, error) {
+	UTXOSet := UTXOSet{chain}
+
+	indexed, err := chain.AddressIndexEnabled()
+	if err != nil {
+		return 0, 0, err
+	}
+	if !indexed {
+		return UTXOSet.FindBalance(pubKeyHash)
+	}
+
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return 0, 0, err
+	}
+	UTXOs, err := chain.FindAddressUTXOs(pubKeyHash)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	spendable, immature := 0, 0
+	for _, utxo := range UTXOs {
+		entry, err := UTXOSet.FindEntry(utxo.ID, utxo.Out)
+		if err != nil {
+			return 0, 0, err
+		}
+		if entry == nil {
+			continue
+		}
+
+		if entry.IsMature(bestHeight + 1) {
+			spendable += utxo.Output.Value
+		} else {
+			immature += utxo.Output.Value
+		}
+	}
+
+	return spendable, immature, nil
+}
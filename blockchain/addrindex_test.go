@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+func TestAddressIndex(t *testing.T) {
+	chain, w := newTestChain(t)
+	other := newTestWallet(t)
+	pubKeyHash := wallet.PublicKeyHash(other.PublicKey)
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := genesis.Transactions[0]
+	value := coins.Outputs[0].Value
+
+	if _, err := chain.GetAddressHistory(pubKeyHash, 0, 0); !errors.Is(err, ErrNoAddressIndex) {
+		t.Fatalf("expected %v, got %v", ErrNoAddressIndex, err)
+	}
+
+	payment := spendTestTx(t, w, coins, 0, testOutput(t, 5, other), testOutput(t, value-5, w))
+	mineTestBlock(t, chain, w, payment)
+	refund := spendTestTx(t, other, payment, 0, testOutput(t, 2, w), testOutput(t, 3, other))
+	mineTestBlock(t, chain, w, refund)
+
+	if err := chain.ReindexAddresses(); err != nil {
+		t.Fatal(err)
+	}
+
+	// kept up to date from here on
+	tip := mineTestBlock(t, chain, w)
+	again := spendTestTx(t, w, tip.Transactions[0], 0, testOutput(t, 1, other), testOutput(t, tip.Transactions[0].Outputs[0].Value-1, w))
+	mineTestBlock(t, chain, w, again)
+
+	history, err := chain.GetAddressHistory(pubKeyHash, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		tx       *Transaction
+		received int
+		sent     int
+	}{
+		{again, 1, 0},
+		{refund, 3, 5},
+		{payment, 5, 0},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d transactions, got %d", len(expected), len(history))
+	}
+	for i, want := range expected {
+		atx := history[i]
+		if !bytes.Equal(atx.TxID, want.tx.ID) || atx.Received != want.received || atx.Sent != want.sent {
+			t.Fatalf("entry %d: got %x receiving %d and sending %d", i, atx.TxID, atx.Received, atx.Sent)
+		}
+	}
+
+	page, err := chain.GetAddressHistory(pubKeyHash, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || !bytes.Equal(page[0].TxID, refund.ID) {
+		t.Fatal("expected the second page of one to hold the refund")
+	}
+
+	balance, _, err := chain.GetBalance(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 4 {
+		t.Fatalf("expected a balance of 4, got %d", balance)
+	}
+	UTXOs, err := chain.FindAddressUTXOs(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(UTXOs) != 2 {
+		t.Fatalf("expected 2 unspent outputs, got %d", len(UTXOs))
+	}
+
+	if err := chain.DropAddressIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.GetAddressHistory(pubKeyHash, 0, 0); !errors.Is(err, ErrNoAddressIndex) {
+		t.Fatalf("expected %v after dropping the index, got %v", ErrNoAddressIndex, err)
+	}
+	if balance, _, err := chain.GetBalance(pubKeyHash); err != nil || balance != 4 {
+		t.Fatalf("expected the balance from the UTXO set to be 4, got %d: %v", balance, err)
+	}
+}
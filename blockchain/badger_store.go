@@ -0,0 +1,175 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/options"
+)
+
+// badgerStore struct - a ChainStore in a badger database
+type badgerStore struct {
+	db *badger.DB
+}
+
+// badgerTx struct
+type badgerTx struct {
+	txn *badger.Txn
+}
+
+// openBadgerStore function - creates the directory the database goes in if it is missing
+func openBadgerStore(path string) (*badgerStore, error) {
+	if err := os.MkdirAll(filepath.Dir(filepath.Clean(path)), 0755); err != nil {
+		return nil, err
+	}
+
+	opts := badger.DefaultOptions(path)
+	opts.ValueLogLoadingMode = options.FileIO
+	opts.Logger = nil
+
+	db, err := openDB(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &badgerStore{db}, nil
+}
+
+// View function
+func (s *badgerStore) View(fn func(txn StoreTx) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		return fn(badgerTx{txn})
+	})
+}
+
+// Update function
+func (s *badgerStore) Update(fn func(txn StoreTx) error) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return fn(badgerTx{txn})
+	})
+}
+
+// Close function
+func (s *badgerStore) Close() error {
+	return s.db.Close()
+}
+
+// Get function
+func (t badgerTx) Get(key []byte) ([]byte, error) {
+	item, err := t.txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return item.ValueCopy(nil)
+}
+
+// Set function
+func (t badgerTx) Set(key, value []byte) error {
+	return t.txn.Set(key, value)
+}
+
+// Delete function
+func (t badgerTx) Delete(key []byte) error {
+	return t.txn.Delete(key)
+}
+
+// Iterate function
+func (t badgerTx) Iterate(prefix []byte, reverse bool, fn func(key, value []byte) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = reverse
+	it := t.txn.NewIterator(opts)
+	defer it.Close()
+
+	if reverse {
+		// a reverse seek lands on the last key at or before the one given, so start from the first key past
+		// the prefix and step off it if it exists
+		end := prefixEnd(prefix)
+		if end == nil {
+			it.Rewind()
+		} else {
+			it.Seek(end)
+			if it.Valid() && bytes.Equal(it.Item().Key(), end) {
+				it.Next()
+			}
+		}
+	} else {
+		it.Seek(prefix)
+	}
+
+	for ; it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		err = fn(item.KeyCopy(nil), value)
+		if err == ErrStopIteration {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prefixEnd function - the first key after every key with the prefix, nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
+
+// DBexists function
+func DBexists(path string) bool {
+	if _, err := os.Stat(path + "/MANIFEST"); os.IsNotExist(err) {
+		return false
+	}
+	return true
+}
+
+// retry function
+func retry(dir string, originalOpts badger.Options) (*badger.DB, error) {
+	lockPath := filepath.Join(dir, "LOCK")
+	if err := os.Remove(lockPath); err != nil {
+		return nil, fmt.Errorf(`removing "LOCK": %s`, err)
+	}
+	retryOpts := originalOpts
+	retryOpts.Truncate = true
+	db, err := badger.Open(retryOpts)
+	return db, err
+}
+
+// openDB function
+func openDB(dir string, opts badger.Options) (*badger.DB, error) {
+	if db, err := badger.Open(opts); err != nil {
+		if strings.Contains(err.Error(), "LOCK") {
+			if db, err := retry(dir, opts); err == nil {
+				log.Println("database unlocked, value log truncated")
+				return db, nil
+			}
+			log.Println("could not unlock database:", err)
+		}
+		return nil, err
+	} else {
+		return db, nil
+	}
+}
@@ -9,31 +9,32 @@ import (
 
 // Block struct
 type Block struct {
-	Timestamp    int64
-	Hash         []byte
+	BlockHeader
 	Transactions []*Transaction
-	PrevHash     []byte
-	Nonce        int
-	Height       int
 }
 
-// HashTransactions function
+// HashTransactions function - the merkle root of the block's transactions
 func (b *Block) HashTransactions() []byte {
+	return b.merkleTree().RootNode.Data
+}
+
+// merkleTree function
+func (b *Block) merkleTree() *MerkleTree {
 	var txHashes [][]byte
 
 	for _, tx := range b.Transactions {
 		txHashes = append(txHashes, tx.Serialize())
 	}
-	tree := NewMerkleTree(txHashes)
 
-	return tree.RootNode.Data
+	return NewMerkleTree(txHashes)
 }
 
 // CreateBlock function
-func CreateBlock(txs []*Transaction, prevHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), []byte{}, txs, prevHash, 0, height}
+func CreateBlock(txs []*Transaction, prevHash []byte, height, difficulty int) *Block {
+	block := &Block{BlockHeader{BlockVersion, prevHash, nil, time.Now().Unix(), difficulty, 0, height, nil}, txs}
+	block.MerkleRoot = block.HashTransactions()
 
-	pow := NewProof(block)
+	pow := NewProof(&block.BlockHeader)
 	nonce, hash := pow.Run()
 
 	block.Hash = hash[:]
@@ -44,15 +45,15 @@ func CreateBlock(txs []*Transaction, prevHash []byte, height int) *Block {
 
 // Genesis function
 func Genesis(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0, InitialDifficulty)
 }
 
-// Serialize function
+// Serialize function - the header's canonical encoding followed by the transactions
 func (b *Block) Serialize() []byte {
-	var res bytes.Buffer
-	encoder := gob.NewEncoder(&res)
+	res := bytes.NewBuffer(b.BlockHeader.Serialize())
+	encoder := gob.NewEncoder(res)
 
-	err := encoder.Encode(b)
+	err := encoder.Encode(b.Transactions)
 
 	Handle(err)
 
@@ -60,19 +61,26 @@ func (b *Block) Serialize() []byte {
 }
 
 // Deserialize function
-func Deserialize(data []byte) *Block {
-	var block Block
+func Deserialize(data []byte) (*Block, error) {
+	r := bytes.NewReader(data)
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
+	header, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	block := Block{BlockHeader: *header}
 
-	err := decoder.Decode(&block)
+	decoder := gob.NewDecoder(r)
 
-	Handle(err)
+	err = decoder.Decode(&block.Transactions)
+	if err != nil {
+		return nil, err
+	}
 
-	return &block
+	return &block, nil
 }
 
-// Handle function
+// Handle function - only for errors that mean a bug, like failing to encode our own types
 func Handle(err error) {
 	if err != nil {
 		log.Panic(err)
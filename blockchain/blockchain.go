@@ -6,14 +6,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
-	"os"
-	"path/filepath"
-	"runtime"
-	"strings"
-
-	"github.com/dgraph-io/badger"
-	"github.com/dgraph-io/badger/options"
+	"math/big"
 	// network "github.com/jlynch25/golang-blockchain/noise_network"
 )
 
@@ -21,157 +14,274 @@ const (
 	// basePath = "/data/data/com.github.jlynch25.mylib_example/files"
 	// basePath    = "/Internal storage/storage/emulated/0"
 	// basePath    = "/data/user/0/com.github.jlynch25.mylib_example/app_flutter"
-	dbPath      = "/tmp/blocks_%s/"
 	genesisData = "First Transaction from Genesis"
 )
 
+var (
+	// ErrChainNotFound is returned when there is no blockchain to continue
+	ErrChainNotFound = errors.New("No existing blockchain found, create one!")
+	// ErrChainExists is returned when creating a blockchain over an existing one
+	ErrChainExists = errors.New("Blockchain already exists")
+	// ErrChainVersion is returned when continuing a blockchain made by an older version
+	ErrChainVersion = errors.New("Blockchain was made by an older version, create a new one!")
+	// ErrBlockNotFound is returned when a block is not in the database
+	ErrBlockNotFound = errors.New("Block is not found")
+	// ErrTxNotFound is returned when a transaction is not on the main chain
+	ErrTxNotFound = errors.New("Transaction does not exist")
+	// ErrInvalidTransaction is returned when a transaction fails verification
+	ErrInvalidTransaction = errors.New("Invalid Transaction")
+	// ErrNotTipChild is returned when connecting a block whose parent is not the tip
+	ErrNotTipChild = errors.New("Block does not build on the chain tip")
+)
+
 // BlockChain struct
 type BlockChain struct {
 	LastHash []byte
-	Database *badger.DB
+	Store    ChainStore
+	Events   ChainEvents
 }
 
-// DBexists function
-func DBexists(path string) bool {
-	if _, err := os.Stat(path + "/MANIFEST"); os.IsNotExist(err) {
-		return false
-	}
-	return true
+// ContinueBlockChain function - opens the node's chain under basePath
+func ContinueBlockChain(nodeID, basePath string) (*BlockChain, error) {
+	return ContinueBlockChainWithConfig(DefaultConfig(nodeID, basePath))
 }
 
-// ContinueBlockChain function
-func ContinueBlockChain(nodeID, basePath string) *BlockChain {
-	if _, err := os.Stat(basePath + "tmp"); os.IsNotExist(err) {
-		os.Mkdir(basePath+"tmp", 0755)
+// ContinueBlockChainWithConfig function
+func ContinueBlockChainWithConfig(config Config) (*BlockChain, error) {
+	if !storeExists(config) {
+		return nil, ErrChainNotFound
 	}
-	path := fmt.Sprintf(basePath+dbPath, nodeID)
-	if DBexists(path) == false {
-		fmt.Println("No existing blockchain found, create one!")
-		runtime.Goexit()
+
+	store, err := OpenStore(config)
+	if err != nil {
+		return nil, err
 	}
 
 	var lastHash []byte
-
-	opts := badger.DefaultOptions(path)
-	opts.ValueLogLoadingMode = options.FileIO
-	opts.Logger = nil
-
-	db, err := openDB(path, opts)
-	Handle(err)
-
-	err = db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, err = item.ValueCopy(nil)
+	err = store.View(func(txn StoreTx) error {
+		var err error
+		lastHash, err = txn.Get([]byte("lh"))
+		if err == ErrKeyNotFound {
+			return ErrChainNotFound
+		}
 
 		return err
 	})
-	Handle(err)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
 
-	blockchain := BlockChain{lastHash, db}
-	return &blockchain
+	blockchain := BlockChain{LastHash: lastHash, Store: store}
 
-}
+	tip, err := blockchain.GetBlock(lastHash)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	if tip.Version < BlockVersion {
+		store.Close()
+		return nil, ErrChainVersion
+	}
 
-// InitBlockChain  function
-func InitBlockChain(address, nodeID, basePath string) *BlockChain {
-	var lastHash []byte
-	path := fmt.Sprintf(basePath+dbPath, nodeID)
-	fmt.Printf("path: %s\n", path)
-	if DBexists(path) {
-		fmt.Println(" BLockchain already exists")
-		runtime.Goexit()
+	if err := blockchain.buildIndexes(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	if err := (UTXOSet{&blockchain}).repair(); err != nil {
+		store.Close()
+		return nil, err
 	}
 
-	opts := badger.DefaultOptions(path)
-	opts.ValueLogLoadingMode = options.FileIO
-	opts.Logger = nil
+	return &blockchain, nil
+}
 
-	db, err := openDB(path, opts)
-	Handle(err)
+// buildIndexes function - builds the indexes a database written by an older version is missing
+func (chain *BlockChain) buildIndexes() error {
+	indexes := []struct {
+		name    string
+		key     []byte
+		reindex func() error
+	}{
+		{"height", heightIndexKey, chain.ReindexHeights},
+		{"transaction", txIndexKey, chain.ReindexTransactions},
+	}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CoinbaseTx(address, genesisData)
-		genesis := Genesis(cbtx)
-		fmt.Println("Genesis created")
-		err = txn.Set(genesis.Hash, genesis.Serialize())
-		Handle(err)
-		err = txn.Set([]byte("lh"), genesis.Hash)
+	for _, index := range indexes {
+		built, err := chain.indexBuilt(index.key)
+		if err != nil {
+			return err
+		}
+		if built {
+			continue
+		}
 
-		lastHash = genesis.Hash
+		fmt.Printf("Building the %s index\n", index.name)
+		if err := index.reindex(); err != nil {
+			return err
+		}
+	}
 
-		return err
+	return nil
+}
 
-	})
+// InitBlockChain  function - creates the node's chain under basePath
+func InitBlockChain(address, nodeID, basePath string) (*BlockChain, error) {
+	return InitBlockChainWithConfig(address, DefaultConfig(nodeID, basePath))
+}
+
+// InitBlockChainWithConfig function - creates a chain whose genesis block pays address
+func InitBlockChainWithConfig(address string, config Config) (*BlockChain, error) {
+	fmt.Printf("path: %s\n", config.Path)
+	if storeExists(config) {
+		return nil, ErrChainExists
+	}
 
-	Handle(err)
+	cbtx, err := CoinbaseTx(address, genesisData, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	genesis := Genesis(cbtx)
+	fmt.Println("Genesis created")
 
-	blockchain := BlockChain{lastHash, db}
-	return &blockchain
+	return initBlockChain(genesis, config)
 }
 
-// AddBlock function
-func (chain *BlockChain) AddBlock(block *Block) {
-	err := chain.Database.Update(func(txn *badger.Txn) error {
-		if _, err := txn.Get(block.Hash); err == nil {
-			return nil
+// initBlockChain function - creates a chain from its genesis block
+func initBlockChain(genesis *Block, config Config) (*BlockChain, error) {
+	var lastHash []byte
+
+	store, err := OpenStore(config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.Update(func(txn StoreTx) error {
+		if err := storeBlock(txn, genesis, BlockWork(genesis.Difficulty)); err != nil {
+			return err
+		}
+		if err := txn.Set(txIndexKey, []byte{1}); err != nil {
+			return err
+		}
+		if err := txn.Set(heightIndexKey, []byte{1}); err != nil {
+			return err
+		}
+		if err := txn.Set(utxoFormatKey, []byte{1}); err != nil {
+			return err
 		}
 
-		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
-		Handle(err)
+		lastHash = genesis.Hash
 
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, _ := item.ValueCopy(nil)
+		return connectTip(txn, genesis)
+	})
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	blockchain := BlockChain{LastHash: lastHash, Store: store}
+	return &blockchain, nil
+}
+
+// AddBlock function - validates and stores the block, moving the main chain onto it if its branch now has
+// the most accumulated proof of work. Blocks whose parent is unknown are held as orphans until it arrives.
+// Consensus failures are returned as a RuleError.
+func (chain *BlockChain) AddBlock(block *Block) error {
+	if _, err := chain.GetBlock(block.Hash); err == nil {
+		return nil
+	}
 
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		lastBlockData, _ := item.ValueCopy(nil)
+	if err := CheckBlock(block); err != nil {
+		return chain.rejectBlock(block, err)
+	}
 
-		lastBlock := Deserialize(lastBlockData)
+	err := chain.checkBlockContext(block)
+	if err == ErrOrphanBlock {
+		fmt.Printf("Holding orphan block %x until its parent arrives\n", block.Hash)
+		return chain.addOrphan(block)
+	}
+	if err != nil {
+		return chain.rejectBlock(block, err)
+	}
 
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("lh"), block.Hash)
-			Handle(err)
-			chain.LastHash = block.Hash
+	parentWork := big.NewInt(0)
+	if len(block.PrevHash) > 0 {
+		parentWork, err = chain.GetChainWork(block.PrevHash)
+		if err != nil {
+			return err
 		}
-		fmt.Printf("chain.GetBestHeight() %d\n", chain.GetBestHeight())
+	}
+	work := new(big.Int).Add(parentWork, BlockWork(block.Difficulty))
 
-		return nil
+	err = chain.Store.Update(func(txn StoreTx) error {
+		return storeBlock(txn, block, work)
 	})
-	Handle(err)
+	if err != nil {
+		return err
+	}
+
+	tipWork, err := chain.GetChainWork(chain.LastHash)
+	if err != nil {
+		return err
+	}
+
+	if work.Cmp(tipWork) > 0 {
+		if err := chain.reorganize(block); err != nil {
+			return err
+		}
+	}
+
+	return chain.connectOrphans(block.Hash)
+}
+
+// storeBlock function - writes the block, its header and the total work up to it
+func storeBlock(txn StoreTx, block *Block, work *big.Int) error {
+	if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+		return err
+	}
+	if err := txn.Set(append(headerPrefix, block.Hash...), block.BlockHeader.Serialize()); err != nil {
+		return err
+	}
+
+	return txn.Set(append(workPrefix, block.Hash...), work.Bytes())
 }
 
 // GetBlock functiopn
 func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		if item, err := txn.Get(blockHash); err != nil {
-			return errors.New("Block is not found")
-		} else {
-			blockData, _ := item.ValueCopy(nil)
+	err := chain.Store.View(func(txn StoreTx) error {
+		blockData, err := txn.Get(blockHash)
+		if err == ErrKeyNotFound {
+			return ErrBlockNotFound
+		}
+		if err != nil {
+			return err
+		}
 
-			block = *Deserialize(blockData)
+		decoded, err := Deserialize(blockData)
+		if err != nil {
+			return err
 		}
+		block = *decoded
+
 		return nil
 	})
-	if err != nil {
-		return block, err
-	}
 
-	return block, nil
+	return block, err
 }
 
 // GetBlockHashes function
-func (chain *BlockChain) GetBlockHashes() [][]byte {
+func (chain *BlockChain) GetBlockHashes() ([][]byte, error) {
 	var blocks [][]byte
 
 	iter := chain.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 
 		blocks = append(blocks, block.Hash)
 
@@ -180,189 +290,199 @@ func (chain *BlockChain) GetBlockHashes() [][]byte {
 		}
 	}
 
-	return blocks
+	return blocks, nil
 }
 
 // GetBestHeight function
-func (chain *BlockChain) GetBestHeight() int {
-	var lastBlock Block
-
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, _ := item.ValueCopy(nil)
+func (chain *BlockChain) GetBestHeight() (int, error) {
+	lastBlock, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return 0, err
+	}
 
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		lastBlockData, _ := item.ValueCopy(nil)
+	return lastBlock.Height, nil
+}
 
-		lastBlock = *Deserialize(lastBlockData)
+// SpendContext function - the height and median time past a transaction spending from the tip is checked at
+func (chain *BlockChain) SpendContext() (int, int64, error) {
+	tip, err := chain.GetHeader(chain.LastHash)
+	if err != nil {
+		return 0, 0, err
+	}
 
-		return nil
-	})
-	Handle(err)
+	medianTime, err := chain.MedianTimePast(tip)
+	if err != nil {
+		return 0, 0, err
+	}
 
-	return lastBlock.Height
+	return tip.Height + 1, medianTime, nil
 }
 
-// MineBlock function
-func (chain *BlockChain) MineBlock(transactions []*Transaction) *Block {
-	var lastHash []byte
-	var lastHeight int
+// MineBlock function - the first transaction must be the coinbase. The others are checked as a block would be,
+// so they may spend each other's outputs.
+func (chain *BlockChain) MineBlock(transactions []*Transaction) (*Block, error) {
+	if len(transactions) == 0 || !transactions[0].IsCoinbase() {
+		return nil, ErrFirstTxNotCoinbase
+	}
 
-	for _, tx := range transactions {
-		if chain.VerifyTransaction(tx) != true {
-			log.Panic("Invalid Transaction")
-		}
+	lastHash := chain.LastHash
+	lastBlock, err := chain.GetBlock(lastHash)
+	if err != nil {
+		return nil, err
 	}
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, err = item.ValueCopy(nil)
+	if err := chain.checkBlockTransactions(&Block{BlockHeader{PrevHash: lastHash, Height: lastBlock.Height + 1}, transactions}); err != nil {
+		return nil, err
+	}
 
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		lastBlockData, _ := item.ValueCopy(nil)
+	difficulty, err := chain.NextDifficulty(&lastBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	newBlock := CreateBlock(transactions, lastHash, lastBlock.Height+1, difficulty)
+
+	if err := chain.ConnectBlock(newBlock); err != nil {
+		return nil, err
+	}
 
-		lastBlock := Deserialize(lastBlockData)
+	return newBlock, nil
+}
 
-		lastHeight = lastBlock.Height
+// ConnectBlock function - makes a block whose parent is the tip the new tip. The block, the UTXO changes with
+// their undo record, the indexes and the tip are written in one transaction, so a crash leaves all or none of
+// them. The block is not validated here.
+func (chain *BlockChain) ConnectBlock(block *Block) error {
+	if !bytes.Equal(block.PrevHash, chain.LastHash) {
+		return ErrNotTipChild
+	}
 
+	parentWork, err := chain.GetChainWork(block.PrevHash)
+	if err != nil {
 		return err
-	})
+	}
+	work := new(big.Int).Add(parentWork, BlockWork(block.Difficulty))
 
-	Handle(err)
+	err = chain.Store.Update(func(txn StoreTx) error {
+		if err := storeBlock(txn, block, work); err != nil {
+			return err
+		}
+		return connectTip(txn, block)
+	})
+	if err != nil {
+		return err
+	}
+	chain.LastHash = block.Hash
 
-	newBlock := CreateBlock(transactions, lastHash, lastHeight+1)
+	if chain.Events.OnBlockConnected != nil {
+		chain.Events.OnBlockConnected(block)
+	}
 
-	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		Handle(err)
-		err = txn.Set([]byte("lh"), newBlock.Hash)
+	return nil
+}
 
-		chain.LastHash = newBlock.Hash
+// FindTransaction function - a main chain transaction, found through the transaction index
+func (chain *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
+	var tx Transaction
 
+	err := chain.Store.View(func(txn StoreTx) error {
+		var err error
+		tx, err = findTransaction(txn, ID)
 		return err
 	})
-	Handle(err)
 
-	return newBlock
+	return tx, err
 }
 
-// FindUTXO function
-func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
-	UTXO := make(map[string]TxOutputs)
-	spentTXOs := make(map[string][]int)
-
-	iter := chain.Iterator()
+// findPrevTransactions function - the transactions whose outputs tx spends, keyed by hex ID
+func (chain *BlockChain) findPrevTransactions(tx *Transaction) (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
 
-	for {
-		block := iter.Next()
-
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for outIdx, out := range tx.Outputs {
-				if spentTXOs[txID] != nil {
-					for _, spentOut := range spentTXOs[txID] {
-						if spentOut == outIdx {
-							continue Outputs
-						}
-					}
-				}
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
-			}
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Inputs {
-					inTxID := hex.EncodeToString(in.ID)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
-				}
-			}
-		}
-		if len(block.PrevHash) == 0 {
-			break
+	for _, in := range tx.Inputs {
+		prevTX, err := chain.FindInputTransaction(in.ID)
+		if err != nil {
+			return nil, err
 		}
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
-	return UTXO
+
+	return prevTXs, nil
 }
 
-// FindTransaction function
-func (chain *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
-	iter := chain.Iterator()
+// SignTransaction function
+func (chain *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
+	prevTXs, err := chain.findPrevTransactions(tx)
+	if err != nil {
+		return err
+	}
 
-	for {
-		block := iter.Next()
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
-		}
+	return tx.Sign(privKey, prevTXs)
+}
 
-		if len(block.PrevHash) == 0 {
-			break
-		}
+// SignMultiSigTransaction function
+func (chain *BlockChain) SignMultiSigTransaction(tx *Transaction, privKey ecdsa.PrivateKey, redeemScript []byte) error {
+	prevTXs, err := chain.findPrevTransactions(tx)
+	if err != nil {
+		return err
 	}
 
-	return Transaction{}, errors.New("Transaction does not exist")
+	return tx.SignMultiSig(privKey, redeemScript, prevTXs)
 }
 
-// SignTransaction function
-func (chain *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
+// CombineMultiSigTransactions function
+func (chain *BlockChain) CombineMultiSigTransactions(redeemScript []byte, partials []*Transaction) (*Transaction, error) {
+	if len(partials) == 0 {
+		return nil, ErrNotEnoughSignatures
+	}
 
-	for _, in := range tx.Inputs {
-		prevTX, err := chain.FindTransaction(in.ID)
-		Handle(err)
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	prevTXs, err := chain.findPrevTransactions(partials[0])
+	if err != nil {
+		return nil, err
 	}
 
-	tx.Sign(privKey, prevTXs)
+	return CombineMultiSig(redeemScript, prevTXs, partials)
 }
 
-// VerifyTransaction function
-func (chain *BlockChain) VerifyTransaction(tx *Transaction) bool {
+// VerifyTransaction function - returns ErrInvalidTransaction if a signature does not check out, ErrLockTime if
+// it cannot go in the next block yet, ErrBadTxOutput or ErrValueOutOfRange if a value is out of range and
+// ErrSpendTooHigh if the outputs pay out more than the inputs bring in
+func (chain *BlockChain) VerifyTransaction(tx *Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
-	prevTXs := make(map[string]Transaction)
 
-	for _, in := range tx.Inputs {
-		prevTX, err := chain.FindTransaction(in.ID)
-		Handle(err)
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	if _, err := tx.OutputValue(); err != nil {
+		return err
 	}
 
-	return tx.Verify(prevTXs)
-}
+	prevTXs, err := chain.findPrevTransactions(tx)
+	if err != nil {
+		return err
+	}
+
+	height, medianTime, err := chain.SpendContext()
+	if err != nil {
+		return err
+	}
+	if !tx.IsFinal(height, medianTime) {
+		return ErrLockTime
+	}
+	if err := tx.Verify(prevTXs); err != nil {
+		return ErrInvalidTransaction
+	}
 
-// retry function
-func retry(dir string, originalOpts badger.Options) (*badger.DB, error) {
-	lockPath := filepath.Join(dir, "LOCK")
-	if err := os.Remove(lockPath); err != nil {
-		return nil, fmt.Errorf(`removing "LOCK": %s`, err)
+	fee, err := tx.Fee(prevTXs)
+	if err != nil {
+		return err
 	}
-	retryOpts := originalOpts
-	retryOpts.Truncate = true
-	db, err := badger.Open(retryOpts)
-	return db, err
+	if fee < 0 {
+		return ErrSpendTooHigh
+	}
+
+	return nil
 }
 
-// openDB function
-func openDB(dir string, opts badger.Options) (*badger.DB, error) {
-	if db, err := badger.Open(opts); err != nil {
-		if strings.Contains(err.Error(), "LOCK") {
-			if db, err := retry(dir, opts); err == nil {
-				log.Println("database unlocked, value log truncated")
-				return db, nil
-			}
-			log.Println("could not unlock database:", err)
-		}
-		return nil, err
-	} else {
-		return db, nil
-	}
+// Close function
+func (chain *BlockChain) Close() error {
+	return chain.Store.Close()
 }
@@ -0,0 +1,219 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BootstrapVersion is the version of the bootstrap file format written by ExportChain
+const BootstrapVersion = 1
+
+// bootstrapMagic starts every bootstrap file. It is followed by the version, then each main chain block from
+// genesis up as a 4 byte length and the serialized block, all big endian.
+var bootstrapMagic = []byte("FYPCHAIN")
+
+var (
+	// ErrBadBootstrap is returned for a file that is not a bootstrap file or is cut short
+	ErrBadBootstrap = errors.New("Not a valid bootstrap file")
+	// ErrBootstrapVersion is returned for a bootstrap file written in a version we cannot read
+	ErrBootstrapVersion = errors.New("Unsupported bootstrap file version")
+	// ErrGenesisMismatch is returned when importing a chain with a different genesis block
+	ErrGenesisMismatch = errors.New("Genesis block does not match the chain")
+)
+
+// ExportChain function - writes the main chain to w in the bootstrap format, returning the number of blocks
+func (chain *BlockChain) ExportChain(w io.Writer) (int, error) {
+	var version [4]byte
+	binary.BigEndian.PutUint32(version[:], BootstrapVersion)
+
+	if _, err := w.Write(append(append([]byte{}, bootstrapMagic...), version[:]...)); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	iter := chain.ForwardIterator(0)
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return count, err
+		}
+		if block == nil {
+			return count, nil
+		}
+
+		if err := writeChunk(w, block.Serialize()); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// BootstrapReader struct - reads the blocks out of a bootstrap file
+type BootstrapReader struct {
+	r       *bufio.Reader
+	Version int
+}
+
+// NewBootstrapReader function - checks the file's magic and version
+func NewBootstrapReader(r io.Reader) (*BootstrapReader, error) {
+	br := bufio.NewReader(r)
+
+	head := make([]byte, len(bootstrapMagic)+4)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, ErrBadBootstrap
+	}
+	if !bytes.Equal(head[:len(bootstrapMagic)], bootstrapMagic) {
+		return nil, ErrBadBootstrap
+	}
+
+	version := int(binary.BigEndian.Uint32(head[len(bootstrapMagic):]))
+	if version != BootstrapVersion {
+		return nil, fmt.Errorf("%w: %d", ErrBootstrapVersion, version)
+	}
+
+	return &BootstrapReader{br, version}, nil
+}
+
+// Next function - returns nil at the end of the file
+func (reader *BootstrapReader) Next() (*Block, error) {
+	data, err := readChunk(reader.r, MaxBlockSize)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBadBootstrap, err)
+	}
+
+	block, err := Deserialize(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBadBootstrap, err)
+	}
+
+	return block, nil
+}
+
+// writeChunk function - data with its length in front as 4 bytes big endian
+func writeChunk(w io.Writer, data []byte) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+
+	_, err := w.Write(append(length[:], data...))
+	return err
+}
+
+// readChunk function - reads what writeChunk wrote. Returns io.EOF only if r was already at its end.
+func readChunk(r io.Reader, max int) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(length[:])
+	if size > uint32(max) {
+		return nil, fmt.Errorf("chunk of %d bytes is too big", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// ImportChain function - adds the blocks in a bootstrap file to the chain in config, creating it from the file's
+// genesis block if there is none. Every block is validated as one from a peer would be, and the indexes and
+// UTXO set are kept up as it is connected. Blocks the chain already has are skipped. Returns the chain and the
+// number of blocks added.
+func ImportChain(r io.Reader, config Config) (*BlockChain, int, error) {
+	reader, err := NewBootstrapReader(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	genesis, err := reader.Next()
+	if err != nil {
+		return nil, 0, err
+	}
+	if genesis == nil || genesis.Height != 0 || len(genesis.PrevHash) != 0 {
+		return nil, 0, ErrBadBootstrap
+	}
+
+	chain, count, err := openImportChain(genesis, config)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for {
+		block, err := reader.Next()
+		if err != nil {
+			chain.Close()
+			return nil, count, err
+		}
+		if block == nil {
+			return chain, count, nil
+		}
+
+		added, err := chain.importBlock(block)
+		if err != nil {
+			chain.Close()
+			return nil, count, fmt.Errorf("block %d: %w", block.Height, err)
+		}
+		if added {
+			count++
+		}
+	}
+}
+
+// openImportChain function - the chain in config if its genesis block matches, otherwise a new chain from genesis
+func openImportChain(genesis *Block, config Config) (*BlockChain, int, error) {
+	if !storeExists(config) {
+		if err := CheckBlock(genesis); err != nil {
+			return nil, 0, err
+		}
+		chain, err := initBlockChain(genesis, config)
+		return chain, 1, err
+	}
+
+	chain, err := ContinueBlockChainWithConfig(config)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hash, err := chain.GetBlockHash(0)
+	if err != nil {
+		chain.Close()
+		return nil, 0, err
+	}
+	if !bytes.Equal(hash, genesis.Hash) {
+		chain.Close()
+		return nil, 0, ErrGenesisMismatch
+	}
+
+	return chain, 0, nil
+}
+
+// importBlock function - the blocks must come in order, so one whose parent's body is missing is refused rather
+// than held as an orphan. Only a block we already have the body of is skipped, its header alone may be known from
+// headers first sync.
+func (chain *BlockChain) importBlock(block *Block) (bool, error) {
+	if _, err := chain.GetBlock(block.Hash); err == nil {
+		return false, nil
+	}
+	if _, err := chain.GetBlock(block.PrevHash); err != nil {
+		return false, fmt.Errorf("%w: blocks are out of order", ErrBadBootstrap)
+	}
+
+	if err := chain.AddBlock(block); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
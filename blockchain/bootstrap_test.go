@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testChainBlocks function - a chain of four blocks, the third spending the genesis coinbase
+func testChainBlocks(t *testing.T) (*BlockChain, []*Block) {
+	t.Helper()
+
+	chain, w := newTestChain(t)
+	other := newTestWallet(t)
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := genesis.Transactions[0]
+
+	blocks := []*Block{&genesis, mineTestBlock(t, chain, w)}
+	blocks = append(blocks, mineTestBlock(t, chain, w,
+		spendTestTx(t, w, coins, 0, testOutput(t, coins.Outputs[0].Value, other))))
+	blocks = append(blocks, mineTestBlock(t, chain, other))
+
+	return chain, blocks
+}
+
+func TestExportImportChain(t *testing.T) {
+	chain, blocks := testChainBlocks(t)
+
+	var buff bytes.Buffer
+	exported, err := chain.ExportChain(&buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported != len(blocks) {
+		t.Fatalf("expected %d blocks exported, got %d", len(blocks), exported)
+	}
+
+	imported, count, err := ImportChain(&buff, Config{Backend: MemoryBackend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imported.Close()
+
+	if count != len(blocks) {
+		t.Fatalf("expected %d blocks imported, got %d", len(blocks), count)
+	}
+	if !bytes.Equal(imported.LastHash, chain.LastHash) {
+		t.Fatal("the imported chain has a different tip")
+	}
+
+	for _, tx := range blocks[2].Transactions {
+		if _, err := imported.FindTransaction(tx.ID); err != nil {
+			t.Fatalf("transaction %x is not indexed: %v", tx.ID, err)
+		}
+	}
+	for _, out := range []struct {
+		txID []byte
+		vout int
+	}{{blocks[0].Transactions[0].ID, 0}, {blocks[2].Transactions[1].ID, 0}} {
+		want, err := UTXOSet{chain}.FindEntry(out.txID, out.vout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := UTXOSet{imported}.FindEntry(out.txID, out.vout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (want == nil) != (got == nil) {
+			t.Fatalf("output %x:%d is unspent in one chain only", out.txID, out.vout)
+		}
+	}
+}
+
+func TestImportBlock(t *testing.T) {
+	chain, blocks := testChainBlocks(t)
+	synced := newTestBranch(t, chain)
+
+	// a node that synced the headers first knows every header but has none of the bodies
+	var headers []*BlockHeader
+	for _, block := range blocks[1:] {
+		headers = append(headers, block.Header())
+	}
+	if err := synced.AddHeaders(headers); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := synced.importBlock(blocks[2]); !errors.Is(err, ErrBadBootstrap) {
+		t.Fatalf("expected a block before its parent to give %v, got %v", ErrBadBootstrap, err)
+	}
+
+	for _, block := range blocks {
+		added, err := synced.importBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != (block.Height > 0) {
+			t.Fatalf("block %d: expected added to be %t", block.Height, block.Height > 0)
+		}
+	}
+
+	height, err := synced.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != len(blocks)-1 || !bytes.Equal(synced.LastHash, chain.LastHash) {
+		t.Fatalf("expected the blocks to be connected up to height %d, got %d", len(blocks)-1, height)
+	}
+}
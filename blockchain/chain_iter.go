@@ -1,35 +1,73 @@
 package blockchain
 
-import "github.com/dgraph-io/badger"
+import "fmt"
 
 // BlockChainIterator struct
 type BlockChainIterator struct {
 	CurrentHash []byte
-	Database    *badger.DB
+	Store       ChainStore
 }
 
 // Iterator function
 func (chain *BlockChain) Iterator() *BlockChainIterator {
-	iter := &BlockChainIterator{chain.LastHash, chain.Database}
+	iter := &BlockChainIterator{chain.LastHash, chain.Store}
 
 	return iter
 }
 
 // Next function
-func (iter *BlockChainIterator) Next() *Block {
+func (iter *BlockChainIterator) Next() (*Block, error) {
 	var block *Block
 
-	err := iter.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(iter.CurrentHash)
-		Handle(err)
-		encodedBlock, err := item.ValueCopy(nil)
-		block = Deserialize(encodedBlock)
+	err := iter.Store.View(func(txn StoreTx) error {
+		encodedBlock, err := txn.Get(iter.CurrentHash)
+		if err == ErrKeyNotFound {
+			return ErrBlockNotFound
+		}
+		if err != nil {
+			return err
+		}
+		block, err = Deserialize(encodedBlock)
 
 		return err
 	})
-	Handle(err)
+	if err != nil {
+		return nil, err
+	}
 
 	iter.CurrentHash = block.PrevHash
 
-	return block
+	return block, nil
+}
+
+// BlockChainForwardIterator struct - walks the main chain up from a height to the tip through the height index
+type BlockChainForwardIterator struct {
+	Height int
+	chain  *BlockChain
+}
+
+// ForwardIterator function
+func (chain *BlockChain) ForwardIterator(from int) *BlockChainForwardIterator {
+	return &BlockChainForwardIterator{from, chain}
+}
+
+// Next function - returns nil once past the tip. A block missing below the tip, as those before an unverified
+// UTXO snapshot are, is returned as ErrBlockNotFound.
+func (iter *BlockChainForwardIterator) Next() (*Block, error) {
+	hash, err := iter.chain.GetBlockHash(iter.Height)
+	if err == ErrBlockNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := iter.chain.GetBlock(hash)
+	if err != nil {
+		return nil, fmt.Errorf("%w: main chain block at height %d", err, iter.Height)
+	}
+
+	iter.Height++
+
+	return &block, nil
 }
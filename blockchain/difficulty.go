@@ -0,0 +1,100 @@
+package blockchain
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	// InitialDifficulty is the difficulty of the genesis block and of every block before the first retarget
+	InitialDifficulty = 14
+	// MinDifficulty is the lowest difficulty a retarget can fall to
+	MinDifficulty = 1
+	// MaxDifficulty is the highest difficulty a retarget can climb to
+	MaxDifficulty = 255
+	// TargetBlockTime is the number of seconds we aim for between blocks
+	TargetBlockTime = 30
+	// RetargetInterval is the number of blocks in each retarget window
+	RetargetInterval = 10
+	// maxRetargetStep limits how many bits the difficulty may move in one retarget (a factor of 4, like bitcoin)
+	maxRetargetStep = 2
+)
+
+// ErrBadDifficulty is returned when a block declares a difficulty that does not match the expected retarget
+var ErrBadDifficulty = errors.New("Block difficulty does not match the expected retarget")
+
+// RetargetDifficulty function - works out the difficulty of the next block from the timestamps of the
+// first and last blocks in a retarget window. Difficulty is the number of leading zero bits of the target,
+// so the change is the base 2 log of the ratio between the expected and the actual time span.
+func RetargetDifficulty(difficulty int, firstTimestamp, lastTimestamp int64) int {
+	expected := float64(TargetBlockTime * (RetargetInterval - 1))
+	actual := float64(lastTimestamp - firstTimestamp)
+	if actual < 1 {
+		actual = 1
+	}
+
+	step := int(math.Round(math.Log2(expected / actual)))
+	if step > maxRetargetStep {
+		step = maxRetargetStep
+	} else if step < -maxRetargetStep {
+		step = -maxRetargetStep
+	}
+
+	next := difficulty + step
+	if next < MinDifficulty {
+		next = MinDifficulty
+	} else if next > MaxDifficulty {
+		next = MaxDifficulty
+	}
+
+	return next
+}
+
+// NextDifficulty function - returns the difficulty a block built on top of prev must declare
+func (chain *BlockChain) NextDifficulty(prev *Block) (int, error) {
+	return chain.nextDifficulty(prev.Header())
+}
+
+// nextDifficulty function - walks back through headers, so it works before the block bodies have arrived
+func (chain *BlockChain) nextDifficulty(prev *BlockHeader) (int, error) {
+	height := prev.Height + 1
+	if height%RetargetInterval != 0 {
+		return prev.Difficulty, nil
+	}
+
+	first := prev
+	for i := 0; i < RetargetInterval-1; i++ {
+		header, err := chain.GetHeader(first.PrevHash)
+		if err != nil {
+			return 0, err
+		}
+		first = header
+	}
+
+	return RetargetDifficulty(prev.Difficulty, first.Timestamp, prev.Timestamp), nil
+}
+
+// CheckDifficulty function - rejects blocks whose declared difficulty is not the expected retarget
+func (chain *BlockChain) CheckDifficulty(block *Block) error {
+	if len(block.PrevHash) == 0 {
+		if block.Difficulty != InitialDifficulty {
+			return ErrBadDifficulty
+		}
+		return nil
+	}
+
+	prev, err := chain.GetBlock(block.PrevHash)
+	if err != nil {
+		return err
+	}
+
+	expected, err := chain.NextDifficulty(&prev)
+	if err != nil {
+		return err
+	}
+	if block.Difficulty != expected {
+		return ErrBadDifficulty
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRetargetDifficulty(t *testing.T) {
+	expected := int64(TargetBlockTime * (RetargetInterval - 1))
+
+	tests := []struct {
+		name       string
+		difficulty int
+		span       int64
+		next       int
+	}{
+		{"on target", 20, expected, 20},
+		{"twice as fast", 20, expected / 2, 21},
+		{"four times as fast", 20, expected / 4, 22},
+		{"far too fast is held to the step", 20, 1, 20 + maxRetargetStep},
+		{"timestamps going backwards", 20, -expected, 20 + maxRetargetStep},
+		{"twice as slow", 20, expected * 2, 19},
+		{"far too slow is held to the step", 20, expected * 100, 20 - maxRetargetStep},
+		{"not below MinDifficulty", MinDifficulty, expected * 4, MinDifficulty},
+		{"not above MaxDifficulty", MaxDifficulty, expected / 4, MaxDifficulty},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if next := RetargetDifficulty(test.difficulty, 1000, 1000+test.span); next != test.next {
+				t.Fatalf("expected %d, got %d", test.next, next)
+			}
+		})
+	}
+}
+
+func TestNextDifficulty(t *testing.T) {
+	chain, w := newTestChain(t)
+
+	// the blocks come far quicker than TargetBlockTime, so the first retarget climbs as far as it may
+	var tip *Block
+	for height := 1; height < RetargetInterval; height++ {
+		tip = mineTestBlock(t, chain, w)
+		if tip.Difficulty != InitialDifficulty {
+			t.Fatalf("block %d retargeted to %d before the end of the window", height, tip.Difficulty)
+		}
+	}
+
+	next, err := chain.NextDifficulty(tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != InitialDifficulty+maxRetargetStep {
+		t.Fatalf("expected %d, got %d", InitialDifficulty+maxRetargetStep, next)
+	}
+
+	if block := mineTestBlock(t, chain, w); block.Difficulty != next {
+		t.Fatalf("mined at %d, expected %d", block.Difficulty, next)
+	}
+}
+
+func TestCheckDifficulty(t *testing.T) {
+	chain, w := newTestChain(t)
+	tip := mineTestBlock(t, chain, w)
+
+	cbTx, err := CoinbaseTx(string(w.Address()), "", tip.Height+1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chain.CheckDifficulty(CreateBlock([]*Transaction{cbTx}, tip.Hash, tip.Height+1, InitialDifficulty)); err != nil {
+		t.Fatalf("expected the block to pass, got %v", err)
+	}
+
+	block := CreateBlock([]*Transaction{cbTx}, tip.Hash, tip.Height+1, InitialDifficulty-1)
+	if err := chain.CheckDifficulty(block); !errors.Is(err, ErrBadDifficulty) {
+		t.Fatalf("expected %v, got %v", ErrBadDifficulty, err)
+	}
+}
@@ -0,0 +1,511 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// BlockVersion is the header version of the blocks we create. Version 2 blocks lock outputs with scripts.
+	BlockVersion = 2
+	// MaxHeadersPerMsg is the most headers sent in answer to one getheaders request
+	MaxHeadersPerMsg = 2000
+	// hashLength is the length of a block hash or merkle root
+	hashLength = sha256.Size
+)
+
+var (
+	headerPrefix  = []byte("hdr-")
+	bestHeaderKey = []byte("hh")
+)
+
+// ErrMalformedHeader is returned for a header that cannot be decoded or has hashes of the wrong length
+var ErrMalformedHeader = errors.New("Block header is malformed")
+
+// ErrOldBlockVersion is returned for a block made before outputs were locked with scripts
+var ErrOldBlockVersion = errors.New("Block version is no longer supported")
+
+// BlockHeader struct - everything about a block but its transactions, enough to check its proof of work.
+// Hash is not part of the encoding, it is worked out from the other fields.
+type BlockHeader struct {
+	Version    int
+	PrevHash   []byte
+	MerkleRoot []byte
+	Timestamp  int64
+	Difficulty int
+	Nonce      int
+	Height     int
+	Hash       []byte
+}
+
+// Header function
+func (b *Block) Header() *BlockHeader {
+	header := b.BlockHeader
+
+	return &header
+}
+
+// Serialize function - the canonical encoding the block hash is taken over: the version, then each hash with a
+// one byte length in front, then the timestamp, difficulty, nonce and height, all big endian
+func (h *BlockHeader) Serialize() []byte {
+	buff := new(bytes.Buffer)
+
+	binary.Write(buff, binary.BigEndian, uint32(h.Version)) // writing to a bytes.Buffer never returns an error
+	for _, hash := range [][]byte{h.PrevHash, h.MerkleRoot} {
+		buff.WriteByte(byte(len(hash)))
+		buff.Write(hash)
+	}
+	for _, field := range []int64{h.Timestamp, int64(h.Difficulty), int64(h.Nonce), int64(h.Height)} {
+		binary.Write(buff, binary.BigEndian, field)
+	}
+
+	return buff.Bytes()
+}
+
+// ComputeHash function
+func (h *BlockHeader) ComputeHash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+
+	return hash[:]
+}
+
+// DeserializeHeader function
+func DeserializeHeader(data []byte) (*BlockHeader, error) {
+	r := bytes.NewReader(data)
+
+	header, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, ErrMalformedHeader
+	}
+
+	return header, nil
+}
+
+// readHeader function - reads one encoded header and works out its hash
+func readHeader(r *bytes.Reader) (*BlockHeader, error) {
+	var header BlockHeader
+	var version uint32
+
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, ErrMalformedHeader
+	}
+	header.Version = int(version)
+
+	for _, hash := range []*[]byte{&header.PrevHash, &header.MerkleRoot} {
+		length, err := r.ReadByte()
+		if err != nil || int(length) > r.Len() {
+			return nil, ErrMalformedHeader
+		}
+		if length > 0 {
+			*hash = make([]byte, length)
+			r.Read(*hash)
+		}
+	}
+
+	var fields [4]int64
+	if err := binary.Read(r, binary.BigEndian, &fields); err != nil {
+		return nil, ErrMalformedHeader
+	}
+	header.Timestamp = fields[0]
+	header.Difficulty = int(fields[1])
+	header.Nonce = int(fields[2])
+	header.Height = int(fields[3])
+
+	header.Hash = header.ComputeHash()
+
+	return &header, nil
+}
+
+// String function
+func (h *BlockHeader) String() string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("Hash:        %x", h.Hash))
+	lines = append(lines, fmt.Sprintf("Version:     %d", h.Version))
+	lines = append(lines, fmt.Sprintf("Prev. hash:  %x", h.PrevHash))
+	lines = append(lines, fmt.Sprintf("Merkle root: %x", h.MerkleRoot))
+	lines = append(lines, fmt.Sprintf("Timestamp:   %d", h.Timestamp))
+	lines = append(lines, fmt.Sprintf("Difficulty:  %d", h.Difficulty))
+	lines = append(lines, fmt.Sprintf("Nonce:       %d", h.Nonce))
+	lines = append(lines, fmt.Sprintf("Height:      %d", h.Height))
+
+	return strings.Join(lines, "\n")
+}
+
+// CheckHeader function - checks that need nothing but the header itself
+func CheckHeader(header *BlockHeader) error {
+	if (len(header.PrevHash) != 0 && len(header.PrevHash) != hashLength) || len(header.MerkleRoot) != hashLength {
+		return ruleError(ErrMalformedHeader, "block %x", header.Hash)
+	}
+
+	if header.Version < BlockVersion {
+		return ruleError(ErrOldBlockVersion, "version %d", header.Version)
+	}
+
+	if header.Difficulty < MinDifficulty || header.Difficulty > MaxDifficulty {
+		return ruleError(ErrBadProofOfWork, "difficulty %d is out of range", header.Difficulty)
+	}
+
+	if !bytes.Equal(header.ComputeHash(), header.Hash) {
+		return ruleError(ErrBadBlockHash, "block %x", header.Hash)
+	}
+	if !NewProof(header).Validate() {
+		return ruleError(ErrBadProofOfWork, "block %x", header.Hash)
+	}
+
+	if header.Timestamp > time.Now().Unix()+MaxFutureBlockTime {
+		return ruleError(ErrTimeTooNew, "timestamp %d", header.Timestamp)
+	}
+
+	return nil
+}
+
+// checkHeaderContext function - checks the header against its parent header
+func (chain *BlockChain) checkHeaderContext(header *BlockHeader) error {
+	if len(header.PrevHash) == 0 {
+		if header.Height != 0 {
+			return ruleError(ErrBadHeight, "genesis block at height %d", header.Height)
+		}
+		if header.Difficulty != InitialDifficulty {
+			return ruleError(ErrBadDifficulty, "difficulty %d", header.Difficulty)
+		}
+		return nil
+	}
+
+	parent, err := chain.GetHeader(header.PrevHash)
+	if err == ErrBlockNotFound {
+		return ErrOrphanBlock
+	}
+	if err != nil {
+		return err
+	}
+
+	if header.Height != parent.Height+1 {
+		return ruleError(ErrBadHeight, "height %d on parent at height %d", header.Height, parent.Height)
+	}
+
+	expected, err := chain.nextDifficulty(parent)
+	if err != nil {
+		return err
+	}
+	if header.Difficulty != expected {
+		return ruleError(ErrBadDifficulty, "difficulty %d, expected %d", header.Difficulty, expected)
+	}
+
+	return nil
+}
+
+// GetHeader function - every stored block has its header stored alongside, as do blocks we have only been
+// sent the header of
+func (chain *BlockChain) GetHeader(blockHash []byte) (*BlockHeader, error) {
+	var header *BlockHeader
+
+	err := chain.Store.View(func(txn StoreTx) error {
+		headerData, err := txn.Get(append(headerPrefix, blockHash...))
+		if err == ErrKeyNotFound {
+			return ErrBlockNotFound
+		}
+		if err != nil {
+			return err
+		}
+		header, err = DeserializeHeader(headerData)
+
+		return err
+	})
+
+	return header, err
+}
+
+// AddHeaders function - validates headers ahead of their block bodies and stores them, keeping track of the
+// header chain with the most work. Headers must come parent first; an unknown parent gives ErrOrphanBlock.
+func (chain *BlockChain) AddHeaders(headers []*BlockHeader) error {
+	for _, header := range headers {
+		if _, err := chain.GetHeader(header.Hash); err == nil {
+			continue
+		}
+
+		if err := CheckHeader(header); err != nil {
+			return err
+		}
+		if err := chain.checkHeaderContext(header); err != nil {
+			return err
+		}
+
+		parentWork := big.NewInt(0)
+		if len(header.PrevHash) > 0 {
+			var err error
+			parentWork, err = chain.GetChainWork(header.PrevHash)
+			if err != nil {
+				return err
+			}
+		}
+		work := new(big.Int).Add(parentWork, BlockWork(header.Difficulty))
+
+		best, err := chain.BestHeader()
+		if err != nil {
+			return err
+		}
+		bestWork, err := chain.GetChainWork(best.Hash)
+		if err != nil {
+			return err
+		}
+
+		err = chain.Store.Update(func(txn StoreTx) error {
+			if err := txn.Set(append(headerPrefix, header.Hash...), header.Serialize()); err != nil {
+				return err
+			}
+			if err := txn.Set(append(workPrefix, header.Hash...), work.Bytes()); err != nil {
+				return err
+			}
+			if work.Cmp(bestWork) > 0 {
+				return txn.Set(bestHeaderKey, header.Hash)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BestHeader function - the tip of whichever has more work, the best header chain or the main chain
+func (chain *BlockChain) BestHeader() (*BlockHeader, error) {
+	tip := chain.LastHash
+
+	var headerTip []byte
+	err := chain.Store.View(func(txn StoreTx) error {
+		var err error
+		headerTip, err = txn.Get(bestHeaderKey)
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if headerTip != nil {
+		headerWork, err := chain.GetChainWork(headerTip)
+		if err != nil {
+			return nil, err
+		}
+		tipWork, err := chain.GetChainWork(tip)
+		if err != nil {
+			return nil, err
+		}
+		if headerWork.Cmp(tipWork) > 0 {
+			tip = headerTip
+		}
+	}
+
+	return chain.GetHeader(tip)
+}
+
+// invalidateHeader function - forgets the header of a block that broke a rule so its body is not fetched again
+func (chain *BlockChain) invalidateHeader(blockHash []byte) error {
+	return chain.Store.Update(func(txn StoreTx) error {
+		key := append(headerPrefix, blockHash...)
+		if _, err := txn.Get(key); err == ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// the best header chain may run through it, so fall back to the main chain until new headers arrive
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+		return txn.Delete(bestHeaderKey)
+	})
+}
+
+// headerNotAtFault lists the rule errors that say nothing about the header. A body that does not match it, or
+// repeats transactions, which leaves the merkle root unchanged, may be a peer's mutation of a valid block.
+// A block from the future may be valid once our clock catches up.
+var headerNotAtFault = []error{ErrBadBlockHash, ErrBadMerkleRoot, ErrDuplicateTx, ErrBlockTooBig, ErrTimeTooNew}
+
+// rejectBlock function - invalidates the header of a block that broke a rule and passes the error on,
+// unless the error is in headerNotAtFault
+func (chain *BlockChain) rejectBlock(block *Block, err error) error {
+	var ruleErr RuleError
+	if !errors.As(err, &ruleErr) {
+		return err
+	}
+	for _, notAtFault := range headerNotAtFault {
+		if errors.Is(err, notAtFault) {
+			return err
+		}
+	}
+
+	if err := chain.invalidateHeader(block.Hash); err != nil {
+		return err
+	}
+
+	return err
+}
+
+// BlockLocator function - hashes from the best header back to genesis, one apart for the first ten then
+// doubling the gap each time, so a peer can find where our chains split in a single round trip
+func (chain *BlockChain) BlockLocator() ([][]byte, error) {
+	var locator [][]byte
+
+	header, err := chain.BestHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	step := 1
+	for {
+		locator = append(locator, header.Hash)
+		if len(header.PrevHash) == 0 {
+			break
+		}
+		if len(locator) >= 10 {
+			step *= 2
+		}
+
+		for i := 0; i < step && len(header.PrevHash) > 0; i++ {
+			header, err = chain.GetHeader(header.PrevHash)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return locator, nil
+}
+
+// LocateHeaders function - the main chain headers after the first locator hash on our main chain, oldest first,
+// up to max of them and stopping at stopHash. With no locator hash on the main chain it starts from genesis.
+func (chain *BlockChain) LocateHeaders(locator [][]byte, stopHash []byte, max int) ([]*BlockHeader, error) {
+	start := 0
+	for _, hash := range locator {
+		onMainChain, height, err := chain.mainChainHeight(hash)
+		if err != nil {
+			return nil, err
+		}
+		if onMainChain {
+			start = height + 1
+			break
+		}
+	}
+
+	var headers []*BlockHeader
+	for height := start; len(headers) < max; height++ {
+		hash, err := chain.GetBlockHash(height)
+		if err == ErrBlockNotFound {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		header, err := chain.GetHeader(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		headers = append(headers, header)
+		if bytes.Equal(hash, stopHash) {
+			break
+		}
+	}
+
+	return headers, nil
+}
+
+// mainChainHeight function - whether the block is on the main chain, going by the height index, and its height
+func (chain *BlockChain) mainChainHeight(hash []byte) (bool, int, error) {
+	header, err := chain.GetHeader(hash)
+	if err == ErrBlockNotFound {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	mainHash, err := chain.GetBlockHash(header.Height)
+	if err == ErrBlockNotFound {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	return bytes.Equal(mainHash, hash), header.Height, nil
+}
+
+// MissingBlocks function - hashes on the best header chain whose bodies we do not have, oldest first, up to max.
+// The walk back from the best header ends at the first body we have, at the latest where it meets the main chain.
+func (chain *BlockChain) MissingBlocks(max int) ([][]byte, error) {
+	var missing [][]byte
+
+	header, err := chain.BestHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		have, err := chain.haveBody(header)
+		if err != nil {
+			return nil, err
+		}
+		if have {
+			break
+		}
+		// newest first for now, keeping only the oldest max seen
+		missing = append(missing, header.Hash)
+		if len(missing) > max {
+			missing = missing[1:]
+		}
+
+		if len(header.PrevHash) == 0 {
+			break
+		}
+		header, err = chain.GetHeader(header.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, j := 0, len(missing)-1; i < j; i, j = i+1, j-1 {
+		missing[i], missing[j] = missing[j], missing[i]
+	}
+
+	return missing, nil
+}
+
+// haveBody function - true if the block is stored or waiting in the orphan pool
+func (chain *BlockChain) haveBody(header *BlockHeader) (bool, error) {
+	if _, err := chain.GetBlock(header.Hash); err == nil {
+		return true, nil
+	} else if err != ErrBlockNotFound {
+		return false, err
+	}
+
+	key := append(append(append([]byte{}, orphanPrefix...), header.PrevHash...), header.Hash...)
+	err := chain.Store.View(func(txn StoreTx) error {
+		_, err := txn.Get(key)
+		return err
+	})
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
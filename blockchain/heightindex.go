@@ -0,0 +1,134 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	heightPrefix = []byte("hi-")
+	// set once the height index covers the whole main chain
+	heightIndexKey = []byte("heightindexed")
+)
+
+// ErrBadRange is returned when asking for a range of blocks that ends before it starts
+var ErrBadRange = errors.New("Block range ends before it starts")
+
+// heightKey function - big endian so the keys sort by height
+func heightKey(height int) []byte {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+
+	return append(append([]byte{}, heightPrefix...), h[:]...)
+}
+
+// indexHeight function - makes the block the main chain block at its height
+func indexHeight(txn StoreTx, block *Block) error {
+	return txn.Set(heightKey(block.Height), block.Hash)
+}
+
+// unindexHeight function
+func unindexHeight(txn StoreTx, block *Block) error {
+	return txn.Delete(heightKey(block.Height))
+}
+
+// ReindexHeights function - rebuilds the height index from the main chain
+func (chain *BlockChain) ReindexHeights() error {
+	err := chain.Store.Update(func(txn StoreTx) error {
+		return txn.Delete(heightIndexKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := deleteByPrefix(chain.Store, heightPrefix); err != nil {
+		return err
+	}
+
+	iter := chain.Iterator()
+
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		err = chain.Store.Update(func(txn StoreTx) error {
+			return indexHeight(txn, block)
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return chain.Store.Update(func(txn StoreTx) error {
+		return txn.Set(heightIndexKey, []byte{1})
+	})
+}
+
+// GetBlockHash function - the hash of the main chain block at the height
+func (chain *BlockChain) GetBlockHash(height int) ([]byte, error) {
+	var hash []byte
+
+	err := chain.Store.View(func(txn StoreTx) error {
+		var err error
+		hash, err = txn.Get(heightKey(height))
+		if err == ErrKeyNotFound {
+			return ErrBlockNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		return err
+	})
+
+	return hash, err
+}
+
+// GetBlockByHeight function - the main chain block at the height
+func (chain *BlockChain) GetBlockByHeight(height int) (Block, error) {
+	if height < 0 {
+		return Block{}, ErrBlockNotFound
+	}
+
+	hash, err := chain.GetBlockHash(height)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return chain.GetBlock(hash)
+}
+
+// GetBlockRange function - the main chain blocks from height from to height to, both included, lowest first.
+// The range stops at the tip.
+func (chain *BlockChain) GetBlockRange(from, to int) ([]*Block, error) {
+	if to < from {
+		return nil, ErrBadRange
+	}
+	if from < 0 {
+		from = 0
+	}
+
+	var blocks []*Block
+
+	iter := chain.ForwardIterator(from)
+
+	for iter.Height <= to {
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if block == nil {
+			break
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+// newTestWallet function
+func newTestWallet(t *testing.T) *wallet.Wallet {
+	t.Helper()
+
+	w, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+// newTestChain function - an in-memory chain whose genesis coinbase pays a new wallet. Coinbase outputs can be
+// spent straight away for the length of the test.
+func newTestChain(t *testing.T) (*BlockChain, *wallet.Wallet) {
+	t.Helper()
+
+	maturity := CoinbaseMaturity
+	CoinbaseMaturity = 0
+	t.Cleanup(func() { CoinbaseMaturity = maturity })
+
+	w := newTestWallet(t)
+	chain, err := InitBlockChainWithConfig(string(w.Address()), Config{Backend: MemoryBackend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Close() })
+
+	return chain, w
+}
+
+// newTestBranch function - an in-memory chain sharing chain's genesis block
+func newTestBranch(t *testing.T, chain *BlockChain) *BlockChain {
+	t.Helper()
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch, err := initBlockChain(&genesis, Config{Backend: MemoryBackend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { branch.Close() })
+
+	return branch
+}
+
+// mineTestBlock function - mines the transactions on the tip with a coinbase paying the wallet
+func mineTestBlock(t *testing.T, chain *BlockChain, w *wallet.Wallet, txs ...*Transaction) *Block {
+	t.Helper()
+
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cbTx, err := CoinbaseTx(string(w.Address()), "", height+1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := chain.MineBlock(append([]*Transaction{cbTx}, txs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+// testOutput function
+func testOutput(t *testing.T, value int, w *wallet.Wallet) TxOutput {
+	t.Helper()
+
+	out, err := NewTxOutput(value, string(w.Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return *out
+}
+
+// spendTestTx function - a transaction spending output out of prev, signed by w
+func spendTestTx(t *testing.T, w *wallet.Wallet, prev *Transaction, out int, outputs ...TxOutput) *Transaction {
+	t.Helper()
+
+	tx := &Transaction{
+		Inputs:  []TxInput{{ID: prev.ID, Out: out, Sequence: SequenceFinal}},
+		Outputs: outputs,
+	}
+	tx.ID = tx.Hash()
+	if err := tx.Sign(w.PrivateKey, map[string]Transaction{hex.EncodeToString(prev.ID): *prev}); err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
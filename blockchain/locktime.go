@@ -0,0 +1,233 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+// ErrBadLockTime is returned for a lock time that is negative, or a relative one that does not fit a sequence
+var ErrBadLockTime = errors.New("Lock time is not valid")
+
+// IsFinal function - true once the lock time has passed for a transaction in a block at the height, on a chain
+// whose median time past is medianTime. A lock time of 0 is no lock.
+func (tx *Transaction) IsFinal(height int, medianTime int64) bool {
+	switch {
+	case tx.LockTime == 0:
+		return true
+	case tx.LockTime < LockTimeThreshold:
+		return int64(height) >= tx.LockTime
+	default:
+		return medianTime >= tx.LockTime
+	}
+}
+
+// CheckLockTime function - checks the transaction's lock time and the relative lock time of each input, for a
+// transaction in a block at the height with the median time past of its parent. coinHeights are the heights
+// of the blocks holding the outputs the inputs spend.
+func (chain *BlockChain) CheckLockTime(tx *Transaction, coinHeights []int, height int, medianTime int64) error {
+	if !tx.IsFinal(height, medianTime) {
+		return ruleError(ErrLockTime, "transaction %x is locked until %d", tx.ID, tx.LockTime)
+	}
+
+	for i, in := range tx.Inputs {
+		if in.Sequence&SequenceLockDisabled != 0 {
+			continue
+		}
+		lock := int64(in.Sequence & SequenceLockMask)
+
+		if in.Sequence&SequenceLockSeconds == 0 {
+			if int64(height) < int64(coinHeights[i])+lock {
+				return ruleError(ErrSequenceLock, "transaction %x input %d is locked until height %d", tx.ID, i,
+					int64(coinHeights[i])+lock)
+			}
+			continue
+		}
+
+		// the output's age counts from the median time past of the block before the one holding it
+		coinTime, err := chain.medianTimeAt(coinHeights[i] - 1)
+		if err != nil {
+			return err
+		}
+		if medianTime < coinTime+lock<<SequenceSecondsShift {
+			return ruleError(ErrSequenceLock, "transaction %x input %d is locked until time %d", tx.ID, i,
+				coinTime+lock<<SequenceSecondsShift)
+		}
+	}
+
+	return nil
+}
+
+// medianTimeAt function - the median time past of the main chain block at the height
+func (chain *BlockChain) medianTimeAt(height int) (int64, error) {
+	if height < 0 {
+		height = 0
+	}
+
+	hash, err := chain.GetBlockHash(height)
+	if err != nil {
+		return 0, err
+	}
+	header, err := chain.GetHeader(hash)
+	if err != nil {
+		return 0, err
+	}
+
+	return chain.MedianTimePast(header)
+}
+
+// RelativeLockBlocks function - the input sequence for a relative lock of that many blocks
+func RelativeLockBlocks(blocks int) (uint32, error) {
+	if blocks < 0 || blocks > SequenceLockMask {
+		return 0, ErrBadLockTime
+	}
+
+	return uint32(blocks), nil
+}
+
+// RelativeLockSeconds function - the input sequence for a relative lock of at least that many seconds
+func RelativeLockSeconds(seconds int) (uint32, error) {
+	units := (seconds + 1<<SequenceSecondsShift - 1) >> SequenceSecondsShift
+	if seconds < 0 || units > SequenceLockMask {
+		return 0, ErrBadLockTime
+	}
+
+	return SequenceLockSeconds | uint32(units), nil
+}
+
+// TimeLockScript function - pays the public key hash once the lock has passed. An absolute lock is a height or
+// unix time the spending transaction's lock time has to reach, a relative one is a sequence the spending input
+// has to carry.
+func TimeLockScript(lock int64, relative bool, pubKeyHash []byte) []byte {
+	op := byte(OpCheckLockTimeVerify)
+	if relative {
+		op = OpCheckSequenceVerify
+	}
+
+	script := pushNumber(nil, lock)
+	script = append(script, op, OpDrop)
+
+	return append(script, P2PKHScript(pubKeyHash)...)
+}
+
+// ParseTimeLockScript function - the lock, its kind and the public key hash of a script made by TimeLockScript
+func ParseTimeLockScript(script []byte) (int64, bool, []byte, error) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 8 {
+		return 0, false, nil, ErrBadScript
+	}
+
+	var lock int64
+	switch op := ops[0]; {
+	case op.code >= Op1 && op.code <= Op16:
+		lock = int64(op.code-Op1) + 1
+	case op.code <= OpPushData2:
+		if lock, err = decodeScriptNum(op.data, 5); err != nil {
+			return 0, false, nil, ErrBadScript
+		}
+	default:
+		return 0, false, nil, ErrBadScript
+	}
+
+	relative := ops[1].code == OpCheckSequenceVerify
+	pubKeyHash := ops[5].data
+	if !bytes.Equal(TimeLockScript(lock, relative, pubKeyHash), script) {
+		return 0, false, nil, ErrBadScript
+	}
+
+	return lock, relative, pubKeyHash, nil
+}
+
+// checkLock function - an absolute lock must not be negative, a relative one must be a sequence with a lock
+func checkLock(lock int64, relative bool) error {
+	if lock < 0 {
+		return ErrBadLockTime
+	}
+	if relative && (lock > SequenceFinal || lock&SequenceLockDisabled != 0 ||
+		lock&^(SequenceLockSeconds|SequenceLockMask) != 0) {
+		return ErrBadLockTime
+	}
+
+	return nil
+}
+
+// NewTimeLockedTransaction function - a payment that cannot be mined until the lock time, a height or a unix
+// time, has passed
+func NewTimeLockedTransaction(w *wallet.Wallet, to string, amount, fee int, lockTime int64, UTXO *UTXOSet) (*Transaction, error) {
+	if err := checkLock(lockTime, false); err != nil {
+		return nil, err
+	}
+
+	tx, err := newUnsignedTransaction(string(w.Address()), to, amount, fee, UTXO)
+	if err != nil {
+		return nil, err
+	}
+	tx.LockTime = lockTime
+	tx.ID = tx.Hash()
+
+	if err := UTXO.Blockchain.SignTransaction(tx, w.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// NewTimeLockedPayment function - pays a script address that the recipient can only spend from once the lock
+// has passed, as for vesting. The recipient needs the redeem script it returns to spend the output.
+func NewTimeLockedPayment(w *wallet.Wallet, to string, amount, fee int, lock int64, relative bool, UTXO *UTXOSet) (*Transaction, []byte, error) {
+	if err := checkLock(lock, relative); err != nil {
+		return nil, nil, err
+	}
+
+	version, pubKeyHash, err := wallet.DecodeAddress(to)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version != wallet.PubKeyHashVersion {
+		return nil, nil, wallet.ErrInvalidAddress
+	}
+
+	redeemScript := TimeLockScript(lock, relative, pubKeyHash)
+	tx, err := NewTransaction(w, string(wallet.ScriptAddress(redeemScript)), amount, fee, UTXO)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tx, redeemScript, nil
+}
+
+// SpendTimeLocked function - spends the outputs paying the time locked redeem script, with the lock time or
+// input sequence the script needs. Any change goes back to the script address.
+func SpendTimeLocked(w *wallet.Wallet, redeemScript []byte, to string, amount, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	lock, relative, pubKeyHash, err := ParseTimeLockScript(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pubKeyHash, wallet.PublicKeyHash(w.PublicKey)) {
+		return nil, ErrKeyNotUsed
+	}
+
+	tx, err := newUnsignedTransaction(string(wallet.ScriptAddress(redeemScript)), to, amount, fee, UTXO)
+	if err != nil {
+		return nil, err
+	}
+	if relative {
+		for i := range tx.Inputs {
+			tx.Inputs[i].Sequence = uint32(lock)
+		}
+	} else {
+		tx.LockTime = lock
+	}
+	tx.ID = tx.Hash()
+
+	for i := range tx.Inputs {
+		signature, err := signHash(w.PrivateKey, tx.SigHash(i, redeemScript))
+		if err != nil {
+			return nil, err
+		}
+		tx.Inputs[i].Script = pushData(P2PKHUnlockingScript(signature, w.PublicKey), redeemScript)
+	}
+
+	return tx, nil
+}
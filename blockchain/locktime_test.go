@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckLockTime(t *testing.T) {
+	chain, w := newTestChain(t)
+	mineTestBlock(t, chain, w)
+	mineTestBlock(t, chain, w)
+
+	// the spent output is in block 1, checked for a transaction going in block 3
+	coinHeights := []int{1}
+	height := 3
+	coinTime, err := chain.medianTimeAt(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seconds, err := RelativeLockSeconds(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		lockTime   int64
+		sequence   uint32
+		medianTime int64
+		err        error
+	}{
+		{"no lock", 0, SequenceFinal, coinTime, nil},
+		{"height reached", 3, SequenceFinal, coinTime, nil},
+		{"height not reached", 4, SequenceFinal, coinTime, ErrLockTime},
+		{"time reached", LockTimeThreshold, SequenceFinal, LockTimeThreshold, nil},
+		{"time not reached", LockTimeThreshold + 1, SequenceFinal, LockTimeThreshold, ErrLockTime},
+		{"relative blocks reached", 0, 2, coinTime, nil},
+		{"relative blocks not reached", 0, 3, coinTime, ErrSequenceLock},
+		{"relative lock disabled", 0, SequenceLockDisabled | 3, coinTime, nil},
+		{"relative seconds reached", 0, seconds, coinTime + 1024, nil},
+		{"relative seconds not reached", 0, seconds, coinTime + 1023, ErrSequenceLock},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := &Transaction{
+				Inputs:   []TxInput{{ID: make([]byte, hashLength), Sequence: test.sequence}},
+				LockTime: test.lockTime,
+			}
+			tx.ID = tx.Hash()
+
+			err := chain.CheckLockTime(tx, coinHeights, height, test.medianTime)
+			if test.err == nil && err != nil {
+				t.Fatalf("expected the transaction to be final, got %v", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestRelativeLock(t *testing.T) {
+	if _, err := RelativeLockBlocks(SequenceLockMask + 1); !errors.Is(err, ErrBadLockTime) {
+		t.Fatalf("expected %v, got %v", ErrBadLockTime, err)
+	}
+	if _, err := RelativeLockSeconds(-1); !errors.Is(err, ErrBadLockTime) {
+		t.Fatalf("expected %v, got %v", ErrBadLockTime, err)
+	}
+
+	// seconds round up to whole units so the lock is never shorter than asked
+	sequence, err := RelativeLockSeconds(513)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sequence != SequenceLockSeconds|2 {
+		t.Fatalf("expected two units of seconds, got %#x", sequence)
+	}
+}
+
+func TestTimeLockedPayment(t *testing.T) {
+	chain, w := newTestChain(t)
+	other := newTestWallet(t)
+	UTXOSet := UTXOSet{chain}
+
+	height, _, err := chain.SpendContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := int64(height + 2)
+
+	payment, redeem, err := NewTimeLockedPayment(w, string(other.Address()), 5, 0, lock, false, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mineTestBlock(t, chain, w, payment)
+
+	if _, err := SpendTimeLocked(w, redeem, string(w.Address()), 5, 0, &UTXOSet); !errors.Is(err, ErrKeyNotUsed) {
+		t.Fatalf("expected %v, got %v", ErrKeyNotUsed, err)
+	}
+
+	tx, err := SpendTimeLocked(other, redeem, string(other.Address()), 5, 0, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.LockTime != lock {
+		t.Fatalf("expected lock time %d, got %d", lock, tx.LockTime)
+	}
+	if err := chain.VerifyTransaction(tx); !errors.Is(err, ErrLockTime) {
+		t.Fatalf("expected %v, got %v", ErrLockTime, err)
+	}
+	cbTx, err := CoinbaseTx(string(w.Address()), "", height+1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.MineBlock([]*Transaction{cbTx, tx}); !errors.Is(err, ErrLockTime) {
+		t.Fatalf("expected mining to fail with %v, got %v", ErrLockTime, err)
+	}
+
+	mineTestBlock(t, chain, w)
+	if err := chain.VerifyTransaction(tx); err != nil {
+		t.Fatalf("expected the spend to be valid once the lock passed, got %v", err)
+	}
+	mineTestBlock(t, chain, w, tx)
+}
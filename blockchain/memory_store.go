@@ -0,0 +1,149 @@
+package blockchain
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var errReadOnly = errors.New("Cannot write in a read only transaction")
+
+// MemoryStore struct - a ChainStore kept in a map, for tests and throwaway chains. Updates run one at a time
+// and their writes are only applied to the map once fn returns without an error.
+type MemoryStore struct {
+	mutex sync.RWMutex
+	data  map[string][]byte
+}
+
+// memoryTx struct - writes holds the values set in an Update, deleted the keys it removed
+type memoryTx struct {
+	store   *MemoryStore
+	writes  map[string][]byte
+	deleted map[string]bool
+}
+
+// NewMemoryStore function
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+// View function
+func (s *MemoryStore) View(fn func(txn StoreTx) error) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return fn(&memoryTx{store: s})
+}
+
+// Update function
+func (s *MemoryStore) Update(fn func(txn StoreTx) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	txn := &memoryTx{s, make(map[string][]byte), make(map[string]bool)}
+	if err := fn(txn); err != nil {
+		return err
+	}
+
+	for key := range txn.deleted {
+		delete(s.data, key)
+	}
+	for key, value := range txn.writes {
+		s.data[key] = value
+	}
+
+	return nil
+}
+
+// Close function
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// Get function
+func (t *memoryTx) Get(key []byte) ([]byte, error) {
+	k := string(key)
+
+	if value, ok := t.writes[k]; ok {
+		return append([]byte{}, value...), nil
+	}
+	if t.deleted[k] {
+		return nil, ErrKeyNotFound
+	}
+	if value, ok := t.store.data[k]; ok {
+		return append([]byte{}, value...), nil
+	}
+
+	return nil, ErrKeyNotFound
+}
+
+// Set function
+func (t *memoryTx) Set(key, value []byte) error {
+	if t.writes == nil {
+		return errReadOnly
+	}
+
+	k := string(key)
+	t.writes[k] = append([]byte{}, value...)
+	delete(t.deleted, k)
+
+	return nil
+}
+
+// Delete function
+func (t *memoryTx) Delete(key []byte) error {
+	if t.writes == nil {
+		return errReadOnly
+	}
+
+	k := string(key)
+	delete(t.writes, k)
+	t.deleted[k] = true
+
+	return nil
+}
+
+// Iterate function
+func (t *memoryTx) Iterate(prefix []byte, reverse bool, fn func(key, value []byte) error) error {
+	p := string(prefix)
+	seen := make(map[string]bool)
+	var keys []string
+
+	for key := range t.store.data {
+		if strings.HasPrefix(key, p) && !t.deleted[key] {
+			keys = append(keys, key)
+			seen[key] = true
+		}
+	}
+	for key := range t.writes {
+		if strings.HasPrefix(key, p) && !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+
+	// string order is byte order, the same as badger's
+	sort.Strings(keys)
+	if reverse {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	for _, key := range keys {
+		value, err := t.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		err = fn([]byte(key), value)
+		if err == ErrStopIteration {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
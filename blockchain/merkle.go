@@ -1,10 +1,19 @@
 package blockchain
 
-import "crypto/sha256"
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// ErrProofIndex is returned when asking for the proof of a leaf the tree does not have
+var ErrProofIndex = errors.New("Merkle proof index is out of range")
 
 // MerkleTree struct
 type MerkleTree struct {
 	RootNode *MerkleNode
+	levels   [][]*MerkleNode // leaves first, each padded to an even length
+	leaves   int             // leaves before padding
 }
 
 // MerkleNode struct
@@ -14,6 +23,13 @@ type MerkleNode struct {
 	Data  []byte
 }
 
+// MerkleProof struct - the sibling hashes on the path from a leaf up to the root, lowest first.
+// Index is the leaf's position, its bits say which side each sibling goes on.
+type MerkleProof struct {
+	Index  int
+	Hashes [][]byte
+}
+
 // NewMerkleNode function
 func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	node := MerkleNode{}
@@ -22,7 +38,7 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 		hash := sha256.Sum256(data)
 		node.Data = hash[:]
 	} else {
-		prevHashes := append(left.Data, right.Data...)
+		prevHashes := append(append([]byte{}, left.Data...), right.Data...)
 		hash := sha256.Sum256(prevHashes)
 		node.Data = hash[:]
 	}
@@ -33,31 +49,74 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	return &node
 }
 
-// NewMerkleTree function
+// NewMerkleTree function - the last node of a level with an odd number of nodes is paired with itself.
+// There is always at least one level above the leaves, so a single leaf is hashed with itself too.
 func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
-
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
-	}
+	var nodes []*MerkleNode
 
 	for _, dat := range data {
-		node := NewMerkleNode(nil, nil, dat)
-		nodes = append(nodes, *node)
+		nodes = append(nodes, NewMerkleNode(nil, nil, dat))
+	}
+	if len(nodes) == 0 {
+		nodes = append(nodes, NewMerkleNode(nil, nil, nil))
 	}
 
-	for i := 0; i < len(data)/2; i++ {
-		var level []MerkleNode
+	tree := MerkleTree{leaves: len(data)}
 
-		for j := 0; j < len(nodes); j += 2 {
-			node := NewMerkleNode(&nodes[j], &nodes[j+1], nil)
-			level = append(level, *node)
+	for {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
 		}
+		tree.levels = append(tree.levels, nodes)
 
+		var level []*MerkleNode
+		for j := 0; j < len(nodes); j += 2 {
+			level = append(level, NewMerkleNode(nodes[j], nodes[j+1], nil))
+		}
 		nodes = level
+
+		if len(nodes) == 1 {
+			break
+		}
 	}
 
-	tree := MerkleTree{&nodes[0]}
+	tree.RootNode = nodes[0]
 
 	return &tree
 }
+
+// Proof function - the proof that the leaf at index is under the root. The copy padding an odd level has no proof.
+func (t *MerkleTree) Proof(index int) (*MerkleProof, error) {
+	if index < 0 || index >= t.leaves {
+		return nil, ErrProofIndex
+	}
+
+	proof := &MerkleProof{Index: index}
+	for _, level := range t.levels {
+		proof.Hashes = append(proof.Hashes, level[index^1].Data)
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyProof function - true if the leaf data hashes up through the proof to the root
+func VerifyProof(root, leaf []byte, proof *MerkleProof) bool {
+	if proof == nil || proof.Index < 0 {
+		return false
+	}
+
+	hash := sha256.Sum256(leaf)
+	index := proof.Index
+
+	for _, sibling := range proof.Hashes {
+		if index%2 == 0 {
+			hash = sha256.Sum256(append(append([]byte{}, hash[:]...), sibling...))
+		} else {
+			hash = sha256.Sum256(append(append([]byte{}, sibling...), hash[:]...))
+		}
+		index /= 2
+	}
+
+	return index == 0 && bytes.Equal(hash[:], root)
+}
@@ -0,0 +1,46 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for count := 1; count <= 7; count++ {
+		t.Run(fmt.Sprintf("%d leaves", count), func(t *testing.T) {
+			var leaves [][]byte
+			for i := 0; i < count; i++ {
+				leaves = append(leaves, []byte(fmt.Sprintf("leaf %d", i)))
+			}
+			tree := NewMerkleTree(leaves)
+			root := tree.RootNode.Data
+
+			for i, leaf := range leaves {
+				proof, err := tree.Proof(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !VerifyProof(root, leaf, proof) {
+					t.Fatalf("leaf %d does not prove out", i)
+				}
+				if VerifyProof(root, []byte("another leaf"), proof) {
+					t.Fatalf("leaf %d's proof works for another leaf", i)
+				}
+
+				moved := *proof
+				moved.Index ^= 1
+				if moved.Index < count && VerifyProof(root, leaf, &moved) {
+					t.Fatalf("leaf %d proves out at index %d", i, moved.Index)
+				}
+			}
+
+			// the copy padding an odd level is not a leaf
+			for _, index := range []int{-1, count, count + 1} {
+				if _, err := tree.Proof(index); !errors.Is(err, ErrProofIndex) {
+					t.Fatalf("index %d: expected %v, got %v", index, ErrProofIndex, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// blockReservedSize is the room kept back in each block for the header and the coinbase
+const blockReservedSize = 1000
+
+// candidate struct - a pool transaction being considered for the next block
+type candidate struct {
+	tx   *Transaction
+	fee  int
+	size int
+}
+
+// SelectTransactions function - picks transactions for the next block by fee per byte, highest first, until the
+// block is full. A transaction spending another pool transaction is only picked once its parent has been.
+// Transactions that are invalid, conflict with one already picked or spend unknown outputs are left out.
+// Returns the picked transactions in block order and the fees they pay.
+func (chain *BlockChain) SelectTransactions(pool []*Transaction) ([]*Transaction, int) {
+	poolTXs := make(map[string]*Transaction)
+	for _, tx := range pool {
+		poolTXs[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	var candidates []*candidate
+	for _, tx := range pool {
+		c, err := chain.newCandidate(tx, poolTXs)
+		if err != nil {
+			fmt.Printf("Skipping transaction %x: %s\n", tx.ID, err)
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+
+	// compare fee rates without dividing: a.fee/a.size > b.fee/b.size
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].fee*candidates[j].size > candidates[j].fee*candidates[i].size
+	})
+
+	var selected []*Transaction
+	included := make(map[string]bool)
+	spent := make(map[string]bool)
+	size, fees := 0, 0
+
+	for progress := true; progress; {
+		progress = false
+
+		for _, c := range candidates {
+			txID := hex.EncodeToString(c.tx.ID)
+			if included[txID] || size+c.size > MaxBlockSize-blockReservedSize {
+				continue
+			}
+			if !c.ready(poolTXs, included, spent) {
+				continue
+			}
+			// the coinbase could not claim fees past MaxMoney, so leave out what would take them there
+			newFees, ok := addMoney(fees, c.fee)
+			if !ok {
+				continue
+			}
+
+			for _, in := range c.tx.Inputs {
+				spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] = true
+			}
+			included[txID] = true
+			selected = append(selected, c.tx)
+			size += c.size
+			fees = newFees
+			progress = true
+		}
+	}
+
+	return selected, fees
+}
+
+// newCandidate function - resolves the outputs a pool transaction spends, from the chain or the pool,
+// and checks its lock times, signatures and fee
+func (chain *BlockChain) newCandidate(tx *Transaction, poolTXs map[string]*Transaction) (*candidate, error) {
+	if tx.IsCoinbase() {
+		return nil, ErrInvalidTransaction
+	}
+
+	height, medianTime, err := chain.SpendContext()
+	if err != nil {
+		return nil, err
+	}
+
+	prevTXs := make(map[string]Transaction)
+	var coinHeights []int
+	for _, in := range tx.Inputs {
+		inTxID := hex.EncodeToString(in.ID)
+		if parent, ok := poolTXs[inTxID]; ok {
+			prevTXs[inTxID] = *parent
+			coinHeights = append(coinHeights, height)
+			continue
+		}
+
+		prevTX, err := chain.FindInputTransaction(in.ID)
+		if err != nil {
+			return nil, err
+		}
+		if in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return nil, ErrMissingInput
+		}
+		entry, err := UTXOSet{chain}.FindEntry(in.ID, in.Out)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, ErrMissingInput
+		}
+		if !entry.IsMature(height) {
+			return nil, ErrImmatureSpend
+		}
+		prevTXs[inTxID] = prevTX
+		coinHeights = append(coinHeights, entry.Height)
+	}
+
+	if err := chain.CheckLockTime(tx, coinHeights, height, medianTime); err != nil {
+		return nil, err
+	}
+	if err := tx.Verify(prevTXs); err != nil {
+		return nil, ErrInvalidTransaction
+	}
+
+	fee, err := tx.Fee(prevTXs)
+	if err != nil {
+		return nil, err
+	}
+	if fee < 0 {
+		return nil, ErrSpendTooHigh
+	}
+
+	return &candidate{tx, fee, len(tx.Serialize())}, nil
+}
+
+// ready function - true once every pool parent is in the block and none of the inputs are already spent in it
+func (c *candidate) ready(poolTXs map[string]*Transaction, included, spent map[string]bool) bool {
+	for _, in := range c.tx.Inputs {
+		inTxID := hex.EncodeToString(in.ID)
+		if _, inPool := poolTXs[inTxID]; inPool && !included[inTxID] {
+			return false
+		}
+		if spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,48 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSelectTransactions(t *testing.T) {
+	chain, w := newTestChain(t)
+	other := newTestWallet(t)
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := genesis.Transactions[0]
+	moreCoins := mineTestBlock(t, chain, w).Transactions[0]
+	value := coins.Outputs[0].Value
+
+	low := spendTestTx(t, w, coins, 0, testOutput(t, value-1, other))
+	high := spendTestTx(t, w, moreCoins, 0, testOutput(t, value-5, other))
+	child := spendTestTx(t, other, low, 0, testOutput(t, value-4, w))
+	overspend := spendTestTx(t, other, high, 0, testOutput(t, value, w))
+
+	selected, fees := chain.SelectTransactions([]*Transaction{child, low, overspend, high})
+
+	// the child pays more per byte than its parent but has to follow it
+	expected := []*Transaction{high, low, child}
+	if len(selected) != len(expected) {
+		t.Fatalf("expected %d transactions, got %d", len(expected), len(selected))
+	}
+	for i, tx := range expected {
+		if !bytes.Equal(selected[i].ID, tx.ID) {
+			t.Fatalf("transaction %d is not the expected one", i)
+		}
+	}
+	if fees != 5+1+3 {
+		t.Fatalf("expected fees of %d, got %d", 5+1+3, fees)
+	}
+
+	cbTx, err := CoinbaseTx(string(w.Address()), "", 2, fees)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.MineBlock(append([]*Transaction{cbTx}, selected...)); err != nil {
+		t.Fatalf("the selected transactions do not make a valid block: %v", err)
+	}
+}
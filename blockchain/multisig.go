@@ -0,0 +1,189 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+var (
+	// ErrNotEnoughSignatures is returned when combining signatures that do not add up to what a multisig input needs
+	ErrNotEnoughSignatures = errors.New("Not enough signatures to spend the multisig output")
+	// ErrNotSameTransaction is returned when combining partly signed copies of different transactions
+	ErrNotSameTransaction = errors.New("Partly signed transactions are not copies of the same transaction")
+)
+
+// NewMultiSigTransaction function - an unsigned transaction spending from the P2SH address of the multisig redeem
+// script, with the change going back to it. Each keyholder then adds a signature with SignMultiSig.
+func NewMultiSigTransaction(redeemScript []byte, to string, amount, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	if _, _, err := ParseMultiSigScript(redeemScript); err != nil {
+		return nil, err
+	}
+
+	return newUnsignedTransaction(string(wallet.ScriptAddress(redeemScript)), to, amount, fee, UTXO)
+}
+
+// SignMultiSig function - adds a signature from the key to each input spending the P2SH output of the multisig
+// redeem script. The unlocking script holds the signatures so far in key order then the redeem script, so it is
+// complete once it holds as many as the script needs.
+func (tx *Transaction) SignMultiSig(privKey ecdsa.PrivateKey, redeemScript []byte, prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	required, pubKeys, err := ParseMultiSigScript(redeemScript)
+	if err != nil {
+		return err
+	}
+	if !hasPrevOutputs(tx, prevTXs) {
+		return ErrPrevTxNotFound
+	}
+
+	key := -1
+	padded := wallet.PublicKeyBytes(&privKey.PublicKey)
+	unpadded := append(privKey.PublicKey.X.Bytes(), privKey.PublicKey.Y.Bytes()...)
+	for i, pubKey := range pubKeys {
+		if bytes.Equal(pubKey, padded) || bytes.Equal(pubKey, unpadded) {
+			key = i
+			break
+		}
+	}
+	if key < 0 {
+		return ErrKeyNotUsed
+	}
+
+	signed := false
+	for inID, in := range tx.Inputs {
+		prevOut := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+		if !prevOut.IsLockedWithHash(wallet.PublicKeyHash(redeemScript)) {
+			continue
+		}
+
+		hash := tx.SigHash(inID, redeemScript)
+		signatures := multiSigSignatures(in.Script, pubKeys, hash)
+		if signatures[key] != nil {
+			signed = true
+			continue
+		}
+		if countSignatures(signatures) >= required {
+			continue
+		}
+
+		signatures[key], err = signHash(privKey, hash)
+		if err != nil {
+			return err
+		}
+		tx.Inputs[inID].Script = multiSigUnlockingScript(signatures, required, redeemScript)
+		signed = true
+	}
+
+	if !signed {
+		return ErrKeyNotUsed
+	}
+
+	return nil
+}
+
+// CombineMultiSig function - merges the signatures of partly signed copies of a transaction into one that
+// can be spent. Every input spending the redeem script's P2SH output must end up with enough signatures.
+func CombineMultiSig(redeemScript []byte, prevTXs map[string]Transaction, partials []*Transaction) (*Transaction, error) {
+	if len(partials) == 0 {
+		return nil, ErrNotEnoughSignatures
+	}
+
+	required, pubKeys, err := ParseMultiSigScript(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	first := partials[0]
+	for _, partial := range partials {
+		if !bytes.Equal(partial.ID, first.ID) || !bytes.Equal(partial.Hash(), first.ID) {
+			return nil, ErrNotSameTransaction
+		}
+	}
+	if !hasPrevOutputs(first, prevTXs) {
+		return nil, ErrPrevTxNotFound
+	}
+
+	tx := Transaction{first.ID, append([]TxInput{}, first.Inputs...), first.Outputs, first.LockTime}
+
+	for inID, in := range tx.Inputs {
+		prevOut := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+		if !prevOut.IsLockedWithHash(wallet.PublicKeyHash(redeemScript)) {
+			continue
+		}
+
+		hash := tx.SigHash(inID, redeemScript)
+		signatures := make([][]byte, len(pubKeys))
+		for _, partial := range partials {
+			for i, signature := range multiSigSignatures(partial.Inputs[inID].Script, pubKeys, hash) {
+				if signature != nil {
+					signatures[i] = signature
+				}
+			}
+		}
+
+		if count := countSignatures(signatures); count < required {
+			return nil, fmt.Errorf("%w: input %d has %d of %d", ErrNotEnoughSignatures, inID, count, required)
+		}
+		tx.Inputs[inID].Script = multiSigUnlockingScript(signatures, required, redeemScript)
+	}
+
+	return &tx, nil
+}
+
+// multiSigSignatures function - the signatures in a multisig unlocking script, each at the index of the key it
+// is from. Pushes that are not a signature from one of the keys are dropped.
+func multiSigSignatures(unlocking []byte, pubKeys [][]byte, hash []byte) [][]byte {
+	signatures := make([][]byte, len(pubKeys))
+
+	pushes := scriptPushes(unlocking)
+	if len(pushes) == 0 {
+		return signatures
+	}
+
+	// the last push is the redeem script
+	for _, signature := range pushes[:len(pushes)-1] {
+		for i, pubKey := range pubKeys {
+			if signatures[i] == nil && checkSignature(signature, pubKey, hash) {
+				signatures[i] = signature
+				break
+			}
+		}
+	}
+
+	return signatures
+}
+
+// countSignatures function
+func countSignatures(signatures [][]byte) int {
+	count := 0
+	for _, signature := range signatures {
+		if signature != nil {
+			count++
+		}
+	}
+
+	return count
+}
+
+// multiSigUnlockingScript function - the first required signatures in key order, then the redeem script
+func multiSigUnlockingScript(signatures [][]byte, required int, redeemScript []byte) []byte {
+	var script []byte
+
+	count := 0
+	for _, signature := range signatures {
+		if signature == nil || count == required {
+			continue
+		}
+		script = pushData(script, signature)
+		count++
+	}
+
+	return pushData(script, redeemScript)
+}
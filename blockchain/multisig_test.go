@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+func TestMultiSigTransaction(t *testing.T) {
+	chain, w := newTestChain(t)
+	alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+
+	redeem, err := MultiSigScript(2, [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := genesis.Transactions[0]
+	funding := spendTestTx(t, w, coins, 0,
+		TxOutput{Value: coins.Outputs[0].Value, Script: P2SHScript(wallet.PublicKeyHash(redeem))})
+	mineTestBlock(t, chain, w, funding)
+	prevTXs := map[string]Transaction{hex.EncodeToString(funding.ID): *funding}
+
+	unsigned, err := NewMultiSigTransaction(redeem, string(w.Address()), 5, 1, &UTXOSet{chain})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// signed function - a copy of the unsigned transaction signed by the wallet
+	signed := func(t *testing.T, signer *wallet.Wallet) *Transaction {
+		tx, err := DeserializeTransaction(unsigned.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.SignMultiSig(signer.PrivateKey, redeem, prevTXs); err != nil {
+			t.Fatal(err)
+		}
+		return &tx
+	}
+
+	if err := unsigned.SignMultiSig(w.PrivateKey, redeem, prevTXs); !errors.Is(err, ErrKeyNotUsed) {
+		t.Fatalf("expected %v, got %v", ErrKeyNotUsed, err)
+	}
+
+	one := signed(t, alice)
+	if err := one.Verify(prevTXs); !errors.Is(err, ErrScriptFailed) {
+		t.Fatalf("expected one signature to fail the script, got %v", err)
+	}
+	if _, err := CombineMultiSig(redeem, prevTXs, []*Transaction{one}); !errors.Is(err, ErrNotEnoughSignatures) {
+		t.Fatalf("expected %v, got %v", ErrNotEnoughSignatures, err)
+	}
+
+	tx, err := CombineMultiSig(redeem, prevTXs, []*Transaction{one, signed(t, carol)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.VerifyTransaction(tx); err != nil {
+		t.Fatalf("expected the combined transaction to be valid, got %v", err)
+	}
+	mineTestBlock(t, chain, w, tx)
+
+	other, err := NewTransaction(w, string(w.Address()), 1, 0, &UTXOSet{chain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineMultiSig(redeem, prevTXs, []*Transaction{one, other}); !errors.Is(err, ErrNotSameTransaction) {
+		t.Fatalf("expected %v, got %v", ErrNotSameTransaction, err)
+	}
+}
@@ -0,0 +1,370 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+var (
+	// ErrBadPartialTx is returned for a partially signed transaction whose parts do not match up
+	ErrBadPartialTx = errors.New("Partially signed transaction is malformed")
+	// ErrIncompletePartialTx is returned when finalizing a partially signed transaction missing signatures
+	ErrIncompletePartialTx = errors.New("Partially signed transaction does not have all its signatures")
+	// ErrWrongRedeemScript is returned when the redeem script given for a script address does not hash to it
+	ErrWrongRedeemScript = errors.New("Redeem script does not match the address")
+)
+
+// PartialTx struct - an unsigned or partly signed transaction with what is needed to sign it away from the
+// chain. Inputs line up with Tx.Inputs.
+type PartialTx struct {
+	Tx     Transaction
+	Inputs []PartialInput
+}
+
+// PartialInput struct - the whole transaction the input spends from, so a signer with no chain can check
+// what the output is worth against its ID, the redeem script if that output is P2SH, and the signatures
+// collected so far
+type PartialInput struct {
+	PrevTx       Transaction
+	RedeemScript []byte
+	Signatures   []PartialSig
+}
+
+// PartialSig struct
+type PartialSig struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// NewPartialTx function - an unsigned transaction from the address, which needs no keys, only the UTXO set and
+// the transactions it spends from. redeemScript is only needed when from is a script address.
+func NewPartialTx(from, to string, amount, fee int, redeemScript []byte, UTXO *UTXOSet) (*PartialTx, error) {
+	version, hash, err := wallet.DecodeAddress(from)
+	if err != nil {
+		return nil, err
+	}
+	if version == wallet.ScriptHashVersion && !bytes.Equal(wallet.PublicKeyHash(redeemScript), hash) {
+		return nil, ErrWrongRedeemScript
+	}
+
+	tx, err := newUnsignedTransaction(from, to, amount, fee, UTXO)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PartialTx{Tx: *tx}
+	for _, in := range tx.Inputs {
+		entry, err := UTXO.FindEntry(in.ID, in.Out)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, ErrMissingInput
+		}
+		prevTX, err := UTXO.Blockchain.FindTransaction(in.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		input := PartialInput{PrevTx: prevTX}
+		if ExtractScriptHash(entry.Output.Script) != nil {
+			input.RedeemScript = redeemScript
+		}
+		p.Inputs = append(p.Inputs, input)
+	}
+
+	return p, nil
+}
+
+// Serialize function
+func (p *PartialTx) Serialize() []byte {
+	var buff bytes.Buffer
+
+	encoder := gob.NewEncoder(&buff)
+	err := encoder.Encode(p)
+	Handle(err)
+
+	return buff.Bytes()
+}
+
+// DeserializePartialTx function
+func DeserializePartialTx(data []byte) (*PartialTx, error) {
+	var p PartialTx
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&p); err != nil {
+		return nil, err
+	}
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// check function - the inputs line up with the transaction's, each previous transaction hashes to the ID the
+// input spends from, so its output values can be trusted, and each redeem script matches the output it unlocks
+func (p *PartialTx) check() error {
+	if len(p.Inputs) != len(p.Tx.Inputs) || p.Tx.IsCoinbase() {
+		return ErrBadPartialTx
+	}
+	if !bytes.Equal(p.Tx.ID, p.Tx.Hash()) {
+		return fmt.Errorf("%w: %s", ErrBadPartialTx, ErrBadTxID)
+	}
+
+	for i, input := range p.Inputs {
+		in := p.Tx.Inputs[i]
+		if !bytes.Equal(input.PrevTx.ID, in.ID) || !bytes.Equal(input.PrevTx.Hash(), in.ID) {
+			return fmt.Errorf("%w: input %d previous transaction does not match", ErrBadPartialTx, i)
+		}
+		if in.Out < 0 || in.Out >= len(input.PrevTx.Outputs) {
+			return fmt.Errorf("%w: input %d", ErrBadPartialTx, i)
+		}
+
+		scriptHash := ExtractScriptHash(p.prevOutput(i).Script)
+		if scriptHash != nil && input.RedeemScript != nil &&
+			!bytes.Equal(wallet.PublicKeyHash(input.RedeemScript), scriptHash) {
+			return fmt.Errorf("%w: input %d", ErrWrongRedeemScript, i)
+		}
+	}
+
+	return nil
+}
+
+// prevOutput function - the output the input spends. check must have passed.
+func (p *PartialTx) prevOutput(input int) TxOutput {
+	return p.Inputs[input].PrevTx.Outputs[p.Tx.Inputs[input].Out]
+}
+
+// Fee function - what the signer is agreeing to leave for the miner
+func (p *PartialTx) Fee() (int, error) {
+	if err := p.check(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for i := range p.Inputs {
+		var ok bool
+		if total, ok = addMoney(total, p.prevOutput(i).Value); !ok {
+			return 0, ErrValueOutOfRange
+		}
+	}
+
+	outputValue, err := p.Tx.OutputValue()
+	if err != nil {
+		return 0, err
+	}
+
+	return total - outputValue, nil
+}
+
+// sigScript function - the script an input's signatures sign: the redeem script for a P2SH output, otherwise
+// the output's own
+func (p *PartialTx) sigScript(input int) []byte {
+	if p.Inputs[input].RedeemScript != nil {
+		return p.Inputs[input].RedeemScript
+	}
+
+	return p.prevOutput(input).Script
+}
+
+// inputKeys function - the public keys that can sign the input, nil if its script is not P2PKH or multisig P2SH
+func (p *PartialTx) inputKeys(input int, candidates [][]byte) [][]byte {
+	in := p.Inputs[input]
+	prevOutput := p.prevOutput(input)
+
+	if pubKeyHash := prevOutput.PubKeyHash(); pubKeyHash != nil {
+		for _, key := range candidates {
+			if bytes.Equal(wallet.PublicKeyHash(key), pubKeyHash) {
+				return [][]byte{key}
+			}
+		}
+		return nil
+	}
+
+	if in.RedeemScript == nil {
+		return nil
+	}
+	_, pubKeys, err := ParseMultiSigScript(in.RedeemScript)
+	if err != nil {
+		return nil
+	}
+
+	return pubKeys
+}
+
+// Sign function - adds a signature from the key to every input it can sign. Only the partially signed
+// transaction is needed, so this can run on a wallet with no chain.
+func (p *PartialTx) Sign(privKey ecdsa.PrivateKey) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+
+	// wallets made before public keys were padded hold the unpadded encoding
+	candidates := [][]byte{
+		wallet.PublicKeyBytes(&privKey.PublicKey),
+		append(privKey.PublicKey.X.Bytes(), privKey.PublicKey.Y.Bytes()...),
+	}
+
+	signed := false
+	for i := range p.Inputs {
+		for _, key := range p.inputKeys(i, candidates) {
+			if !bytes.Equal(key, candidates[0]) && !bytes.Equal(key, candidates[1]) {
+				continue
+			}
+			if p.Inputs[i].signature(key) != nil {
+				signed = true
+				break
+			}
+
+			signature, err := signHash(privKey, p.Tx.SigHash(i, p.sigScript(i)))
+			if err != nil {
+				return err
+			}
+			p.Inputs[i].Signatures = append(p.Inputs[i].Signatures, PartialSig{key, signature})
+			signed = true
+			break
+		}
+	}
+
+	if !signed {
+		return ErrKeyNotUsed
+	}
+
+	return nil
+}
+
+// signature function - the signature from the key, nil if there is none yet
+func (in *PartialInput) signature(pubKey []byte) []byte {
+	for _, sig := range in.Signatures {
+		if bytes.Equal(sig.PubKey, pubKey) {
+			return sig.Signature
+		}
+	}
+
+	return nil
+}
+
+// CombinePartialTxs function - merges the signatures of copies of the same partially signed transaction,
+// checking each one as it goes
+func CombinePartialTxs(partials []*PartialTx) (*PartialTx, error) {
+	if len(partials) == 0 {
+		return nil, ErrBadPartialTx
+	}
+
+	first := partials[0]
+	if err := first.check(); err != nil {
+		return nil, err
+	}
+
+	combined := &PartialTx{first.Tx, make([]PartialInput, len(first.Inputs))}
+	for i, input := range first.Inputs {
+		combined.Inputs[i] = PartialInput{input.PrevTx, input.RedeemScript, nil}
+	}
+
+	for _, partial := range partials {
+		if err := partial.check(); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(partial.Tx.ID, first.Tx.ID) {
+			return nil, ErrNotSameTransaction
+		}
+
+		for i, input := range partial.Inputs {
+			in := &combined.Inputs[i]
+			if in.RedeemScript == nil {
+				in.RedeemScript = input.RedeemScript
+			}
+
+			hash := combined.Tx.SigHash(i, combined.sigScript(i))
+			for _, sig := range input.Signatures {
+				if in.signature(sig.PubKey) != nil {
+					continue
+				}
+				if !checkSignature(sig.Signature, sig.PubKey, hash) {
+					return nil, fmt.Errorf("%w: input %d key %x", ErrBadSignature, i, sig.PubKey)
+				}
+				in.Signatures = append(in.Signatures, sig)
+			}
+		}
+	}
+
+	return combined, nil
+}
+
+// Finalize function - builds each input's unlocking script from its signatures, giving a transaction that can
+// be broadcast
+func (p *PartialTx) Finalize() (*Transaction, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+
+	tx := Transaction{p.Tx.ID, append([]TxInput{}, p.Tx.Inputs...), p.Tx.Outputs, p.Tx.LockTime}
+
+	for i, in := range p.Inputs {
+		var keys [][]byte
+		for _, sig := range in.Signatures {
+			keys = append(keys, sig.PubKey)
+		}
+
+		prevOutput := p.prevOutput(i)
+		if prevOutput.PubKeyHash() != nil {
+			pubKeys := p.inputKeys(i, keys)
+			if pubKeys == nil {
+				return nil, fmt.Errorf("%w: input %d is not signed", ErrIncompletePartialTx, i)
+			}
+			tx.Inputs[i].Script = P2PKHUnlockingScript(in.signature(pubKeys[0]), pubKeys[0])
+			continue
+		}
+
+		if ExtractScriptHash(prevOutput.Script) == nil {
+			return nil, fmt.Errorf("%w: input %d spends a script that is not P2PKH or P2SH", ErrBadPartialTx, i)
+		}
+		if in.RedeemScript == nil {
+			return nil, fmt.Errorf("%w: input %d has no redeem script", ErrIncompletePartialTx, i)
+		}
+		required, pubKeys, err := ParseMultiSigScript(in.RedeemScript)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+
+		signatures := make([][]byte, len(pubKeys))
+		for k, pubKey := range pubKeys {
+			signatures[k] = in.signature(pubKey)
+		}
+		if count := countSignatures(signatures); count < required {
+			return nil, fmt.Errorf("%w: input %d has %d of %d", ErrIncompletePartialTx, i, count, required)
+		}
+		tx.Inputs[i].Script = multiSigUnlockingScript(signatures, required, in.RedeemScript)
+	}
+
+	return &tx, nil
+}
+
+// String function for cli
+func (p *PartialTx) String() string {
+	result := p.Tx.String()
+	if err := p.check(); err != nil {
+		return result + fmt.Sprintf("\n     %s", err)
+	}
+
+	for i, in := range p.Inputs {
+		result += fmt.Sprintf("\n     Input %d spends %d, %d signatures", i, p.prevOutput(i).Value, len(in.Signatures))
+		for _, sig := range in.Signatures {
+			result += fmt.Sprintf("\n       Signed by: %s", hex.EncodeToString(sig.PubKey))
+		}
+	}
+	if fee, err := p.Fee(); err != nil {
+		result += fmt.Sprintf("\n     Fee: %s", err)
+	} else {
+		result += fmt.Sprintf("\n     Fee: %d", fee)
+	}
+
+	return result
+}
@@ -0,0 +1,118 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+func TestPartialTx(t *testing.T) {
+	chain, w := newTestChain(t)
+	alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+
+	redeem, err := MultiSigScript(2, [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := string(wallet.ScriptAddress(redeem))
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := genesis.Transactions[0]
+	value := coins.Outputs[0].Value
+	funding := spendTestTx(t, w, coins, 0, TxOutput{Value: value, Script: P2SHScript(wallet.PublicKeyHash(redeem))})
+	mineTestBlock(t, chain, w, funding)
+	UTXOSet := UTXOSet{chain}
+
+	_, err = NewPartialTx(from, string(w.Address()), 5, 1, []byte("another script"), &UTXOSet)
+	if !errors.Is(err, ErrWrongRedeemScript) {
+		t.Fatalf("expected %v, got %v", ErrWrongRedeemScript, err)
+	}
+
+	p, err := NewPartialTx(from, string(w.Address()), 5, 1, redeem, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee, err := p.Fee(); err != nil || fee != 1 {
+		t.Fatalf("expected a fee of 1, got %d: %v", fee, err)
+	}
+
+	// signed function - a copy of the partial transaction, sent through serialization, signed by the wallet
+	signed := func(t *testing.T, signer *wallet.Wallet) *PartialTx {
+		partial, err := DeserializePartialTx(p.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := partial.Sign(signer.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		return partial
+	}
+
+	if err := p.Sign(w.PrivateKey); !errors.Is(err, ErrKeyNotUsed) {
+		t.Fatalf("expected %v, got %v", ErrKeyNotUsed, err)
+	}
+
+	one := signed(t, alice)
+	if _, err := one.Finalize(); !errors.Is(err, ErrIncompletePartialTx) {
+		t.Fatalf("expected %v, got %v", ErrIncompletePartialTx, err)
+	}
+
+	combined, err := CombinePartialTxs([]*PartialTx{one, signed(t, carol)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := combined.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.VerifyTransaction(tx); err != nil {
+		t.Fatalf("expected the finalized transaction to be valid, got %v", err)
+	}
+
+	forged := signed(t, bob)
+	forged.Inputs[0].Signatures[0].PubKey = alice.PublicKey
+	if _, err := CombinePartialTxs([]*PartialTx{p, forged}); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected %v, got %v", ErrBadSignature, err)
+	}
+
+	// a signer with no chain relies on the previous transaction to know what it is spending
+	lying := signed(t, bob)
+	lying.Inputs[0].PrevTx.Outputs[0].Value = value * 2
+	if _, err := lying.Fee(); !errors.Is(err, ErrBadPartialTx) {
+		t.Fatalf("expected %v, got %v", ErrBadPartialTx, err)
+	}
+	if _, err := DeserializePartialTx(lying.Serialize()); !errors.Is(err, ErrBadPartialTx) {
+		t.Fatalf("expected %v, got %v", ErrBadPartialTx, err)
+	}
+}
+
+func TestPartialTxP2PKH(t *testing.T) {
+	chain, w := newTestChain(t)
+	other := newTestWallet(t)
+
+	p, err := NewPartialTx(string(w.Address()), string(other.Address()), 5, 0, nil, &UTXOSet{chain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Finalize(); !errors.Is(err, ErrIncompletePartialTx) {
+		t.Fatalf("expected %v, got %v", ErrIncompletePartialTx, err)
+	}
+	if err := p.Sign(other.PrivateKey); !errors.Is(err, ErrKeyNotUsed) {
+		t.Fatalf("expected %v, got %v", ErrKeyNotUsed, err)
+	}
+	if err := p.Sign(w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := p.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.VerifyTransaction(tx); err != nil {
+		t.Fatalf("expected the finalized transaction to be valid, got %v", err)
+	}
+}
@@ -10,37 +10,28 @@ import (
 	"math/big"
 )
 
-// Difficulty static ATM
-const Difficulty = 14
-
 // ProofOfWork struct
 type ProofOfWork struct {
-	Block  *Block
+	Header *BlockHeader
 	Target *big.Int
 }
 
-// NewProof function
-func NewProof(b *Block) *ProofOfWork {
+// NewProof function - the target is built from the difficulty the header declares
+func NewProof(h *BlockHeader) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-Difficulty)) // left shift
+	target.Lsh(target, uint(256-h.Difficulty)) // left shift
 
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{h, target}
 
 	return pow
 }
 
-// InitData function
+// InitData function - the header's canonical encoding with the nonce swapped in, so the hash commits to every field
 func (pow *ProofOfWork) InitData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(),
-			ToHex(int64(nonce)),
-			ToHex(int64(Difficulty)),
-		},
-		[]byte{},
-	)
-	return data
+	header := *pow.Header
+	header.Nonce = nonce
+
+	return header.Serialize()
 }
 
 // Run function
@@ -73,7 +64,7 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 func (pow *ProofOfWork) Validate() bool {
 	var intHash big.Int
 
-	data := pow.InitData(pow.Block.Nonce)
+	data := pow.InitData(pow.Header.Nonce)
 
 	hash := sha256.Sum256(data)
 	intHash.SetBytes(hash[:])
@@ -0,0 +1,351 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+)
+
+var (
+	workPrefix   = []byte("cw-")
+	orphanPrefix = []byte("orphan-")
+)
+
+// ChainEvents struct - hooks fired as blocks join or leave the main chain
+type ChainEvents struct {
+	OnBlockConnected    func(block *Block)
+	OnBlockDisconnected func(block *Block)
+}
+
+// BlockWork function - the expected number of hashes needed to mine a block at the given difficulty
+func BlockWork(difficulty int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty))
+}
+
+// GetChainWork function - total proof of work from genesis up to and including the block.
+// Blocks stored before work was tracked get theirs worked out from their ancestors and saved.
+func (chain *BlockChain) GetChainWork(blockHash []byte) (*big.Int, error) {
+	var work *big.Int
+
+	err := chain.Store.View(func(txn StoreTx) error {
+		workData, err := txn.Get(append(workPrefix, blockHash...))
+		if err != nil {
+			return err
+		}
+		work = new(big.Int).SetBytes(workData)
+
+		return err
+	})
+	if err == nil {
+		return work, nil
+	}
+
+	block, err := chain.GetBlock(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	work = BlockWork(block.Difficulty)
+	if len(block.PrevHash) > 0 {
+		parentWork, err := chain.GetChainWork(block.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+		work.Add(work, parentWork)
+	}
+
+	err = chain.Store.Update(func(txn StoreTx) error {
+		return txn.Set(append(workPrefix, blockHash...), work.Bytes())
+	})
+
+	return work, err
+}
+
+// addOrphan function - keeps a block whose parent we have not seen yet, keyed by that parent
+func (chain *BlockChain) addOrphan(block *Block) error {
+	key := append(append(append([]byte{}, orphanPrefix...), block.PrevHash...), block.Hash...)
+
+	return chain.Store.Update(func(txn StoreTx) error {
+		return txn.Set(key, block.Serialize())
+	})
+}
+
+// connectOrphans function - adds any orphans that were waiting on the given block
+func (chain *BlockChain) connectOrphans(parentHash []byte) error {
+	var orphans []*Block
+	prefix := append(append([]byte{}, orphanPrefix...), parentHash...)
+
+	err := chain.Store.Update(func(txn StoreTx) error {
+		var keys [][]byte
+
+		err := txn.Iterate(prefix, false, func(key, blockData []byte) error {
+			keys = append(keys, key)
+
+			orphan, err := Deserialize(blockData)
+			if err == nil {
+				orphans = append(orphans, orphan)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, orphan := range orphans {
+		if err := chain.AddBlock(orphan); err != nil {
+			fmt.Printf("Rejected orphan block %x: %s\n", orphan.Hash, err)
+		}
+	}
+
+	return nil
+}
+
+// parent function - returns nil for a genesis block
+func (chain *BlockChain) parent(block *Block) (*Block, error) {
+	if len(block.PrevHash) == 0 {
+		return nil, nil
+	}
+
+	parent, err := chain.GetBlock(block.PrevHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parent, nil
+}
+
+// findFork function - walks both tips back to their common ancestor. detach runs from the old tip downwards
+// and attach from the new tip downwards; neither includes the ancestor. When the branches share no blocks
+// (a different genesis) both run all the way back to their genesis blocks.
+func (chain *BlockChain) findFork(oldTip, newTip *Block) (detach, attach []*Block, err error) {
+	a, b := oldTip, newTip
+
+	for a != nil || b != nil {
+		if a != nil && b != nil && bytes.Equal(a.Hash, b.Hash) {
+			break
+		}
+
+		if b == nil || (a != nil && a.Height >= b.Height) {
+			detach = append(detach, a)
+			a, err = chain.parent(a)
+		} else {
+			attach = append(attach, b)
+			b, err = chain.parent(b)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return detach, attach, nil
+}
+
+// reorganize function - switches the main chain over to the branch ending in newTip.
+// Blocks are disconnected back to the common ancestor, then the new branch is validated and connected
+// one block at a time. If a block on it breaks a rule, it is dropped along with the blocks above it on the
+// branch and the old branch is put back. Nothing is disconnected unless every block to go has an undo record,
+// and if disconnecting or connecting still fails the old branch is put back.
+func (chain *BlockChain) reorganize(newTip *Block) error {
+	oldTip, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return err
+	}
+
+	detach, attach, err := chain.findFork(&oldTip, newTip)
+	if err != nil {
+		return err
+	}
+
+	if len(detach) > 0 {
+		fmt.Printf("Reorganizing: disconnecting %d block(s), connecting %d block(s)\n", len(detach), len(attach))
+	}
+
+	if err := chain.checkUndoRecords(detach); err != nil {
+		return err
+	}
+
+	for i, block := range detach {
+		if err := chain.disconnectBlock(block); err != nil {
+			if err := chain.restoreBranch(nil, detach[:i]); err != nil {
+				return err
+			}
+			return err
+		}
+	}
+
+	for i := len(attach) - 1; i >= 0; i-- {
+		block := attach[i]
+
+		checkErr := chain.checkBlockTransactions(block)
+		if checkErr == nil {
+			err := chain.connectBlock(block)
+			if err == nil {
+				continue
+			}
+			if err := chain.restoreBranch(attach[i+1:], detach); err != nil {
+				return err
+			}
+			return err
+		}
+
+		if err := chain.restoreBranch(attach[i+1:], detach); err != nil {
+			return err
+		}
+		for _, invalid := range attach[:i+1] {
+			if err := chain.removeBlock(invalid); err != nil {
+				return err
+			}
+		}
+
+		return checkErr
+	}
+
+	return nil
+}
+
+// restoreBranch function - puts the old branch back after a failed reorganization, in one transaction so the
+// UTXO set is never left between the branches. connected holds the new branch's blocks that made it onto the
+// main chain and disconnected the old branch's blocks taken off it, both newest first.
+func (chain *BlockChain) restoreBranch(connected, disconnected []*Block) error {
+	if len(connected) == 0 && len(disconnected) == 0 {
+		return nil
+	}
+
+	var tip []byte
+	err := chain.Store.Update(func(txn StoreTx) error {
+		for _, block := range connected {
+			if err := disconnectTip(txn, block); err != nil {
+				return err
+			}
+			tip = block.PrevHash
+		}
+		for j := len(disconnected) - 1; j >= 0; j-- {
+			if err := connectTip(txn, disconnected[j]); err != nil {
+				return err
+			}
+			tip = disconnected[j].Hash
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("putting the old branch back: %w", err)
+	}
+	chain.LastHash = tip
+
+	for _, block := range connected {
+		if chain.Events.OnBlockDisconnected != nil {
+			chain.Events.OnBlockDisconnected(block)
+		}
+	}
+	for j := len(disconnected) - 1; j >= 0; j-- {
+		if chain.Events.OnBlockConnected != nil {
+			chain.Events.OnBlockConnected(disconnected[j])
+		}
+	}
+
+	return nil
+}
+
+// checkUndoRecords function - every block must have an undo record to be disconnected. Blocks from before a
+// UTXO snapshot have none.
+func (chain *BlockChain) checkUndoRecords(blocks []*Block) error {
+	return chain.Store.View(func(txn StoreTx) error {
+		for _, block := range blocks {
+			_, err := txn.Get(append(undoPrefix, block.Hash...))
+			if err == ErrKeyNotFound {
+				return fmt.Errorf("%w: block %x", ErrNoUndoRecord, block.Hash)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// removeBlock function - forgets a block that failed validation, or is built on one, and its header
+func (chain *BlockChain) removeBlock(block *Block) error {
+	err := chain.Store.Update(func(txn StoreTx) error {
+		if err := txn.Delete(block.Hash); err != nil {
+			return err
+		}
+		return txn.Delete(append(workPrefix, block.Hash...))
+	})
+	if err != nil {
+		return err
+	}
+
+	return chain.invalidateHeader(block.Hash)
+}
+
+// connectBlock function - see ConnectBlock, used for blocks that are already stored
+func (chain *BlockChain) connectBlock(block *Block) error {
+	err := chain.Store.Update(func(txn StoreTx) error {
+		return connectTip(txn, block)
+	})
+	if err != nil {
+		return err
+	}
+	chain.LastHash = block.Hash
+
+	if chain.Events.OnBlockConnected != nil {
+		chain.Events.OnBlockConnected(block)
+	}
+
+	return nil
+}
+
+// disconnectBlock function - see disconnectTip, in a transaction of its own
+func (chain *BlockChain) disconnectBlock(block *Block) error {
+	err := chain.Store.Update(func(txn StoreTx) error {
+		return disconnectTip(txn, block)
+	})
+	if err != nil {
+		return err
+	}
+	chain.LastHash = block.PrevHash
+
+	if chain.Events.OnBlockDisconnected != nil {
+		chain.Events.OnBlockDisconnected(block)
+	}
+
+	return nil
+}
+
+// connectTip function - applies a stored block whose parent is the tip to the UTXO set and indexes and makes it
+// the tip
+func connectTip(txn StoreTx, block *Block) error {
+	if err := applyBlock(txn, block); err != nil {
+		return err
+	}
+	if err := indexBlock(txn, block); err != nil {
+		return err
+	}
+
+	return txn.Set([]byte("lh"), block.Hash)
+}
+
+// disconnectTip function - takes the tip block's changes out of the UTXO set and indexes and makes its parent
+// the tip
+func disconnectTip(txn StoreTx, block *Block) error {
+	if err := revertBlock(txn, block); err != nil {
+		return err
+	}
+	if err := unindexBlock(txn, block); err != nil {
+		return err
+	}
+
+	return txn.Set([]byte("lh"), block.PrevHash)
+}
@@ -0,0 +1,186 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// forkTestChain function - a chain whose tip spends the genesis coinbase to another wallet, and a two block
+// branch from genesis that has not been added to it
+func forkTestChain(t *testing.T) (chain *BlockChain, spend *Transaction, branch []*Block) {
+	t.Helper()
+
+	chain, w := newTestChain(t)
+	other := newTestWallet(t)
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := genesis.Transactions[0]
+
+	fork := newTestBranch(t, chain)
+	miner := newTestWallet(t)
+	branch = append(branch, mineTestBlock(t, fork, miner), mineTestBlock(t, fork, miner))
+
+	spend = spendTestTx(t, w, coins, 0, testOutput(t, coins.Outputs[0].Value, other))
+	mineTestBlock(t, chain, w, spend)
+
+	return chain, spend, branch
+}
+
+func TestReorganize(t *testing.T) {
+	chain, spend, branch := forkTestChain(t)
+	oldTip := chain.LastHash
+
+	if err := chain.AddBlock(branch[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LastHash, oldTip) {
+		t.Fatal("a branch with the same work replaced the tip")
+	}
+
+	if err := chain.AddBlock(branch[1]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LastHash, branch[1].Hash) {
+		t.Fatal("expected the tip to move to the heavier branch")
+	}
+
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 2 {
+		t.Fatalf("expected height 2, got %d", height)
+	}
+	for i, block := range branch {
+		hash, err := chain.GetBlockHash(i + 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(hash, block.Hash) {
+			t.Fatalf("height %d is not on the new branch", i+1)
+		}
+	}
+
+	// the spend was only in the detached block, so the output it spent is back in the UTXO set
+	UTXOSet := UTXOSet{chain}
+	entry, err := UTXOSet.FindEntry(spend.Inputs[0].ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil {
+		t.Fatal("the output spent in the detached block is not unspent again")
+	}
+	if entry, err = UTXOSet.FindEntry(spend.ID, 0); err != nil || entry != nil {
+		t.Fatalf("the detached spend's output is still unspent: %v", err)
+	}
+	if _, err := chain.FindTransaction(spend.ID); err == nil {
+		t.Fatal("the detached spend is still in the transaction index")
+	}
+}
+
+func TestReorganizeOntoInvalidBranch(t *testing.T) {
+	chain, w := newTestChain(t)
+	miner := newTestWallet(t)
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := genesis.Transactions[0]
+
+	// a branch whose second block overspends, with two valid blocks on top of it
+	fork := newTestBranch(t, chain)
+	first := mineTestBlock(t, fork, miner)
+	cbTx, err := CoinbaseTx(string(miner.Address()), "", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	difficulty, err := fork.NextDifficulty(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overspend := spendTestTx(t, w, coins, 0, testOutput(t, coins.Outputs[0].Value+1, miner))
+	invalid := CreateBlock([]*Transaction{cbTx, overspend}, first.Hash, 2, difficulty)
+	if err := fork.ConnectBlock(invalid); err != nil {
+		t.Fatal(err)
+	}
+	above := mineTestBlock(t, fork, miner)
+	last := mineTestBlock(t, fork, miner)
+
+	mineTestBlock(t, chain, w)
+	tip := mineTestBlock(t, chain, w)
+
+	for _, block := range []*Block{first, invalid} {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := chain.AddBlock(above); !errors.Is(err, ErrSpendTooHigh) {
+		t.Fatalf("expected %v, got %v", ErrSpendTooHigh, err)
+	}
+	if !bytes.Equal(chain.LastHash, tip.Hash) {
+		t.Fatal("the tip moved onto the invalid branch")
+	}
+
+	for _, block := range []*Block{invalid, above} {
+		if _, err := chain.GetBlock(block.Hash); err == nil {
+			t.Fatalf("block %d of the invalid branch is still stored", block.Height)
+		}
+		if _, err := chain.GetHeader(block.Hash); err == nil {
+			t.Fatalf("header %d of the invalid branch is still stored", block.Height)
+		}
+	}
+	if _, err := chain.GetBlock(first.Hash); err != nil {
+		t.Fatalf("the valid block below the invalid one was dropped: %v", err)
+	}
+
+	UTXOSet := UTXOSet{chain}
+	if entry, err := UTXOSet.FindEntry(coins.ID, 0); err != nil || entry == nil {
+		t.Fatalf("the old branch's UTXO set was not put back: %v", err)
+	}
+	if entry, err := UTXOSet.FindEntry(first.Transactions[0].ID, 0); err != nil || entry != nil {
+		t.Fatalf("the new branch's coinbase is still unspent: %v", err)
+	}
+
+	// the parent is gone, so the block waits as an orphan
+	if err := chain.AddBlock(last); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LastHash, tip.Hash) {
+		t.Fatal("a block above the invalid one moved the tip")
+	}
+}
+
+func TestReorganizeWithoutUndoRecord(t *testing.T) {
+	chain, spend, branch := forkTestChain(t)
+	oldTip := chain.LastHash
+
+	err := chain.Store.Update(func(txn StoreTx) error {
+		return txn.Delete(append(undoPrefix, oldTip...))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chain.AddBlock(branch[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddBlock(branch[1]); !errors.Is(err, ErrNoUndoRecord) {
+		t.Fatalf("expected %v, got %v", ErrNoUndoRecord, err)
+	}
+
+	if !bytes.Equal(chain.LastHash, oldTip) {
+		t.Fatal("the tip moved without the undo record")
+	}
+	entry, err := UTXOSet{chain}.FindEntry(spend.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil {
+		t.Fatal("the tip's outputs were disconnected")
+	}
+}
@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+var (
+	// ErrNotReplaceable is returned when bumping the fee of a transaction that does not signal replace-by-fee
+	ErrNotReplaceable = errors.New("Transaction does not signal it can be replaced")
+	// ErrNoChange is returned when bumping the fee of a transaction with no change for the wallet to take it from
+	ErrNoChange = errors.New("Transaction has no change output to take the fee from")
+	// ErrNotOwnInputs is returned when bumping the fee of a transaction spending outputs the wallet cannot sign for
+	ErrNotOwnInputs = errors.New("Transaction spends outputs the wallet does not own")
+)
+
+// SignalsReplacement function - true if any input's sequence opts the transaction in to replace-by-fee
+func (tx *Transaction) SignalsReplacement() bool {
+	for _, in := range tx.Inputs {
+		if in.Sequence <= SequenceReplaceable {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BumpFee function - a replacement for the wallet's transaction paying extra more in fees, taken out of the
+// change output at index change, which must pay back to the wallet. Transactions built here keep their change
+// at ChangeOutput. The replacement spends the same outputs, so once one is mined the other cannot be.
+func BumpFee(w *wallet.Wallet, original *Transaction, change, extra int, UTXO *UTXOSet) (*Transaction, error) {
+	if !original.SignalsReplacement() {
+		return nil, ErrNotReplaceable
+	}
+	if extra <= 0 {
+		return nil, ErrBadTxOutput
+	}
+
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
+	prevTXs, err := UTXO.Blockchain.findPrevTransactions(original)
+	if err != nil {
+		return nil, err
+	}
+	for _, in := range original.Inputs {
+		if !prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out].IsLockedWithKey(pubKeyHash) {
+			return nil, ErrNotOwnInputs
+		}
+	}
+
+	if change < 0 || change >= len(original.Outputs) || !original.Outputs[change].IsLockedWithKey(pubKeyHash) {
+		return nil, ErrNoChange
+	}
+
+	tx := original.TrimmedCopy()
+	if tx.Outputs[change].Value < extra {
+		return nil, ErrInsufficientFunds
+	}
+
+	tx.Outputs[change].Value -= extra
+	if tx.Outputs[change].Value == 0 {
+		tx.Outputs = append(tx.Outputs[:change], tx.Outputs[change+1:]...)
+	}
+	tx.ID = tx.Hash()
+
+	if err := tx.Sign(w.PrivateKey, prevTXs); err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// BumpFeeFromWallets function - BumpFee with whichever of the wallets can sign for the transaction's inputs.
+// Also returns that wallet's address.
+func BumpFeeFromWallets(wallets *wallet.Wallets, original *Transaction, change, extra int, UTXO *UTXOSet) (*Transaction, string, error) {
+	for address, w := range wallets.Wallets {
+		tx, err := BumpFee(w, original, change, extra, UTXO)
+		if err == ErrNotOwnInputs {
+			continue
+		}
+
+		return tx, address, err
+	}
+
+	return nil, "", ErrNotOwnInputs
+}
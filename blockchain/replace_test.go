@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBumpFee(t *testing.T) {
+	chain, w := newTestChain(t)
+	other := newTestWallet(t)
+	UTXOSet := UTXOSet{chain}
+
+	original, err := NewTransaction(w, string(other.Address()), 5, 1, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change := original.Outputs[ChangeOutput].Value
+
+	tests := []struct {
+		name   string
+		change int
+		extra  int
+		err    error
+	}{
+		{"change index past the outputs", len(original.Outputs), 1, ErrNoChange},
+		{"change index of the payment", 0, 1, ErrNoChange},
+		{"no extra fee", ChangeOutput, 0, ErrBadTxOutput},
+		{"more than the change", ChangeOutput, change + 1, ErrInsufficientFunds},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := BumpFee(w, original, test.change, test.extra, &UTXOSet); !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	if _, err := BumpFee(other, original, ChangeOutput, 1, &UTXOSet); !errors.Is(err, ErrNotOwnInputs) {
+		t.Fatalf("expected %v, got %v", ErrNotOwnInputs, err)
+	}
+
+	final := original.TrimmedCopy()
+	for i := range final.Inputs {
+		final.Inputs[i].Sequence = SequenceFinal
+	}
+	if _, err := BumpFee(w, &final, ChangeOutput, 1, &UTXOSet); !errors.Is(err, ErrNotReplaceable) {
+		t.Fatalf("expected %v, got %v", ErrNotReplaceable, err)
+	}
+
+	bumped, err := BumpFee(w, original, ChangeOutput, 2, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bumped.Outputs[ChangeOutput].Value != change-2 {
+		t.Fatalf("expected change of %d, got %d", change-2, bumped.Outputs[ChangeOutput].Value)
+	}
+	if bumped.Outputs[0].Value != original.Outputs[0].Value || bytes.Equal(bumped.ID, original.ID) {
+		t.Fatal("expected the payment to stay the same in a new transaction")
+	}
+	if err := chain.VerifyTransaction(bumped); err != nil {
+		t.Fatalf("expected the replacement to be valid, got %v", err)
+	}
+
+	// taking all the change drops the output
+	all, err := BumpFee(w, original, ChangeOutput, change, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Outputs) != ChangeOutput {
+		t.Fatalf("expected the change output to be dropped, got %d outputs", len(all.Outputs))
+	}
+}
@@ -0,0 +1,769 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+// Limits that keep every script cheap to run
+const (
+	// MaxScriptSize is the longest script we run, in bytes
+	MaxScriptSize = 10000
+	// MaxScriptOps is the most opcodes other than pushes one script may have
+	MaxScriptOps = 201
+	// MaxPushSize is the most bytes one push may put on the stack
+	MaxPushSize = 520
+	// MaxStackSize is the most items the stack may hold
+	MaxStackSize = 1000
+	// MaxMultiSigKeys is the most keys a multisig script may have, as many as fit in a redeem script push
+	MaxMultiSigKeys = 7
+	// LockTimeThreshold splits lock times: below it they are block heights, from it unix timestamps
+	LockTimeThreshold = 500000000
+	// SequenceFinal is the sequence of an input with no relative lock time
+	SequenceFinal = 0xffffffff
+	// SequenceReplaceable is the highest sequence that marks a transaction as open to replace-by-fee
+	SequenceReplaceable = 0xfffffffd
+	// SequenceLockDisabled is set in a sequence that is not a relative lock time
+	SequenceLockDisabled = 1 << 31
+	// SequenceLockSeconds is set in a relative lock time counted in units of 512 seconds rather than blocks
+	SequenceLockSeconds = 1 << 22
+	// SequenceLockMask is the part of a sequence holding the relative lock time
+	SequenceLockMask = 0x0000ffff
+	// SequenceSecondsShift turns a relative lock time in 512 second units into seconds
+	SequenceSecondsShift = 9
+)
+
+// Opcodes. A byte from 0x01 to 0x4b pushes that many bytes that follow it.
+const (
+	Op0                   = 0x00
+	OpPushData1           = 0x4c
+	OpPushData2           = 0x4d
+	Op1                   = 0x51
+	Op16                  = 0x60
+	OpVerify              = 0x69
+	OpReturn              = 0x6a
+	OpDrop                = 0x75
+	OpDup                 = 0x76
+	OpEqual               = 0x87
+	OpEqualVerify         = 0x88
+	OpSha256              = 0xa8
+	OpHash160             = 0xa9
+	OpCheckSig            = 0xac
+	OpCheckSigVerify      = 0xad
+	OpCheckMultiSig       = 0xae
+	OpCheckMultiSigVerify = 0xaf
+	OpCheckLockTimeVerify = 0xb1
+	OpCheckSequenceVerify = 0xb2
+)
+
+var opNames = map[byte]string{
+	Op0:                   "OP_0",
+	OpVerify:              "OP_VERIFY",
+	OpReturn:              "OP_RETURN",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	OpSha256:              "OP_SHA256",
+	OpHash160:             "OP_HASH160",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+	OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+}
+
+var (
+	// ErrScriptFailed is returned when a script runs but does not leave true on the stack, or fails a check
+	ErrScriptFailed = errors.New("Script failed")
+	// ErrBadScript is returned for a script that cannot be run: malformed, too big or with an unknown opcode
+	ErrBadScript = errors.New("Script is malformed")
+	// ErrKeyNotUsed is returned when signing with a key that unlocks none of the transaction's inputs
+	ErrKeyNotUsed = errors.New("Key does not unlock any of the transaction's inputs")
+	// ErrBadMultiSig is returned for a multisig script asking for more signatures than it has keys, or with too many
+	ErrBadMultiSig = errors.New("Multisig needs 1 to n signatures from at most 7 keys")
+)
+
+// ScriptContext struct - the spend a script is run for: the input of the transaction it unlocks
+type ScriptContext struct {
+	Tx    *Transaction
+	Input int
+}
+
+// scriptOp struct - one parsed instruction, data is set for pushes
+type scriptOp struct {
+	code byte
+	data []byte
+}
+
+// parseScript function
+func parseScript(script []byte) ([]scriptOp, error) {
+	var ops []scriptOp
+
+	for i := 0; i < len(script); {
+		code := script[i]
+		i++
+
+		size := 0
+		switch {
+		case code > Op0 && code < OpPushData1:
+			size = int(code)
+		case code == OpPushData1:
+			if i+1 > len(script) {
+				return nil, ErrBadScript
+			}
+			size = int(script[i])
+			i++
+		case code == OpPushData2:
+			if i+2 > len(script) {
+				return nil, ErrBadScript
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		default:
+			ops = append(ops, scriptOp{code, nil})
+			continue
+		}
+
+		if i+size > len(script) {
+			return nil, ErrBadScript
+		}
+		ops = append(ops, scriptOp{code, script[i : i+size]})
+		i += size
+	}
+
+	return ops, nil
+}
+
+// isPush function - true for opcodes that only put something on the stack
+func (op scriptOp) isPush() bool {
+	return op.code < OpPushData2+1 || (op.code >= Op1 && op.code <= Op16)
+}
+
+// pushData function - appends the smallest push of data to the script
+func pushData(script, data []byte) []byte {
+	switch {
+	case len(data) == 0:
+		return append(script, Op0)
+	case len(data) < OpPushData1:
+		script = append(script, byte(len(data)))
+	case len(data) <= 0xff:
+		script = append(script, OpPushData1, byte(len(data)))
+	default:
+		var size [2]byte
+		binary.LittleEndian.PutUint16(size[:], uint16(len(data)))
+		script = append(append(script, OpPushData2), size[:]...)
+	}
+
+	return append(script, data...)
+}
+
+// pushNumber function - appends a push of n, using the small number opcodes when they fit
+func pushNumber(script []byte, n int64) []byte {
+	if n == 0 {
+		return append(script, Op0)
+	}
+	if n >= 1 && n <= 16 {
+		return append(script, byte(Op1+n-1))
+	}
+
+	return pushData(script, encodeScriptNum(n))
+}
+
+// encodeScriptNum function - little endian with the sign in the top bit of the last byte
+func encodeScriptNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	var result []byte
+	for n > 0 {
+		result = append(result, byte(n&0xff))
+		n >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+// decodeScriptNum function - the inverse of encodeScriptNum, for numbers of at most maxSize bytes
+func decodeScriptNum(data []byte, maxSize int) (int64, error) {
+	if len(data) > maxSize {
+		return 0, fmt.Errorf("%w: number of %d bytes", ErrScriptFailed, len(data))
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	for i, b := range data {
+		n |= int64(b) << uint(8*i)
+	}
+
+	last := data[len(data)-1]
+	if last&0x80 != 0 {
+		n &= ^(int64(0x80) << uint(8*(len(data)-1)))
+		return -n, nil
+	}
+
+	return n, nil
+}
+
+// asBool function - any non zero value is true, a negative zero is false
+func asBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+
+	return false
+}
+
+// fromBool function
+func fromBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return nil
+}
+
+// scriptEngine struct - runs scripts on one stack for a spend
+type scriptEngine struct {
+	ctx   *ScriptContext
+	stack [][]byte
+}
+
+// pop function
+func (e *scriptEngine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, fmt.Errorf("%w: stack is empty", ErrScriptFailed)
+	}
+
+	top := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+
+	return top, nil
+}
+
+// push function
+func (e *scriptEngine) push(data []byte) error {
+	if len(e.stack) >= MaxStackSize {
+		return fmt.Errorf("%w: stack is full", ErrScriptFailed)
+	}
+
+	e.stack = append(e.stack, data)
+	return nil
+}
+
+// run function - runs one script on the engine's stack
+func (e *scriptEngine) run(script []byte) error {
+	if len(script) > MaxScriptSize {
+		return fmt.Errorf("%w: %d bytes", ErrBadScript, len(script))
+	}
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, op := range ops {
+		if !op.isPush() {
+			count++
+			if count > MaxScriptOps {
+				return fmt.Errorf("%w: more than %d operations", ErrBadScript, MaxScriptOps)
+			}
+		}
+
+		if err := e.step(op, script); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// step function - runs one instruction of script
+func (e *scriptEngine) step(op scriptOp, script []byte) error {
+	switch {
+	case op.code == Op0:
+		return e.push(nil)
+	case op.code <= OpPushData2:
+		if len(op.data) > MaxPushSize {
+			return fmt.Errorf("%w: push of %d bytes", ErrBadScript, len(op.data))
+		}
+		return e.push(op.data)
+	case op.code >= Op1 && op.code <= Op16:
+		return e.push(encodeScriptNum(int64(op.code - Op1 + 1)))
+	}
+
+	switch op.code {
+	case OpVerify:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if !asBool(top) {
+			return fmt.Errorf("%w: OP_VERIFY", ErrScriptFailed)
+		}
+
+	case OpReturn:
+		return fmt.Errorf("%w: OP_RETURN", ErrScriptFailed)
+
+	case OpDrop:
+		_, err := e.pop()
+		return err
+
+	case OpDup:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.stack = append(e.stack, top)
+		return e.push(top)
+
+	case OpEqual, OpEqualVerify:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if op.code == OpEqualVerify {
+			if !bytes.Equal(a, b) {
+				return fmt.Errorf("%w: OP_EQUALVERIFY", ErrScriptFailed)
+			}
+			return nil
+		}
+		return e.push(fromBool(bytes.Equal(a, b)))
+
+	case OpSha256:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(top)
+		return e.push(hash[:])
+
+	case OpHash160:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		return e.push(wallet.PublicKeyHash(top))
+
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, err := e.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := e.pop()
+		if err != nil {
+			return err
+		}
+		valid := checkSignature(signature, pubKey, e.ctx.Tx.SigHash(e.ctx.Input, script))
+		if op.code == OpCheckSigVerify {
+			if !valid {
+				return fmt.Errorf("%w: OP_CHECKSIGVERIFY", ErrScriptFailed)
+			}
+			return nil
+		}
+		return e.push(fromBool(valid))
+
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		valid, err := e.checkMultiSig(script)
+		if err != nil {
+			return err
+		}
+		if op.code == OpCheckMultiSigVerify {
+			if !valid {
+				return fmt.Errorf("%w: OP_CHECKMULTISIGVERIFY", ErrScriptFailed)
+			}
+			return nil
+		}
+		return e.push(fromBool(valid))
+
+	case OpCheckLockTimeVerify:
+		if len(e.stack) == 0 {
+			return fmt.Errorf("%w: stack is empty", ErrScriptFailed)
+		}
+		lockTime, err := decodeScriptNum(e.stack[len(e.stack)-1], 5)
+		if err != nil {
+			return err
+		}
+		return e.checkLockTime(lockTime)
+
+	case OpCheckSequenceVerify:
+		if len(e.stack) == 0 {
+			return fmt.Errorf("%w: stack is empty", ErrScriptFailed)
+		}
+		sequence, err := decodeScriptNum(e.stack[len(e.stack)-1], 5)
+		if err != nil {
+			return err
+		}
+		return e.checkSequence(sequence)
+
+	default:
+		return fmt.Errorf("%w: unknown opcode 0x%02x", ErrBadScript, op.code)
+	}
+
+	return nil
+}
+
+// popItems function - pops n items, returned in the order they were pushed
+func (e *scriptEngine) popItems(n int) ([][]byte, error) {
+	if n > len(e.stack) {
+		return nil, fmt.Errorf("%w: stack has fewer than %d items", ErrScriptFailed, n)
+	}
+
+	items := make([][]byte, n)
+	copy(items, e.stack[len(e.stack)-n:])
+	e.stack = e.stack[:len(e.stack)-n]
+
+	return items, nil
+}
+
+// popCount function - pops a number that must be from 0 to max
+func (e *scriptEngine) popCount(max int) (int, error) {
+	top, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+	n, err := decodeScriptNum(top, 4)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > int64(max) {
+		return 0, fmt.Errorf("%w: count %d is out of range", ErrScriptFailed, n)
+	}
+
+	return int(n), nil
+}
+
+// checkMultiSig function - pops the key count, the keys, the signature count and the signatures. The signatures
+// must be in the same order as the keys they are from.
+func (e *scriptEngine) checkMultiSig(script []byte) (bool, error) {
+	n, err := e.popCount(MaxMultiSigKeys)
+	if err != nil {
+		return false, err
+	}
+	pubKeys, err := e.popItems(n)
+	if err != nil {
+		return false, err
+	}
+	m, err := e.popCount(n)
+	if err != nil {
+		return false, err
+	}
+	signatures, err := e.popItems(m)
+	if err != nil {
+		return false, err
+	}
+
+	hash := e.ctx.Tx.SigHash(e.ctx.Input, script)
+	key := 0
+	for _, signature := range signatures {
+		for key < len(pubKeys) && !checkSignature(signature, pubKeys[key], hash) {
+			key++
+		}
+		if key == len(pubKeys) {
+			return false, nil
+		}
+		key++
+	}
+
+	return true, nil
+}
+
+// checkLockTime function - the transaction's lock time must be of the same kind, height or timestamp, and at
+// or past the script's. Consensus then keeps the transaction out of blocks until its lock time has passed.
+func (e *scriptEngine) checkLockTime(lockTime int64) error {
+	if lockTime < 0 {
+		return fmt.Errorf("%w: negative lock time", ErrScriptFailed)
+	}
+
+	txLockTime := e.ctx.Tx.LockTime
+	if (lockTime < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
+		return fmt.Errorf("%w: lock time %d is not the same kind as %d", ErrScriptFailed, txLockTime, lockTime)
+	}
+	if txLockTime < lockTime {
+		return fmt.Errorf("%w: lock time %d is before %d", ErrScriptFailed, txLockTime, lockTime)
+	}
+
+	return nil
+}
+
+// checkSequence function - the input's relative lock time must be of the same kind, blocks or seconds, and at
+// least the script's. A script sequence with the disable flag set checks nothing.
+func (e *scriptEngine) checkSequence(sequence int64) error {
+	if sequence < 0 {
+		return fmt.Errorf("%w: negative sequence", ErrScriptFailed)
+	}
+	if sequence&SequenceLockDisabled != 0 {
+		return nil
+	}
+
+	txSequence := e.ctx.Tx.Inputs[e.ctx.Input].Sequence
+	if txSequence&SequenceLockDisabled != 0 {
+		return fmt.Errorf("%w: input has no relative lock time", ErrScriptFailed)
+	}
+	if int64(txSequence&SequenceLockSeconds) != sequence&SequenceLockSeconds {
+		return fmt.Errorf("%w: relative lock time is not the same kind", ErrScriptFailed)
+	}
+	if int64(txSequence&SequenceLockMask) < sequence&SequenceLockMask {
+		return fmt.Errorf("%w: relative lock time %d is less than %d", ErrScriptFailed,
+			txSequence&SequenceLockMask, sequence&SequenceLockMask)
+	}
+
+	return nil
+}
+
+// VerifyScript function - runs the unlocking script and then the locking script on the stack it leaves. The
+// spend is valid if that leaves true on top. The unlocking script may only push data.
+func VerifyScript(unlocking, locking []byte, ctx *ScriptContext) error {
+	ops, err := parseScript(unlocking)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if !op.isPush() {
+			return fmt.Errorf("%w: unlocking script does more than push data", ErrBadScript)
+		}
+	}
+
+	e := &scriptEngine{ctx: ctx}
+	if err := e.run(unlocking); err != nil {
+		return err
+	}
+
+	// for a P2SH output the last push is the redeem script, run on what the unlocking script left under it
+	p2sh := ExtractScriptHash(locking) != nil && len(e.stack) > 0
+	var redeemScript []byte
+	var redeemStack [][]byte
+	if p2sh {
+		redeemScript = e.stack[len(e.stack)-1]
+		redeemStack = append([][]byte{}, e.stack[:len(e.stack)-1]...)
+	}
+
+	if err := e.run(locking); err != nil {
+		return err
+	}
+	if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+		return ErrScriptFailed
+	}
+
+	if !p2sh {
+		return nil
+	}
+
+	e.stack = redeemStack
+	if err := e.run(redeemScript); err != nil {
+		return err
+	}
+	if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+		return fmt.Errorf("%w: redeem script", ErrScriptFailed)
+	}
+
+	return nil
+}
+
+// checkSignature function - signatures are r and s, public keys x and y, each 32 bytes big endian
+func checkSignature(signature, pubKey, hash []byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+	key, ok := decodePubKey(pubKey)
+	if !ok {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+
+	return ecdsa.Verify(key, hash, r, s)
+}
+
+// decodePubKey function - keys from wallets made before keys were padded to 64 bytes may be shorter, for those
+// the split between x and y is the one that gives a point on the curve
+func decodePubKey(pubKey []byte) (*ecdsa.PublicKey, bool) {
+	curve := elliptic.P256()
+
+	for split := len(pubKey) - 32; split <= 32; split++ {
+		if split < 0 || len(pubKey)-split > 32 {
+			continue
+		}
+		x := new(big.Int).SetBytes(pubKey[:split])
+		y := new(big.Int).SetBytes(pubKey[split:])
+		if curve.IsOnCurve(x, y) {
+			return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, true
+		}
+	}
+
+	return nil, false
+}
+
+// P2PKHScript function - the default locking script, paying whoever shows the public key with the hash and a
+// signature from it
+func P2PKHScript(pubKeyHash []byte) []byte {
+	script := []byte{OpDup, OpHash160}
+	script = pushData(script, pubKeyHash)
+
+	return append(script, OpEqualVerify, OpCheckSig)
+}
+
+// P2PKHUnlockingScript function
+func P2PKHUnlockingScript(signature, pubKey []byte) []byte {
+	return pushData(pushData(nil, signature), pubKey)
+}
+
+// ExtractPubKeyHash function - the public key hash a P2PKH script pays, nil for any other script
+func ExtractPubKeyHash(script []byte) []byte {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 5 {
+		return nil
+	}
+	if ops[0].code != OpDup || ops[1].code != OpHash160 || ops[3].code != OpEqualVerify ||
+		ops[4].code != OpCheckSig || len(ops[2].data) != 20 {
+		return nil
+	}
+
+	return ops[2].data
+}
+
+// P2SHScript function - pays whoever shows a redeem script with the hash and an unlocking script that satisfies it
+func P2SHScript(scriptHash []byte) []byte {
+	script := []byte{OpHash160}
+	script = pushData(script, scriptHash)
+
+	return append(script, OpEqual)
+}
+
+// ExtractScriptHash function - the redeem script hash a P2SH script pays, nil for any other script
+func ExtractScriptHash(script []byte) []byte {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 3 {
+		return nil
+	}
+	if ops[0].code != OpHash160 || ops[2].code != OpEqual || len(ops[1].data) != 20 {
+		return nil
+	}
+
+	return ops[1].data
+}
+
+// MultiSigScript function - a script satisfied by signatures from required of the keys, given in key order
+func MultiSigScript(required int, pubKeys [][]byte) ([]byte, error) {
+	if required < 1 || required > len(pubKeys) || len(pubKeys) > MaxMultiSigKeys {
+		return nil, ErrBadMultiSig
+	}
+
+	script := pushNumber(nil, int64(required))
+	for _, pubKey := range pubKeys {
+		if _, ok := decodePubKey(pubKey); !ok {
+			return nil, fmt.Errorf("%w: public key %x is not on the curve", ErrBadMultiSig, pubKey)
+		}
+		script = pushData(script, pubKey)
+	}
+	script = pushNumber(script, int64(len(pubKeys)))
+
+	return append(script, OpCheckMultiSig), nil
+}
+
+// ParseMultiSigScript function - the number of signatures and keys of a script made by MultiSigScript
+func ParseMultiSigScript(script []byte) (int, [][]byte, error) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 || ops[len(ops)-1].code != OpCheckMultiSig {
+		return 0, nil, ErrBadMultiSig
+	}
+
+	smallNumber := func(op scriptOp) int {
+		if op.code < Op1 || op.code > Op16 {
+			return -1
+		}
+		return int(op.code-Op1) + 1
+	}
+
+	required := smallNumber(ops[0])
+	count := smallNumber(ops[len(ops)-2])
+	if required < 1 || count < required || count > MaxMultiSigKeys || count != len(ops)-3 {
+		return 0, nil, ErrBadMultiSig
+	}
+
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		if op.data == nil {
+			return 0, nil, ErrBadMultiSig
+		}
+		pubKeys = append(pubKeys, op.data)
+	}
+
+	return required, pubKeys, nil
+}
+
+// scriptPushes function - the data a script pushes, in order
+func scriptPushes(script []byte) [][]byte {
+	ops, err := parseScript(script)
+	if err != nil {
+		return nil
+	}
+
+	var pushes [][]byte
+	for _, op := range ops {
+		if op.data != nil {
+			pushes = append(pushes, op.data)
+		}
+	}
+
+	return pushes
+}
+
+// DisassembleScript function - the script as text for the cli
+func DisassembleScript(script []byte) string {
+	ops, err := parseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[malformed %x]", script)
+	}
+
+	var words []string
+	for _, op := range ops {
+		switch {
+		case op.data != nil:
+			words = append(words, hex.EncodeToString(op.data))
+		case op.code >= Op1 && op.code <= Op16:
+			words = append(words, fmt.Sprintf("OP_%d", op.code-Op1+1))
+		case opNames[op.code] != "":
+			words = append(words, opNames[op.code])
+		default:
+			words = append(words, fmt.Sprintf("OP_UNKNOWN_0x%02x", op.code))
+		}
+	}
+
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,238 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+// spendingTx function - a transaction with one input spending some other output
+func spendingTx(lockTime int64, sequence uint32) *Transaction {
+	return &Transaction{
+		Inputs:   []TxInput{{ID: []byte("previous"), Out: 0, Sequence: sequence}},
+		Outputs:  []TxOutput{{Value: 1, Script: []byte{Op1}}},
+		LockTime: lockTime,
+	}
+}
+
+// testSignature function - w's signature of the input run against script
+func testSignature(t *testing.T, w *wallet.Wallet, tx *Transaction, script []byte) []byte {
+	t.Helper()
+
+	signature, err := signHash(w.PrivateKey, tx.SigHash(0, script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signature
+}
+
+func TestVerifyScript(t *testing.T) {
+	alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	pubKeys := [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey}
+
+	p2pkh := P2PKHScript(wallet.PublicKeyHash(alice.PublicKey))
+	redeem, err := MultiSigScript(2, pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2sh := P2SHScript(wallet.PublicKeyHash(redeem))
+
+	// multiSig function - an unlocking script with the signatures of the wallets, nil leaves a key unsigned
+	multiSig := func(tx *Transaction, redeem []byte, signers ...*wallet.Wallet) []byte {
+		signatures := make([][]byte, len(signers))
+		for i, signer := range signers {
+			if signer != nil {
+				signatures[i] = testSignature(t, signer, tx, redeem)
+			}
+		}
+		return multiSigUnlockingScript(signatures, 2, redeem)
+	}
+
+	// timeLocked function - a spend of a time locked output to alice, signed by alice
+	timeLocked := func(lock int64, relative bool, tx *Transaction) ([]byte, []byte) {
+		locking := TimeLockScript(lock, relative, wallet.PublicKeyHash(alice.PublicKey))
+		return P2PKHUnlockingScript(testSignature(t, alice, tx, locking), alice.PublicKey), locking
+	}
+
+	tests := []struct {
+		name  string
+		spend func() (tx *Transaction, unlocking, locking []byte)
+		err   error
+	}{
+		{
+			name: "p2pkh",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(0, SequenceFinal)
+				return tx, P2PKHUnlockingScript(testSignature(t, alice, tx, p2pkh), alice.PublicKey), p2pkh
+			},
+		},
+		{
+			name: "p2pkh wrong key",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(0, SequenceFinal)
+				return tx, P2PKHUnlockingScript(testSignature(t, bob, tx, p2pkh), bob.PublicKey), p2pkh
+			},
+			err: ErrScriptFailed,
+		},
+		{
+			name: "p2pkh signature of another transaction",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(0, SequenceFinal)
+				signature := testSignature(t, alice, tx, p2pkh)
+				tx.Outputs[0].Value = 2
+				return tx, P2PKHUnlockingScript(signature, alice.PublicKey), p2pkh
+			},
+			err: ErrScriptFailed,
+		},
+		{
+			name: "unlocking script does more than push",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(0, SequenceFinal)
+				unlocking := P2PKHUnlockingScript(testSignature(t, alice, tx, p2pkh), alice.PublicKey)
+				return tx, append(unlocking, OpDup, OpDrop), p2pkh
+			},
+			err: ErrBadScript,
+		},
+		{
+			name: "op_return",
+			spend: func() (*Transaction, []byte, []byte) {
+				return spendingTx(0, SequenceFinal), []byte{Op1}, []byte{OpReturn}
+			},
+			err: ErrScriptFailed,
+		},
+		{
+			name: "multisig first and third key",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(0, SequenceFinal)
+				return tx, multiSig(tx, redeem, alice, nil, carol), p2sh
+			},
+		},
+		{
+			name: "multisig one signature",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(0, SequenceFinal)
+				return tx, multiSig(tx, redeem, nil, bob), p2sh
+			},
+			err: ErrScriptFailed,
+		},
+		{
+			name: "multisig signatures out of key order",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(0, SequenceFinal)
+				unlocking := pushData(nil, testSignature(t, carol, tx, redeem))
+				unlocking = pushData(unlocking, testSignature(t, alice, tx, redeem))
+				return tx, pushData(unlocking, redeem), p2sh
+			},
+			err: ErrScriptFailed,
+		},
+		{
+			name: "multisig redeem script of another output",
+			spend: func() (*Transaction, []byte, []byte) {
+				other, err := MultiSigScript(2, pubKeys[:2])
+				if err != nil {
+					t.Fatal(err)
+				}
+				tx := spendingTx(0, SequenceFinal)
+				return tx, multiSig(tx, other, alice, bob), p2sh
+			},
+			err: ErrScriptFailed,
+		},
+		{
+			name: "lock time reached",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(100, SequenceReplaceable)
+				unlocking, locking := timeLocked(100, false, tx)
+				return tx, unlocking, locking
+			},
+		},
+		{
+			name: "lock time not reached",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(99, SequenceReplaceable)
+				unlocking, locking := timeLocked(100, false, tx)
+				return tx, unlocking, locking
+			},
+			err: ErrScriptFailed,
+		},
+		{
+			name: "lock time of another kind",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(LockTimeThreshold+100, SequenceReplaceable)
+				unlocking, locking := timeLocked(100, false, tx)
+				return tx, unlocking, locking
+			},
+			err: ErrScriptFailed,
+		},
+		{
+			name: "relative lock reached",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(0, 10)
+				unlocking, locking := timeLocked(10, true, tx)
+				return tx, unlocking, locking
+			},
+		},
+		{
+			name: "relative lock not reached",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(0, 9)
+				unlocking, locking := timeLocked(10, true, tx)
+				return tx, unlocking, locking
+			},
+			err: ErrScriptFailed,
+		},
+		{
+			name: "relative lock in seconds spent by blocks",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(0, 10)
+				unlocking, locking := timeLocked(SequenceLockSeconds|10, true, tx)
+				return tx, unlocking, locking
+			},
+			err: ErrScriptFailed,
+		},
+		{
+			name: "relative lock disabled on the input",
+			spend: func() (*Transaction, []byte, []byte) {
+				tx := spendingTx(0, SequenceFinal)
+				unlocking, locking := timeLocked(10, true, tx)
+				return tx, unlocking, locking
+			},
+			err: ErrScriptFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, unlocking, locking := test.spend()
+
+			err := VerifyScript(unlocking, locking, &ScriptContext{Tx: tx, Input: 0})
+			if test.err == nil && err != nil {
+				t.Fatalf("expected the spend to be valid, got %v", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestScriptNumbers(t *testing.T) {
+	tests := []int64{0, 1, 16, 17, 127, 128, 255, 256, 65535, LockTimeThreshold, 1<<32 - 1}
+
+	for _, n := range tests {
+		script := append(pushNumber(nil, n), OpDrop, Op1)
+		locking := TimeLockScript(n, false, make([]byte, 20))
+
+		lock, relative, _, err := ParseTimeLockScript(locking)
+		if err != nil {
+			t.Fatalf("%d: %v", n, err)
+		}
+		if lock != n || relative {
+			t.Fatalf("%d: parsed as %d, relative %t", n, lock, relative)
+		}
+		if err := VerifyScript(nil, script, &ScriptContext{Tx: spendingTx(0, SequenceFinal)}); err != nil {
+			t.Fatalf("%d: %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+var provenPrefix = []byte("proven-")
+
+// ErrBadMerkleProof is returned when a transaction does not prove out against its block's merkle root
+var ErrBadMerkleProof = errors.New("Transaction is not proven to be in the block")
+
+// HeaderChain struct - what a light (SPV) node keeps: block headers, checked for proof of work, and the wallet
+// transactions that have been proven to be in them. There are no block bodies and no UTXO set.
+type HeaderChain struct {
+	chain *BlockChain
+}
+
+// provenTx struct
+type provenTx struct {
+	Tx        Transaction
+	BlockHash []byte
+}
+
+// OpenHeaderChain function - opens the node's header store, creating it if needed
+func OpenHeaderChain(nodeID, basePath string) (*HeaderChain, error) {
+	return OpenHeaderChainWithConfig(HeadersConfig(nodeID, basePath))
+}
+
+// OpenHeaderChainWithConfig function
+func OpenHeaderChainWithConfig(config Config) (*HeaderChain, error) {
+	store, err := OpenStore(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastHash []byte
+	err = store.View(func(txn StoreTx) error {
+		var err error
+		lastHash, err = txn.Get([]byte("lh"))
+		if err == ErrKeyNotFound {
+			return nil
+		}
+
+		return err
+	})
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return &HeaderChain{&BlockChain{LastHash: lastHash, Store: store}}, nil
+}
+
+// Close function
+func (hc *HeaderChain) Close() error {
+	return hc.chain.Store.Close()
+}
+
+// AddHeaders function - see BlockChain.AddHeaders. A new store has no genesis, so the first header it is
+// given must be one and is taken on trust.
+func (hc *HeaderChain) AddHeaders(headers []*BlockHeader) error {
+	if hc.chain.LastHash == nil && len(headers) > 0 {
+		genesis := headers[0]
+		if len(genesis.PrevHash) != 0 {
+			return ErrOrphanBlock
+		}
+		if err := CheckHeader(genesis); err != nil {
+			return err
+		}
+		if err := hc.chain.checkHeaderContext(genesis); err != nil {
+			return err
+		}
+
+		err := hc.chain.Store.Update(func(txn StoreTx) error {
+			if err := txn.Set(append(headerPrefix, genesis.Hash...), genesis.Serialize()); err != nil {
+				return err
+			}
+			if err := txn.Set(append(workPrefix, genesis.Hash...), BlockWork(genesis.Difficulty).Bytes()); err != nil {
+				return err
+			}
+			return txn.Set([]byte("lh"), genesis.Hash)
+		})
+		if err != nil {
+			return err
+		}
+		hc.chain.LastHash = genesis.Hash
+	}
+
+	return hc.chain.AddHeaders(headers)
+}
+
+// BestHeader function - nil until the first headers arrive
+func (hc *HeaderChain) BestHeader() (*BlockHeader, error) {
+	if hc.chain.LastHash == nil {
+		return nil, nil
+	}
+
+	return hc.chain.BestHeader()
+}
+
+// BlockLocator function - empty until the first headers arrive, which asks a peer to start from genesis
+func (hc *HeaderChain) BlockLocator() ([][]byte, error) {
+	if hc.chain.LastHash == nil {
+		return nil, nil
+	}
+
+	return hc.chain.BlockLocator()
+}
+
+// AddMerkleBlock function - checks each transaction's proof against the merkle root of a header we have and
+// stores them once they all prove out
+func (hc *HeaderChain) AddMerkleBlock(blockHash []byte, txs []*Transaction, proofs []*MerkleProof) error {
+	header, err := hc.chain.GetHeader(blockHash)
+	if err != nil {
+		return err
+	}
+
+	if len(txs) != len(proofs) {
+		return ruleError(ErrBadMerkleProof, "%d transactions, %d proofs", len(txs), len(proofs))
+	}
+	for i, tx := range txs {
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return ruleError(ErrBadTxID, "transaction %x", tx.ID)
+		}
+		if !VerifyProof(header.MerkleRoot, tx.Serialize(), proofs[i]) {
+			return ruleError(ErrBadMerkleProof, "transaction %x", tx.ID)
+		}
+	}
+
+	return hc.chain.Store.Update(func(txn StoreTx) error {
+		for _, tx := range txs {
+			var buff bytes.Buffer
+			if err := gob.NewEncoder(&buff).Encode(provenTx{*tx, blockHash}); err != nil {
+				return err
+			}
+			if err := txn.Set(append(provenPrefix, tx.ID...), buff.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindUnspentOutputs function - the outputs locked to pubKeyHash in proven transactions on the best header
+// chain that no other proven transaction spends
+func (hc *HeaderChain) FindUnspentOutputs(pubKeyHash []byte) ([]TxOutput, error) {
+	var UTXOs []TxOutput
+
+	best, err := hc.BestHeader()
+	if err != nil || best == nil {
+		return nil, err
+	}
+
+	onChain := make(map[string]bool)
+	for header := best; ; {
+		onChain[string(header.Hash)] = true
+		if len(header.PrevHash) == 0 {
+			break
+		}
+		if header, err = hc.chain.GetHeader(header.PrevHash); err != nil {
+			return nil, err
+		}
+	}
+
+	var txs []Transaction
+	err = hc.chain.Store.View(func(txn StoreTx) error {
+		return txn.Iterate(provenPrefix, false, func(_, v []byte) error {
+			var proven provenTx
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&proven); err != nil {
+				return err
+			}
+			if onChain[string(proven.BlockHash)] {
+				txs = append(txs, proven.Tx)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	spent := make(map[string]bool)
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] = true
+		}
+	}
+
+	for _, tx := range txs {
+		for outIdx, out := range tx.Outputs {
+			if out.IsLockedWithHash(pubKeyHash) && !spent[fmt.Sprintf("%x:%d", tx.ID, outIdx)] {
+				UTXOs = append(UTXOs, out)
+			}
+		}
+	}
+
+	return UTXOs, nil
+}
+
+// FilterBlock function - the block's transactions that pay to or spend from any of the public key hashes, each
+// with the proof that it is in the block
+func FilterBlock(block *Block, pubKeyHashes [][]byte) ([]*Transaction, []*MerkleProof, error) {
+	var txs []*Transaction
+	var proofs []*MerkleProof
+
+	tree := block.merkleTree()
+
+	for i, tx := range block.Transactions {
+		if !txMatches(tx, pubKeyHashes) {
+			continue
+		}
+
+		proof, err := tree.Proof(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		txs = append(txs, tx)
+		proofs = append(proofs, proof)
+	}
+
+	return txs, proofs, nil
+}
+
+// txMatches function
+func txMatches(tx *Transaction, pubKeyHashes [][]byte) bool {
+	for _, pubKeyHash := range pubKeyHashes {
+		for _, out := range tx.Outputs {
+			if out.IsLockedWithHash(pubKeyHash) {
+				return true
+			}
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if in.UsesKey(pubKeyHash) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
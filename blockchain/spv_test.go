@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+func TestHeaderChain(t *testing.T) {
+	chain, w := newTestChain(t)
+	other := newTestWallet(t)
+	watched := [][]byte{wallet.PublicKeyHash(other.PublicKey)}
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := genesis.Transactions[0]
+	value := coins.Outputs[0].Value
+
+	payment := spendTestTx(t, w, coins, 0, testOutput(t, 5, other), testOutput(t, value-5, w))
+	mineTestBlock(t, chain, w, payment)
+	mineTestBlock(t, chain, w)
+
+	light, err := OpenHeaderChainWithConfig(Config{Backend: MemoryBackend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer light.Close()
+
+	var blocks []*Block
+	var headers []*BlockHeader
+	for height := 0; height <= 2; height++ {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, &block)
+		headers = append(headers, block.Header())
+	}
+	if err := light.AddHeaders(headers); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range blocks {
+		txs, proofs, err := FilterBlock(block, watched)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txs) != 0 && block.Height != 1 {
+			t.Fatalf("block %d has %d transactions for the watched key", block.Height, len(txs))
+		}
+		if err := light.AddMerkleBlock(block.Hash, txs, proofs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	UTXOs, err := light.FindUnspentOutputs(watched[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(UTXOs) != 1 || UTXOs[0].Value != 5 {
+		t.Fatalf("expected the one payment of 5, got %v", UTXOs)
+	}
+
+	// a transaction proven against the wrong block is refused
+	txs, proofs, err := FilterBlock(blocks[1], watched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := light.AddMerkleBlock(blocks[2].Hash, txs, proofs); !errors.Is(err, ErrBadMerkleProof) {
+		t.Fatalf("expected %v, got %v", ErrBadMerkleProof, err)
+	}
+}
@@ -0,0 +1,137 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// BadgerBackend keeps the chain in a badger database on disk
+	BadgerBackend = "badger"
+	// MemoryBackend keeps the chain in memory, it is gone once the chain is closed
+	MemoryBackend = "memory"
+)
+
+var (
+	// ErrKeyNotFound is returned by a store for a key it does not have
+	ErrKeyNotFound = errors.New("Key is not found")
+	// ErrStopIteration can be returned from an Iterate callback to stop early without an error
+	ErrStopIteration = errors.New("Stop iteration")
+	// ErrUnknownBackend is returned for a Config with a backend we do not have
+	ErrUnknownBackend = errors.New("Unknown storage backend")
+)
+
+// ChainStore interface - where a chain keeps its blocks, metadata, UTXO set and indexes, each under its own key
+// prefix. All reads and writes go through a StoreTx, and the writes of one Update are applied together or not
+// at all.
+type ChainStore interface {
+	View(fn func(txn StoreTx) error) error
+	Update(fn func(txn StoreTx) error) error
+	Close() error
+}
+
+// StoreTx interface - one read or read-write transaction on a ChainStore. Reads in an Update see its own writes.
+type StoreTx interface {
+	// Get returns ErrKeyNotFound for a missing key
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	// Iterate calls fn for each key with the prefix in key order, or reverse key order
+	Iterate(prefix []byte, reverse bool, fn func(key, value []byte) error) error
+}
+
+// Config struct - how and where a chain is stored
+type Config struct {
+	Backend string
+	Path    string // the database directory, for the badger backend
+}
+
+// DefaultConfig function - the badger database for a node, under basePath
+func DefaultConfig(nodeID, basePath string) Config {
+	return Config{BadgerBackend, filepath.Join(basePath, "blocks_"+nodeID)}
+}
+
+// HistoryConfig function - a scratch badger database for a node, under basePath, to check the history before a
+// UTXO snapshot in
+func HistoryConfig(nodeID, basePath string) Config {
+	return Config{BadgerBackend, filepath.Join(basePath, "history_"+nodeID)}
+}
+
+// HeadersConfig function - the badger database for a light node's headers, under basePath
+func HeadersConfig(nodeID, basePath string) Config {
+	return Config{BadgerBackend, filepath.Join(basePath, "headers_"+nodeID)}
+}
+
+// OpenStore function
+func OpenStore(config Config) (ChainStore, error) {
+	switch config.Backend {
+	case BadgerBackend:
+		return openBadgerStore(config.Path)
+	case MemoryBackend:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownBackend, config.Backend)
+	}
+}
+
+// storeExists function - true if the config points at a store that has been written to
+func storeExists(config Config) bool {
+	switch config.Backend {
+	case BadgerBackend:
+		return DBexists(config.Path)
+	default:
+		return false
+	}
+}
+
+// removeStore function - deletes everything a closed store wrote
+func removeStore(config Config) error {
+	switch config.Backend {
+	case BadgerBackend:
+		return os.RemoveAll(config.Path)
+	default:
+		return nil
+	}
+}
+
+// deleteByPrefix function - deletes every key with the prefix, in batches so no one write gets too big
+func deleteByPrefix(store ChainStore, prefix []byte) error {
+	collectSize := 100000
+
+	for {
+		var keys [][]byte
+		err := store.View(func(txn StoreTx) error {
+			return txn.Iterate(prefix, false, func(key, _ []byte) error {
+				keys = append(keys, key)
+				if len(keys) == collectSize {
+					return ErrStopIteration
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		err = store.Update(func(txn StoreTx) error {
+			for _, key := range keys {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(keys) < collectSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	configs := []Config{
+		{Backend: MemoryBackend},
+		{Backend: BadgerBackend, Path: filepath.Join(t.TempDir(), "nested", "blocks")},
+	}
+
+	for _, config := range configs {
+		t.Run(config.Backend, func(t *testing.T) {
+			store, err := OpenStore(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			err = store.Update(func(txn StoreTx) error {
+				for _, key := range []string{"a-2", "a-1", "a-3", "b-1"} {
+					if err := txn.Set([]byte(key), []byte("value "+key)); err != nil {
+						return err
+					}
+				}
+				// reads see the transaction's own writes
+				value, err := txn.Get([]byte("a-2"))
+				if err != nil {
+					return err
+				}
+				if string(value) != "value a-2" {
+					t.Fatalf("read %q back in the transaction", value)
+				}
+				return txn.Delete([]byte("a-3"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// a failed update writes nothing
+			failed := errors.New("failed")
+			err = store.Update(func(txn StoreTx) error {
+				if err := txn.Set([]byte("a-4"), []byte("value a-4")); err != nil {
+					return err
+				}
+				return failed
+			})
+			if err != failed {
+				t.Fatalf("expected %v, got %v", failed, err)
+			}
+
+			err = store.View(func(txn StoreTx) error {
+				for _, key := range []string{"a-3", "a-4"} {
+					if _, err := txn.Get([]byte(key)); err != ErrKeyNotFound {
+						t.Fatalf("%s: expected %v, got %v", key, ErrKeyNotFound, err)
+					}
+				}
+
+				for _, reverse := range []bool{false, true} {
+					var keys [][]byte
+					err := txn.Iterate([]byte("a-"), reverse, func(key, value []byte) error {
+						if string(value) != "value "+string(key) {
+							t.Fatalf("%s holds %q", key, value)
+						}
+						keys = append(keys, key)
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+
+					expected := [][]byte{[]byte("a-1"), []byte("a-2")}
+					if reverse {
+						expected[0], expected[1] = expected[1], expected[0]
+					}
+					if len(keys) != len(expected) || !bytes.Equal(keys[0], expected[0]) || !bytes.Equal(keys[1], expected[1]) {
+						t.Fatalf("reverse %t: iterated %q", reverse, keys)
+					}
+				}
+
+				count := 0
+				err := txn.Iterate([]byte("a-"), false, func(_, _ []byte) error {
+					count++
+					return ErrStopIteration
+				})
+				if err != nil || count != 1 {
+					t.Fatalf("expected ErrStopIteration to stop after one key without an error, got %d: %v", count, err)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestReopenChain(t *testing.T) {
+	basePath := filepath.Join(t.TempDir(), "data")
+	w := newTestWallet(t)
+
+	chain, err := InitBlockChainWithConfig(string(w.Address()), DefaultConfig("3000", basePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastHash := chain.LastHash
+	if err := chain.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := InitBlockChainWithConfig(string(w.Address()), DefaultConfig("3000", basePath)); err == nil {
+		t.Fatal("expected a second chain in the same place to be refused")
+	}
+
+	chain, err = ContinueBlockChain("3000", basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	if !bytes.Equal(chain.LastHash, lastHash) {
+		t.Fatal("the reopened chain has a different tip")
+	}
+
+	if _, err := OpenStore(Config{Backend: "bolt"}); !errors.Is(err, ErrUnknownBackend) {
+		t.Fatalf("expected %v, got %v", ErrUnknownBackend, err)
+	}
+}
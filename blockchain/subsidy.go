@@ -0,0 +1,55 @@
+package blockchain
+
+// The emission schedule. Every node on a network must use the same values.
+var (
+	// InitialSubsidy is the amount a coinbase may create before the first halving
+	InitialSubsidy = 20
+	// HalvingInterval is the number of blocks between each halving of the subsidy
+	HalvingInterval = 10000
+	// CoinbaseMaturity is the number of blocks after a coinbase before its outputs can be spent
+	CoinbaseMaturity = 100
+)
+
+// MaxMoney is the most an output, or any sum of values in a transaction or block, may be worth. It is far above
+// anything the emission schedule creates and small enough that adding two such values cannot overflow an int.
+const MaxMoney = 21000000
+
+// addMoney function - total plus value, false if value is negative or the sum passes MaxMoney
+func addMoney(total, value int) (int, bool) {
+	if value < 0 || value > MaxMoney || total+value > MaxMoney {
+		return 0, false
+	}
+
+	return total + value, true
+}
+
+// Subsidy function - the amount a coinbase at the given height may create on top of the fees it collects.
+// It halves every HalvingInterval blocks until it reaches zero.
+func Subsidy(height int) int {
+	halvings := height / HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+
+	return InitialSubsidy >> uint(halvings)
+}
+
+// TotalSupply function - every coin created by coinbase subsidies from genesis up to and including height
+func TotalSupply(height int) int {
+	total := 0
+
+	for start := 0; start <= height; start += HalvingInterval {
+		reward := Subsidy(start)
+		if reward == 0 {
+			break
+		}
+
+		end := start + HalvingInterval - 1
+		if end > height {
+			end = height
+		}
+		total += reward * (end - start + 1)
+	}
+
+	return total
+}
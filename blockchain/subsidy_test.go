@@ -0,0 +1,72 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSubsidy(t *testing.T) {
+	tests := []struct {
+		height  int
+		subsidy int
+	}{
+		{0, InitialSubsidy},
+		{HalvingInterval - 1, InitialSubsidy},
+		{HalvingInterval, InitialSubsidy / 2},
+		{2*HalvingInterval + 1, InitialSubsidy / 4},
+		{10 * HalvingInterval, 0},
+		{64 * HalvingInterval, 0},
+	}
+
+	for _, test := range tests {
+		if subsidy := Subsidy(test.height); subsidy != test.subsidy {
+			t.Fatalf("height %d: expected %d, got %d", test.height, test.subsidy, subsidy)
+		}
+	}
+}
+
+func TestTotalSupply(t *testing.T) {
+	interval := HalvingInterval
+	HalvingInterval = 5
+	t.Cleanup(func() { HalvingInterval = interval })
+
+	// add up every block's subsidy until it stops for good
+	total := 0
+	for height := 0; height < 64*HalvingInterval; height++ {
+		total += Subsidy(height)
+		if supply := TotalSupply(height); supply != total {
+			t.Fatalf("height %d: expected %d, got %d", height, total, supply)
+		}
+	}
+
+	if TotalSupply(1000000) != total {
+		t.Fatal("the supply kept growing after the subsidy ran out")
+	}
+	if total > MaxMoney {
+		t.Fatalf("the supply of %d passes MaxMoney", total)
+	}
+}
+
+func TestCoinbaseAfterHalving(t *testing.T) {
+	interval := HalvingInterval
+	HalvingInterval = 2
+	t.Cleanup(func() { HalvingInterval = interval })
+
+	chain, w := newTestChain(t)
+	tip := mineTestBlock(t, chain, w)
+
+	// a coinbase claiming the subsidy from before the halving
+	cbTx, err := CoinbaseTx(string(w.Address()), "", tip.Height, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := &Block{BlockHeader{PrevHash: tip.Hash, Height: 2}, []*Transaction{cbTx}}
+	if err := chain.checkBlockTransactions(block); !errors.Is(err, ErrBadCoinbaseValue) {
+		t.Fatalf("expected %v, got %v", ErrBadCoinbaseValue, err)
+	}
+
+	if block := mineTestBlock(t, chain, w); block.Transactions[0].Outputs[0].Value != InitialSubsidy/2 {
+		t.Fatalf("expected a coinbase of %d, got %d", InitialSubsidy/2, block.Transactions[0].Outputs[0].Value)
+	}
+}
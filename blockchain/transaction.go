@@ -3,31 +3,47 @@ package blockchain
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
-	"math/big"
 	"strings"
 
 	"github.com/jlynch25/golang-blockchain/wallet"
 )
 
+// ChangeOutput is the index newUnsignedTransaction puts the change at, straight after the payment
+const ChangeOutput = 1
+
+var (
+	// ErrInsufficientFunds is returned when a wallet cannot cover a payment
+	ErrInsufficientFunds = errors.New("Not enough funds")
+	// ErrPrevTxNotFound is returned when signing without every transaction the inputs spend
+	ErrPrevTxNotFound = errors.New("Previous transaction is not correct")
+)
+
 // Transaction struct
 type Transaction struct {
-	ID      []byte
-	Inputs  []TxInput
-	Outputs []TxOutput
+	ID       []byte
+	Inputs   []TxInput
+	Outputs  []TxOutput
+	LockTime int64
 }
 
-// Hash function
+// Hash function - unlocking scripts are left out, so the ID is fixed before signing and re-signing cannot
+// change it. A coinbase keeps its script, it is what makes each coinbase unique.
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 	txCopy := *tx
 	txCopy.ID = []byte{}
+	if !tx.IsCoinbase() {
+		txCopy.Inputs = make([]TxInput, len(tx.Inputs))
+		for i, in := range tx.Inputs {
+			txCopy.Inputs[i] = TxInput{in.ID, in.Out, nil, in.Sequence}
+		}
+	}
 
 	hash = sha256.Sum256(txCopy.Serialize())
 
@@ -46,69 +62,143 @@ func (tx Transaction) Serialize() []byte {
 }
 
 // DeserializeTransaction function
-func DeserializeTransaction(data []byte) Transaction {
+func DeserializeTransaction(data []byte) (Transaction, error) {
 	var transaction Transaction
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&transaction)
-	Handle(err)
-	return transaction
+	return transaction, err
 }
 
-// CoinbaseTx function
-func CoinbaseTx(to, data string) *Transaction {
+// CoinbaseTx function - pays the subsidy for the block's height plus the fees collected from its other transactions
+func CoinbaseTx(to, data string, height, fees int) (*Transaction, error) {
 	if data == "" {
 		randData := make([]byte, 20)
-		_, err := rand.Read(randData)
-		Handle(err)
+		if _, err := rand.Read(randData); err != nil {
+			return nil, err
+		}
 
 		data = fmt.Sprintf("%x", randData)
 	}
 
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTxOutput(20, to)
+	txin := TxInput{[]byte{}, -1, []byte(data), SequenceFinal}
+	txout, err := NewTxOutput(Subsidy(height)+fees, to)
+	if err != nil {
+		return nil, err
+	}
 
-	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
+	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}, 0}
 	tx.ID = tx.Hash()
 
-	return &tx
+	return &tx, nil
 }
 
-// NewTransaction function
-func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Transaction {
+// NewTransaction function - fee is left out of the outputs for the miner to collect
+func NewTransaction(w *wallet.Wallet, to string, amount, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	from := fmt.Sprintf("%s", w.Address())
+
+	tx, err := newUnsignedTransaction(from, to, amount, fee, UTXO)
+	if err != nil {
+		return nil, err
+	}
+	if err := UTXO.Blockchain.SignTransaction(tx, w.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// newUnsignedTransaction function - spends outputs paying from, with the change going back to it at
+// ChangeOutput. The inputs signal replace-by-fee so the fee can be bumped while the transaction waits in the pool.
+func newUnsignedTransaction(from, to string, amount, fee int, UTXO *UTXOSet) (*Transaction, error) {
 	var inputs []TxInput
 	var outputs []TxOutput
 
-	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
-	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount)
+	if amount <= 0 || fee < 0 {
+		return nil, ErrBadTxOutput
+	}
+
+	hash, err := wallet.AddressPubKeyHash(from)
+	if err != nil {
+		return nil, err
+	}
+	acc, validOutputs, err := UTXO.FindSpendableOutputs(hash, amount+fee)
+	if err != nil {
+		return nil, err
+	}
 
-	if acc < amount {
-		log.Panic("Error: not enough funds")
+	if acc < amount+fee {
+		return nil, ErrInsufficientFunds
 	}
 
 	for txid, outs := range validOutputs {
 		txID, err := hex.DecodeString(txid)
-		Handle(err)
+		if err != nil {
+			return nil, err
+		}
 
 		for _, out := range outs {
-			input := TxInput{txID, out, nil, w.PublicKey}
+			input := TxInput{txID, out, nil, SequenceReplaceable}
 			inputs = append(inputs, input)
 		}
 	}
 
-	from := fmt.Sprintf("%s", w.Address())
-
-	outputs = append(outputs, *NewTxOutput(amount, to))
+	output, err := NewTxOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+	outputs = append(outputs, *output)
 
-	if acc > amount {
-		outputs = append(outputs, *NewTxOutput(acc-amount, from))
+	if acc > amount+fee {
+		change, err := NewTxOutput(acc-amount-fee, from)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *change)
 	}
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, inputs, outputs, 0}
 	tx.ID = tx.Hash()
-	UTXO.Blockchain.SignTransaction(&tx, w.PrivateKey)
 
-	return &tx
+	return &tx, nil
+}
+
+// OutputValue function - the total paid out by the transaction. Returns ErrBadTxOutput if an output is
+// negative or the total passes MaxMoney.
+func (tx *Transaction) OutputValue() (int, error) {
+	total := 0
+	for _, out := range tx.Outputs {
+		var ok bool
+		if total, ok = addMoney(total, out.Value); !ok {
+			return 0, ErrBadTxOutput
+		}
+	}
+
+	return total, nil
+}
+
+// Fee function - what the inputs bring in minus what the outputs pay out. prevTXs must hold every
+// transaction the inputs spend.
+func (tx *Transaction) Fee(prevTXs map[string]Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	inputValue := 0
+	for _, in := range tx.Inputs {
+		var ok bool
+		value := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out].Value
+		if inputValue, ok = addMoney(inputValue, value); !ok {
+			return 0, ErrValueOutOfRange
+		}
+	}
+
+	outputValue, err := tx.OutputValue()
+	if err != nil {
+		return 0, err
+	}
+
+	return inputValue - outputValue, nil
 }
 
 // IsCoinbase function
@@ -116,72 +206,105 @@ func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
 
-// Sign function
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+// Sign function - signs each input spending a P2PKH output locked to the key, leaving the others as they are
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
-		}
+	if !hasPrevOutputs(tx, prevTXs) {
+		return ErrPrevTxNotFound
 	}
 
-	txCopy := tx.TrimmedCopy()
+	// wallets made before public keys were padded hold the unpadded encoding, and their addresses hash it
+	pubKeys := [][]byte{
+		wallet.PublicKeyBytes(&privKey.PublicKey),
+		append(privKey.PublicKey.X.Bytes(), privKey.PublicKey.Y.Bytes()...),
+	}
 
-	for inID, in := range txCopy.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inID].Signature = nil
-		txCopy.Inputs[inID].PubKey = prevTX.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inID].PubKey = nil
+	signed := false
+	for inID, in := range tx.Inputs {
+		prevOut := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+
+		var pubKey []byte
+		for _, key := range pubKeys {
+			if prevOut.IsLockedWithKey(wallet.PublicKeyHash(key)) {
+				pubKey = key
+				break
+			}
+		}
+		if pubKey == nil {
+			continue
+		}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
-		Handle(err)
-		signature := append(r.Bytes(), s.Bytes()...)
+		signature, err := signHash(privKey, tx.SigHash(inID, prevOut.Script))
+		if err != nil {
+			return err
+		}
+		tx.Inputs[inID].Script = P2PKHUnlockingScript(signature, pubKey)
+		signed = true
+	}
 
-		tx.Inputs[inID].Signature = signature
+	if !signed {
+		return ErrKeyNotUsed
+	}
 
+	return nil
+}
+
+// signHash function - the signature is r and s, each padded to 32 bytes
+func signHash(privKey ecdsa.PrivateKey, hash []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
+	if err != nil {
+		return nil, err
 	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signature, nil
 }
 
-// Verify funtion
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+// SigHash function - the hash an input's signature signs: the transaction with every unlocking script left out
+// and the script being run in place of the input's own
+func (tx *Transaction) SigHash(input int, script []byte) []byte {
+	txCopy := tx.TrimmedCopy()
+	txCopy.ID = nil
+	txCopy.Inputs[input].Script = script
+
+	hash := sha256.Sum256(txCopy.Serialize())
+
+	return hash[:]
+}
+
+// Verify funtion - runs each input's unlocking script against the locking script of the output it spends
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+	if !hasPrevOutputs(tx, prevTXs) {
+		return ErrPrevTxNotFound
+	}
+
+	for inID, in := range tx.Inputs {
+		prevOut := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+
+		ctx := ScriptContext{tx, inID}
+		if err := VerifyScript(in.Script, prevOut.Script, &ctx); err != nil {
+			return fmt.Errorf("input %d: %w", inID, err)
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+	return nil
+}
 
-	for inID, in := range tx.Inputs {
+// hasPrevOutputs function - checks prevTXs holds every output the inputs spend
+func hasPrevOutputs(tx *Transaction, prevTXs map[string]Transaction) bool {
+	for _, in := range tx.Inputs {
 		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inID].Signature = nil
-		txCopy.Inputs[inID].PubKey = prevTX.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inID].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[(keyLen / 2):])
-
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
+		if prevTX.ID == nil || in.Out < 0 || in.Out >= len(prevTX.Outputs) {
 			return false
 		}
 	}
@@ -195,14 +318,14 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TxOutput
 
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{in.ID, in.Out, nil, nil})
+		inputs = append(inputs, TxInput{in.ID, in.Out, nil, in.Sequence})
 	}
 
 	for _, out := range tx.Outputs {
-		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TxOutput{out.Value, out.Script})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.LockTime}
 
 	return txCopy
 }
@@ -216,14 +339,24 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:     %x", input.ID))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("       Data:      %x", input.Script))
+		} else {
+			lines = append(lines, fmt.Sprintf("       Script:    %s", DisassembleScript(input.Script)))
+		}
+		if input.Sequence != SequenceFinal {
+			lines = append(lines, fmt.Sprintf("       Sequence:  %d", input.Sequence))
+		}
 	}
 
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", DisassembleScript(output.Script)))
+	}
+
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     Lock time: %d", tx.LockTime))
 	}
 
 	return strings.Join(lines, "\n")
@@ -7,10 +7,10 @@ import (
 	"github.com/jlynch25/golang-blockchain/wallet"
 )
 
-// TxOutput struct
+// TxOutput struct - Script is the locking script a spend of the output has to satisfy
 type TxOutput struct {
-	Value      int
-	PubKeyHash []byte
+	Value  int
+	Script []byte
 }
 
 // TxOutputs struct
@@ -18,39 +18,79 @@ type TxOutputs struct {
 	Outputs []TxOutput
 }
 
-// TxInput struct
+// TxInput struct - Script is the unlocking script, for a coinbase it holds arbitrary data. Sequence is a relative
+// lock time on the output spent, SequenceFinal for none.
 type TxInput struct {
-	ID        []byte
-	Out       int
-	Signature []byte
-	PubKey    []byte
+	ID       []byte
+	Out      int
+	Script   []byte
+	Sequence uint32
 }
 
-// UsesKey function
+// UsesKey function - true if the unlocking script shows the public key with the hash
 func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := wallet.PublicKeyHash(in.PubKey)
+	for _, data := range scriptPushes(in.Script) {
+		if bytes.Equal(wallet.PublicKeyHash(data), pubKeyHash) {
+			return true
+		}
+	}
 
-	return bytes.Compare(lockingHash, pubKeyHash) == 0
+	return false
 }
 
-// Lock function
-func (out *TxOutput) Lock(address []byte) {
-	pubKeyHash := wallet.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+// Lock function - P2PKH for a wallet address, P2SH for a script address
+func (out *TxOutput) Lock(address []byte) error {
+	version, hash, err := wallet.DecodeAddress(string(address))
+	if err != nil {
+		return err
+	}
+
+	if version == wallet.ScriptHashVersion {
+		out.Script = P2SHScript(hash)
+	} else {
+		out.Script = P2PKHScript(hash)
+	}
+
+	return nil
+}
+
+// PubKeyHash function - the public key hash the output pays, nil if it is not locked with P2PKH
+func (out *TxOutput) PubKeyHash() []byte {
+	return ExtractPubKeyHash(out.Script)
 }
 
 // IsLockedWithKey function
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	hash := out.PubKeyHash()
+
+	return hash != nil && bytes.Equal(hash, pubKeyHash)
+}
+
+// AddressHash function - the hash in the address the output pays, of a public key or a redeem script. nil if
+// the script is not one an address stands for.
+func (out *TxOutput) AddressHash() []byte {
+	if hash := out.PubKeyHash(); hash != nil {
+		return hash
+	}
+
+	return ExtractScriptHash(out.Script)
+}
+
+// IsLockedWithHash function - true if the output pays the address with the hash
+func (out *TxOutput) IsLockedWithHash(hash []byte) bool {
+	addressHash := out.AddressHash()
+
+	return addressHash != nil && bytes.Equal(addressHash, hash)
 }
 
 // NewTxOutput function
-func NewTxOutput(value int, address string) *TxOutput {
+func NewTxOutput(value int, address string) (*TxOutput, error) {
 	txo := &TxOutput{value, nil}
-	txo.Lock([]byte(address))
+	if err := txo.Lock([]byte(address)); err != nil {
+		return nil, err
+	}
 
-	return txo
+	return txo, nil
 }
 
 // Serialize function
@@ -63,10 +103,9 @@ func (outs TxOutputs) Serialize() []byte {
 }
 
 // DeserializeOutputs function
-func DeserializeOutputs(data []byte) TxOutputs {
+func DeserializeOutputs(data []byte) (TxOutputs, error) {
 	var outputs TxOutputs
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&outputs)
-	Handle(err)
-	return outputs
+	return outputs, err
 }
@@ -0,0 +1,216 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+var (
+	txIndexPrefix = []byte("tx-")
+	// set once the transaction index covers the whole main chain
+	txIndexKey = []byte("txindexed")
+)
+
+// TxLocation struct - where a main chain transaction is: its block and its position in the block
+type TxLocation struct {
+	BlockHash []byte
+	Position  int
+}
+
+// Serialize function
+func (loc TxLocation) Serialize() []byte {
+	var buff bytes.Buffer
+
+	encode := gob.NewEncoder(&buff)
+	Handle(encode.Encode(loc))
+
+	return buff.Bytes()
+}
+
+// DeserializeTxLocation function
+func DeserializeTxLocation(data []byte) (TxLocation, error) {
+	var loc TxLocation
+
+	decode := gob.NewDecoder(bytes.NewReader(data))
+	err := decode.Decode(&loc)
+
+	return loc, err
+}
+
+// indexTransactions function - points each of the block's transactions at the block
+func indexTransactions(txn StoreTx, block *Block) error {
+	for i, tx := range block.Transactions {
+		loc := TxLocation{block.Hash, i}
+		if err := txn.Set(append(txIndexPrefix, tx.ID...), loc.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexTransactions function - drops the block's transactions from the index
+func unindexTransactions(txn StoreTx, block *Block) error {
+	for _, tx := range block.Transactions {
+		if err := txn.Delete(append(txIndexPrefix, tx.ID...)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FindTxLocation function - looks a main chain transaction up in the index
+func (chain *BlockChain) FindTxLocation(ID []byte) (TxLocation, error) {
+	var loc TxLocation
+
+	err := chain.Store.View(func(txn StoreTx) error {
+		var err error
+		loc, err = getTxLocation(txn, ID)
+		return err
+	})
+
+	return loc, err
+}
+
+// getTxLocation function
+func getTxLocation(txn StoreTx, ID []byte) (TxLocation, error) {
+	v, err := txn.Get(append(txIndexPrefix, ID...))
+	if err == ErrKeyNotFound {
+		return TxLocation{}, ErrTxNotFound
+	}
+	if err != nil {
+		return TxLocation{}, err
+	}
+
+	return DeserializeTxLocation(v)
+}
+
+// findTransaction function - FindTransaction within a database transaction, so it sees that transaction's writes
+func findTransaction(txn StoreTx, ID []byte) (Transaction, error) {
+	loc, err := getTxLocation(txn, ID)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	blockData, err := txn.Get(loc.BlockHash)
+	if err == ErrKeyNotFound {
+		return Transaction{}, ErrBlockNotFound
+	}
+	if err != nil {
+		return Transaction{}, err
+	}
+	block, err := Deserialize(blockData)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	if loc.Position < 0 || loc.Position >= len(block.Transactions) ||
+		!bytes.Equal(block.Transactions[loc.Position].ID, ID) {
+		return Transaction{}, ErrTxNotFound
+	}
+
+	return *block.Transactions[loc.Position], nil
+}
+
+// indexBlock function - adds a block joining the main chain to the height and transaction indexes, and to the
+// address index if it is kept
+func indexBlock(txn StoreTx, block *Block) error {
+	if err := indexHeight(txn, block); err != nil {
+		return err
+	}
+	if err := indexTransactions(txn, block); err != nil {
+		return err
+	}
+
+	enabled, err := addrIndexEnabled(txn)
+	if err != nil || !enabled {
+		return err
+	}
+
+	return indexAddresses(txn, block)
+}
+
+// unindexBlock function - undoes indexBlock for the tip block as it leaves the main chain
+func unindexBlock(txn StoreTx, block *Block) error {
+	enabled, err := addrIndexEnabled(txn)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		if err := unindexAddresses(txn, block); err != nil {
+			return err
+		}
+	}
+
+	if err := unindexTransactions(txn, block); err != nil {
+		return err
+	}
+
+	return unindexHeight(txn, block)
+}
+
+// indexBuilt function - false for a database written before the index with the given marker key existed
+func (chain *BlockChain) indexBuilt(key []byte) (bool, error) {
+	err := chain.Store.View(func(txn StoreTx) error {
+		_, err := txn.Get(key)
+		return err
+	})
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// ReindexTransactions function - rebuilds the transaction index from the main chain
+func (chain *BlockChain) ReindexTransactions() error {
+	err := chain.Store.Update(func(txn StoreTx) error {
+		return txn.Delete(txIndexKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := deleteByPrefix(chain.Store, txIndexPrefix); err != nil {
+		return err
+	}
+
+	iter := chain.Iterator()
+
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		err = chain.Store.Update(func(txn StoreTx) error {
+			return indexTransactions(txn, block)
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return chain.Store.Update(func(txn StoreTx) error {
+		return txn.Set(txIndexKey, []byte{1})
+	})
+}
+
+// CountIndexedTransactions function
+func (chain *BlockChain) CountIndexedTransactions() (int, error) {
+	counter := 0
+
+	err := chain.Store.View(func(txn StoreTx) error {
+		return txn.Iterate(txIndexPrefix, false, func(_, _ []byte) error {
+			counter++
+			return nil
+		})
+	})
+
+	return counter, err
+}
@@ -2,227 +2,431 @@ package blockchain
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/gob"
 	"encoding/hex"
-	"log"
-
-	"github.com/dgraph-io/badger"
+	"errors"
+	"fmt"
 )
 
 var (
 	utxoPrefix   = []byte("utxo-")
 	prefixLength = len(utxoPrefix)
+	undoPrefix   = []byte("undo-")
+	// the block the UTXO set is up to date with
+	utxoBestKey = []byte("ub")
+	// set once the UTXO set holds an entry per output, a set written before that is rebuilt
+	utxoFormatKey = []byte("utxooutpoints")
 )
 
+var (
+	// ErrNotUTXOTip is returned when reverting a block the UTXO set is not up to date with
+	ErrNotUTXOTip = errors.New("The UTXO set is not at this block")
+	// ErrNoUndoRecord is returned when reverting a block whose undo record is missing
+	ErrNoUndoRecord = errors.New("The block has no undo record")
+)
+
+// UTXOEntry struct - an unspent output, with the height of the block that created it and whether it was
+// created by a coinbase
+type UTXOEntry struct {
+	Output   TxOutput
+	Height   int
+	Coinbase bool
+}
+
+// Serialize function
+func (entry UTXOEntry) Serialize() []byte {
+	var buff bytes.Buffer
+
+	encode := gob.NewEncoder(&buff)
+	Handle(encode.Encode(entry))
+
+	return buff.Bytes()
+}
+
+// DeserializeUTXOEntry function
+func DeserializeUTXOEntry(data []byte) (UTXOEntry, error) {
+	var entry UTXOEntry
+
+	decode := gob.NewDecoder(bytes.NewReader(data))
+	err := decode.Decode(&entry)
+
+	return entry, err
+}
+
+// IsMature function - true if the output can be spent in a block at the height
+func (entry UTXOEntry) IsMature(height int) bool {
+	return !entry.Coinbase || height-entry.Height >= CoinbaseMaturity
+}
+
+// utxoKey function - the key of one output, big endian so a transaction's outputs sort by index
+func utxoKey(txID []byte, vout int) []byte {
+	var out [4]byte
+	binary.BigEndian.PutUint32(out[:], uint32(vout))
+
+	key := append(append([]byte{}, utxoPrefix...), txID...)
+	return append(key, out[:]...)
+}
+
+// splitUTXOKey function - the transaction ID and output index in a UTXO key
+func splitUTXOKey(key []byte) ([]byte, int) {
+	key = key[prefixLength:]
+	split := len(key) - 4
+
+	return key[:split], int(binary.BigEndian.Uint32(key[split:]))
+}
+
+// undoEntry struct - a UTXO entry as it was before a block changed it, Value is nil if there was none
+type undoEntry struct {
+	Key   []byte
+	Value []byte
+}
+
 // UTXOSet struct
 type UTXOSet struct {
 	Blockchain *BlockChain
 }
 
-// FindSpendableOutputs function
-func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+// FindSpendableOutputs function - outputs paying the public key or redeem script hash. Coinbase outputs that
+// cannot be spent in the next block are skipped.
+func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int, error) {
 	unspentOuts := make(map[string][]int)
 	accumulated := 0
-	db := u.Blockchain.Database
-
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			v, err := item.ValueCopy(nil)
-			Handle(err)
-			k = bytes.TrimPrefix(k, utxoPrefix)
-			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
-
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
-				}
+	db := u.Blockchain.Store
+
+	bestHeight, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = db.View(func(txn StoreTx) error {
+		return txn.Iterate(utxoPrefix, false, func(k, v []byte) error {
+			if accumulated >= amount {
+				return ErrStopIteration
 			}
-		}
-		return nil
+
+			entry, err := DeserializeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+
+			if entry.Output.IsLockedWithHash(pubKeyHash) && entry.IsMature(bestHeight+1) {
+				txID, out := splitUTXOKey(k)
+				accumulated += entry.Output.Value
+				unspentOuts[hex.EncodeToString(txID)] = append(unspentOuts[hex.EncodeToString(txID)], out)
+			}
+			return nil
+		})
 	})
-	Handle(err)
-	return accumulated, unspentOuts
+
+	return accumulated, unspentOuts, err
 }
 
 // FindUnspentTransactions function
-func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
+func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) ([]TxOutput, error) {
 	var UTXOs []TxOutput
 
-	db := u.Blockchain.Database
+	db := u.Blockchain.Store
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := db.View(func(txn StoreTx) error {
+		return txn.Iterate(utxoPrefix, false, func(_, v []byte) error {
+			entry, err := DeserializeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+
+			if entry.Output.IsLockedWithHash(pubKeyHash) {
+				UTXOs = append(UTXOs, entry.Output)
+			}
+			return nil
+		})
+	})
 
-		it := txn.NewIterator(opts)
-		defer it.Close()
+	return UTXOs, err
+}
 
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			v, err := item.ValueCopy(nil)
-			Handle(err)
-			outs := DeserializeOutputs(v)
+// FindBalance function - the value of the address's outputs that can be spent in the next block, and of its
+// coinbase outputs that are still maturing
+func (u UTXOSet) FindBalance(pubKeyHash []byte) (int, int, error) {
+	spendable, immature := 0, 0
 
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
+	bestHeight, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = u.Blockchain.Store.View(func(txn StoreTx) error {
+		return txn.Iterate(utxoPrefix, false, func(_, v []byte) error {
+			entry, err := DeserializeUTXOEntry(v)
+			if err != nil {
+				return err
 			}
-		}
 
-		return nil
+			if !entry.Output.IsLockedWithHash(pubKeyHash) {
+				return nil
+			}
+			if entry.IsMature(bestHeight + 1) {
+				spendable += entry.Output.Value
+			} else {
+				immature += entry.Output.Value
+			}
+			return nil
+		})
 	})
-	Handle(err)
 
-	return UTXOs
+	return spendable, immature, err
 }
 
-// CountTransactions function
-func (u UTXOSet) CountTransactions() int {
-	db := u.Blockchain.Database
-	counter := 0
-
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+// FindEntry function - the unspent output at the outpoint, nil if it is spent or was never created
+func (u UTXOSet) FindEntry(txID []byte, vout int) (*UTXOEntry, error) {
+	var entry *UTXOEntry
 
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			counter++
+	err := u.Blockchain.Store.View(func(txn StoreTx) error {
+		v, err := txn.Get(utxoKey(txID, vout))
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 
-		return nil
+		found, err := DeserializeUTXOEntry(v)
+		entry = &found
+		return err
 	})
 
-	Handle(err)
-
-	return counter
+	return entry, err
 }
 
-// Reindex function
-func (u UTXOSet) Reindex() {
-	db := u.Blockchain.Database
+// CountTransactions function - the number of transactions with an unspent output
+func (u UTXOSet) CountTransactions() (int, error) {
+	db := u.Blockchain.Store
+	counter := 0
+	var last []byte
+
+	err := db.View(func(txn StoreTx) error {
+		return txn.Iterate(utxoPrefix, false, func(k, _ []byte) error {
+			// a transaction's outputs are next to each other
+			txID, _ := splitUTXOKey(k)
+			if !bytes.Equal(txID, last) {
+				counter++
+				last = txID
+			}
+			return nil
+		})
+	})
 
-	u.DeleteByPrefix(utxoPrefix)
+	return counter, err
+}
 
-	UTXO := u.Blockchain.FindUTXO()
+// Reindex function - rebuilds the UTXO set and its undo records by applying the main chain from genesis. Returns
+// ErrHistoryNotVerified, leaving the set as it is, on a chain started from a UTXO snapshot whose history is missing.
+func (u UTXOSet) Reindex() error {
+	db := u.Blockchain.Store
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				return err
-			}
-			key = append(utxoPrefix, key...)
+	verified, err := u.Blockchain.historyVerified()
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return ErrHistoryNotVerified
+	}
 
-			err = txn.Set(key, outs.Serialize())
-			Handle(err)
+	err = db.Update(func(txn StoreTx) error {
+		if err := txn.Delete(utxoFormatKey); err != nil {
+			return err
 		}
+		return txn.Delete(utxoBestKey)
+	})
+	if err != nil {
+		return err
+	}
 
-		return nil
+	if err := u.DeleteByPrefix(utxoPrefix); err != nil {
+		return err
+	}
+	if err := u.DeleteByPrefix(undoPrefix); err != nil {
+		return err
+	}
+
+	if err := u.replay(0); err != nil {
+		return err
+	}
+
+	return db.Update(func(txn StoreTx) error {
+		return txn.Set(utxoFormatKey, []byte{1})
 	})
-	Handle(err)
-}
-
-// Update function
-func (u *UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Database
-
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					Handle(err)
-					v, err := item.ValueCopy(nil)
-					Handle(err)
-
-					outs := DeserializeOutputs(v)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-
-					if len(updatedOuts.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
-					} else {
-						if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-							log.Panic(err)
-						}
-					}
+}
+
+// Update function - applies a block to the UTXO set on its own, see BlockChain.ConnectBlock
+func (u *UTXOSet) Update(block *Block) error {
+	return u.Blockchain.Store.Update(func(txn StoreTx) error {
+		return applyBlock(txn, block)
+	})
+}
+
+// applyBlock function - spends the block's inputs and adds its outputs. Each entry is saved as it was before
+// the block changed it to the block's undo record, and the UTXO set is marked as up to date with the block.
+func applyBlock(txn StoreTx, block *Block) error {
+	var undo []undoEntry
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, in := range tx.Inputs {
+				key := utxoKey(in.ID, in.Out)
+				v, err := txn.Get(key)
+				if err == ErrKeyNotFound {
+					return fmt.Errorf("%w: output %x:%d", ErrMissingInput, in.ID, in.Out)
+				}
+				if err != nil {
+					return err
+				}
+				undo = append(undo, undoEntry{key, v})
+
+				if err := txn.Delete(key); err != nil {
+					return err
 				}
 			}
-			newOutputs := TxOutputs{}
-			for _, out := range tx.Outputs {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
-			}
+		}
 
-			txID := append(utxoPrefix, tx.ID...)
-			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
+		for outIdx, out := range tx.Outputs {
+			key := utxoKey(tx.ID, outIdx)
+			undo = append(undo, undoEntry{key, nil})
+
+			entry := UTXOEntry{out, block.Height, tx.IsCoinbase()}
+			if err := txn.Set(key, entry.Serialize()); err != nil {
+				return err
 			}
 		}
+	}
 
-		return nil
+	var buff bytes.Buffer
+	if err := gob.NewEncoder(&buff).Encode(undo); err != nil {
+		return err
+	}
+	if err := txn.Set(append(undoPrefix, block.Hash...), buff.Bytes()); err != nil {
+		return err
+	}
+
+	return txn.Set(utxoBestKey, block.Hash)
+}
+
+// Revert function - takes a block back out of the UTXO set, which must be up to date with it
+func (u *UTXOSet) Revert(block *Block) error {
+	return u.Blockchain.Store.Update(func(txn StoreTx) error {
+		return revertBlock(txn, block)
 	})
-	Handle(err)
 }
 
-// DeleteByPrefix function
-func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
-	deleteKeys := func(keysForDelete [][]byte) error {
-		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
-			for _, key := range keysForDelete {
-				if err := txn.Delete(key); err != nil {
-					return err
-				}
-			}
-			return nil
-		}); err != nil {
+// revertBlock function - undoes applyBlock by putting back the entries saved in the block's undo record
+func revertBlock(txn StoreTx, block *Block) error {
+	best, err := txn.Get(utxoBestKey)
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	if !bytes.Equal(best, block.Hash) {
+		return ErrNotUTXOTip
+	}
+
+	data, err := txn.Get(append(undoPrefix, block.Hash...))
+	if err == ErrKeyNotFound {
+		return ErrNoUndoRecord
+	}
+	if err != nil {
+		return err
+	}
+
+	var undo []undoEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&undo); err != nil {
+		return err
+	}
+
+	for i := len(undo) - 1; i >= 0; i-- {
+		entry := undo[i]
+		if entry.Value == nil {
+			err = txn.Delete(entry.Key)
+		} else {
+			err = txn.Set(entry.Key, entry.Value)
+		}
+		if err != nil {
 			return err
 		}
+	}
+
+	if err := txn.Delete(append(undoPrefix, block.Hash...)); err != nil {
+		return err
+	}
+
+	return txn.Set(utxoBestKey, block.PrevHash)
+}
+
+// repair function - brings the UTXO set up to the chain tip when they are out of step, as they can be in a
+// database written by an older version. If the set's best block is on the main chain the blocks after it are
+// replayed, otherwise the set is rebuilt, which a chain started from a UTXO snapshot can only do once the
+// snapshot's history is verified.
+func (u UTXOSet) repair() error {
+	chain := u.Blockchain
+
+	current, err := chain.indexBuilt(utxoFormatKey)
+	if err != nil {
+		return err
+	}
+	if !current {
+		fmt.Println("Rebuilding the UTXO set")
+		return u.Reindex()
+	}
+
+	var best []byte
+	err = chain.Store.View(func(txn StoreTx) error {
+		var err error
+		best, err = txn.Get(utxoBestKey)
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(best, chain.LastHash) {
 		return nil
 	}
 
-	collectSize := 100000 // max delete at once for badger
-	u.Blockchain.Database.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		keysForDelete := make([][]byte, 0, collectSize)
-		keysCollected := 0
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := it.Item().KeyCopy(nil)
-			keysForDelete = append(keysForDelete, key)
-			keysCollected++
-			if keysCollected == collectSize {
-				if err := deleteKeys(keysForDelete); err != nil {
-					log.Panic(err)
-				}
-				keysForDelete = make([][]byte, 0, collectSize)
-				keysCollected = 0
+	if best != nil {
+		header, err := chain.GetHeader(best)
+		if err == nil {
+			onChain, err := chain.GetBlockHash(header.Height)
+			if err == nil && bytes.Equal(onChain, best) {
+				fmt.Printf("Replaying blocks after %d onto the UTXO set\n", header.Height)
+				return u.replay(header.Height + 1)
 			}
 		}
-		if keysCollected > 0 {
-			if err := deleteKeys(keysForDelete); err != nil {
-				log.Panic(err)
-			}
+	}
+
+	fmt.Println("Rebuilding the UTXO set")
+	return u.Reindex()
+}
+
+// replay function - applies the main chain blocks from the height up to the tip
+func (u UTXOSet) replay(from int) error {
+	iter := u.Blockchain.ForwardIterator(from)
+
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
 		}
-		return nil
-	})
+		if block == nil {
+			return nil
+		}
+
+		if err := u.Update(block); err != nil {
+			return err
+		}
+	}
+}
+
+// DeleteByPrefix function
+func (u *UTXOSet) DeleteByPrefix(prefix []byte) error {
+	return deleteByPrefix(u.Blockchain.Store, prefix)
 }
@@ -0,0 +1,49 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRevert(t *testing.T) {
+	chain, w := newTestChain(t)
+	other := newTestWallet(t)
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := genesis.Transactions[0]
+
+	spend := spendTestTx(t, w, coins, 0, testOutput(t, coins.Outputs[0].Value, other))
+	tip := mineTestBlock(t, chain, w, spend)
+
+	UTXOSet := UTXOSet{chain}
+
+	if err := UTXOSet.Revert(&genesis); !errors.Is(err, ErrNotUTXOTip) {
+		t.Fatalf("expected reverting below the tip to give %v, got %v", ErrNotUTXOTip, err)
+	}
+	if err := UTXOSet.Revert(tip); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry, err := UTXOSet.FindEntry(coins.ID, 0); err != nil || entry == nil {
+		t.Fatalf("the spent output is not back: %v", err)
+	}
+	for _, tx := range tip.Transactions {
+		if entry, err := UTXOSet.FindEntry(tx.ID, 0); err != nil || entry != nil {
+			t.Fatalf("output %x of the reverted block is still unspent: %v", tx.ID, err)
+		}
+	}
+
+	// applying the block again writes a new undo record, so it can be reverted again
+	if err := UTXOSet.Update(tip); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := UTXOSet.FindEntry(spend.ID, 0); err != nil || entry == nil {
+		t.Fatalf("the reapplied spend's output is not unspent: %v", err)
+	}
+	if err := UTXOSet.Revert(tip); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,700 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+)
+
+// UTXOSnapshotVersion is the version of the snapshot file format written by WriteUTXOSnapshot
+const UTXOSnapshotVersion = 2
+
+// utxoSnapshotMagic starts every snapshot file. It is followed by the version, the number of main chain headers
+// and each header from genesis up, the snapshot block, then each UTXO entry in key order. Everything after the
+// version is written as chunks, see writeChunk.
+var utxoSnapshotMagic = []byte("FYPUTXOS")
+
+// the state of a chain started from a UTXO snapshot
+var snapshotKey = []byte("utxosnapshot")
+
+var (
+	// ErrBadUTXOSnapshot is returned for a file that is not a UTXO snapshot
+	ErrBadUTXOSnapshot = errors.New("Not a valid UTXO snapshot")
+	// ErrCommitmentMismatch is returned when a UTXO set does not hash to the commitment it should
+	ErrCommitmentMismatch = errors.New("UTXO set does not match its commitment")
+	// ErrSnapshotHistory is returned when the blocks before a snapshot do not lead to it
+	ErrSnapshotHistory = errors.New("Block history does not match the UTXO snapshot")
+	// ErrNoSnapshot is returned for snapshot operations on a chain that was not started from one
+	ErrNoSnapshot = errors.New("Chain was not started from a UTXO snapshot")
+	// ErrHistoryNotVerified is returned for operations that need the blocks before a UTXO snapshot while they
+	// are still missing
+	ErrHistoryNotVerified = errors.New("Blocks before the UTXO snapshot have not been verified yet")
+)
+
+// SnapshotState struct - where a chain started from a UTXO snapshot. Until Verified the blocks before it are
+// missing and the snapshot is trusted on its commitment alone.
+type SnapshotState struct {
+	Height     int
+	BlockHash  []byte
+	Commitment []byte
+	Verified   bool
+	// a bootstrap file to verify the history from, see VerifySnapshotHistory
+	HistoryFile string
+}
+
+// Serialize function
+func (state SnapshotState) Serialize() []byte {
+	var buff bytes.Buffer
+
+	encode := gob.NewEncoder(&buff)
+	Handle(encode.Encode(state))
+
+	return buff.Bytes()
+}
+
+// DeserializeSnapshotState function
+func DeserializeSnapshotState(data []byte) (SnapshotState, error) {
+	var state SnapshotState
+
+	decode := gob.NewDecoder(bytes.NewReader(data))
+	err := decode.Decode(&state)
+
+	return state, err
+}
+
+// encodeUTXO function - the canonical encoding of a UTXO entry the commitment is taken over: the transaction ID
+// and locking script with a 4 byte length in front, the output index, value, height and coinbase flag, all
+// big endian
+func encodeUTXO(txID []byte, vout int, entry UTXOEntry) []byte {
+	buff := new(bytes.Buffer)
+
+	binary.Write(buff, binary.BigEndian, uint32(len(txID))) // writing to a bytes.Buffer never returns an error
+	buff.Write(txID)
+	binary.Write(buff, binary.BigEndian, uint32(vout))
+	binary.Write(buff, binary.BigEndian, int64(entry.Output.Value))
+	binary.Write(buff, binary.BigEndian, uint32(len(entry.Output.Script)))
+	buff.Write(entry.Output.Script)
+	binary.Write(buff, binary.BigEndian, uint64(entry.Height))
+	if entry.Coinbase {
+		buff.WriteByte(1)
+	} else {
+		buff.WriteByte(0)
+	}
+
+	return buff.Bytes()
+}
+
+// decodeUTXO function
+func decodeUTXO(data []byte) ([]byte, int, UTXOEntry, error) {
+	r := bytes.NewReader(data)
+	var entry UTXOEntry
+
+	readBytes := func() ([]byte, error) {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		if int(length) > r.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		b := make([]byte, length)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+
+	txID, err := readBytes()
+	if err != nil {
+		return nil, 0, entry, ErrBadUTXOSnapshot
+	}
+	var vout uint32
+	var value int64
+	if err := binary.Read(r, binary.BigEndian, &vout); err != nil {
+		return nil, 0, entry, ErrBadUTXOSnapshot
+	}
+	if err := binary.Read(r, binary.BigEndian, &value); err != nil {
+		return nil, 0, entry, ErrBadUTXOSnapshot
+	}
+	script, err := readBytes()
+	if err != nil {
+		return nil, 0, entry, ErrBadUTXOSnapshot
+	}
+	var height uint64
+	if err := binary.Read(r, binary.BigEndian, &height); err != nil {
+		return nil, 0, entry, ErrBadUTXOSnapshot
+	}
+	coinbase, err := r.ReadByte()
+	if err != nil || coinbase > 1 || r.Len() != 0 {
+		return nil, 0, entry, ErrBadUTXOSnapshot
+	}
+
+	entry = UTXOEntry{TxOutput{int(value), script}, int(height), coinbase == 1}
+
+	return txID, int(vout), entry, nil
+}
+
+// Commitment function - a hash of the UTXO set and the block it is up to date with. Two sets at the same
+// block have the same commitment only if they hold the same entries.
+func (u UTXOSet) Commitment() ([]byte, error) {
+	var commitment []byte
+
+	err := u.Blockchain.Store.View(func(txn StoreTx) error {
+		best, err := txn.Get(utxoBestKey)
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.New()
+		hash.Write(best)
+
+		err = txn.Iterate(utxoPrefix, false, func(k, v []byte) error {
+			entry, err := DeserializeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+			txID, vout := splitUTXOKey(k)
+			hash.Write(encodeUTXO(txID, vout, entry))
+
+			return nil
+		})
+		commitment = hash.Sum(nil)
+
+		return err
+	})
+
+	return commitment, err
+}
+
+// WriteUTXOSnapshot function - writes the UTXO set at the chain tip to w along with the main chain headers, and
+// returns its commitment
+func (chain *BlockChain) WriteUTXOSnapshot(w io.Writer) ([]byte, error) {
+	var commitment []byte
+
+	err := chain.Store.View(func(txn StoreTx) error {
+		best, err := txn.Get(utxoBestKey)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if !bytes.Equal(best, chain.LastHash) {
+			return ErrNotUTXOTip
+		}
+
+		blockData, err := txn.Get(best)
+		if err != nil {
+			return err
+		}
+		block, err := Deserialize(blockData)
+		if err != nil {
+			return err
+		}
+
+		var head [8]byte
+		binary.BigEndian.PutUint32(head[:4], UTXOSnapshotVersion)
+		binary.BigEndian.PutUint32(head[4:], uint32(block.Height+1))
+		if _, err := w.Write(append(append([]byte{}, utxoSnapshotMagic...), head[:]...)); err != nil {
+			return err
+		}
+
+		for height := 0; height <= block.Height; height++ {
+			hash, err := txn.Get(heightKey(height))
+			if err != nil {
+				return err
+			}
+			header, err := txn.Get(append(headerPrefix, hash...))
+			if err != nil {
+				return err
+			}
+			if err := writeChunk(w, header); err != nil {
+				return err
+			}
+		}
+		if err := writeChunk(w, blockData); err != nil {
+			return err
+		}
+
+		hash := sha256.New()
+		hash.Write(best)
+
+		err = txn.Iterate(utxoPrefix, false, func(k, v []byte) error {
+			entry, err := DeserializeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+			txID, vout := splitUTXOKey(k)
+			record := encodeUTXO(txID, vout, entry)
+			hash.Write(record)
+
+			return writeChunk(w, record)
+		})
+		commitment = hash.Sum(nil)
+
+		return err
+	})
+
+	return commitment, err
+}
+
+// LoadUTXOSnapshot function - creates a chain from a UTXO snapshot whose commitment matches the trusted one.
+// The headers are checked as they would be in a headers first sync, but the blocks before the snapshot are
+// missing until VerifySnapshotHistory has run, so the chain cannot reorganize below the snapshot until then.
+func LoadUTXOSnapshot(r io.Reader, commitment []byte, config Config) (*BlockChain, error) {
+	if storeExists(config) {
+		return nil, ErrChainExists
+	}
+
+	reader := bufio.NewReader(r)
+
+	head := make([]byte, len(utxoSnapshotMagic)+8)
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return nil, ErrBadUTXOSnapshot
+	}
+	if !bytes.Equal(head[:len(utxoSnapshotMagic)], utxoSnapshotMagic) {
+		return nil, ErrBadUTXOSnapshot
+	}
+	head = head[len(utxoSnapshotMagic):]
+	if version := binary.BigEndian.Uint32(head[:4]); version != UTXOSnapshotVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrBadUTXOSnapshot, version)
+	}
+	count := int(binary.BigEndian.Uint32(head[4:]))
+
+	store, err := OpenStore(config)
+	if err != nil {
+		return nil, err
+	}
+	chain := &BlockChain{Store: store}
+
+	fail := func(err error) (*BlockChain, error) {
+		store.Close()
+		removeStore(config)
+		return nil, err
+	}
+
+	tip, err := chain.loadSnapshotHeaders(reader, count)
+	if err != nil {
+		return fail(err)
+	}
+
+	blockData, err := readChunk(reader, MaxBlockSize)
+	if err != nil {
+		return fail(ErrBadUTXOSnapshot)
+	}
+	block, err := Deserialize(blockData)
+	if err != nil {
+		return fail(ErrBadUTXOSnapshot)
+	}
+	if !bytes.Equal(block.Hash, tip.Hash) {
+		return fail(fmt.Errorf("%w: block %x is not the last header", ErrBadUTXOSnapshot, block.Hash))
+	}
+	if err := CheckBlock(block); err != nil {
+		return fail(err)
+	}
+
+	loaded, err := chain.loadSnapshotEntries(reader, block.Hash)
+	if err != nil {
+		return fail(err)
+	}
+	if !bytes.Equal(loaded, commitment) {
+		return fail(fmt.Errorf("%w: got %x", ErrCommitmentMismatch, loaded))
+	}
+
+	err = store.Update(func(txn StoreTx) error {
+		work, err := txn.Get(append(workPrefix, block.Hash...))
+		if err != nil {
+			return err
+		}
+		if err := storeBlock(txn, block, new(big.Int).SetBytes(work)); err != nil {
+			return err
+		}
+		if err := indexTransactions(txn, block); err != nil {
+			return err
+		}
+
+		for _, key := range [][]byte{txIndexKey, heightIndexKey, utxoFormatKey} {
+			if err := txn.Set(key, []byte{1}); err != nil {
+				return err
+			}
+		}
+		state := SnapshotState{block.Height, block.Hash, commitment, false, ""}
+		if err := txn.Set(snapshotKey, state.Serialize()); err != nil {
+			return err
+		}
+		if err := txn.Set(utxoBestKey, block.Hash); err != nil {
+			return err
+		}
+
+		return txn.Set([]byte("lh"), block.Hash)
+	})
+	if err != nil {
+		return fail(err)
+	}
+	chain.LastHash = block.Hash
+
+	return chain, nil
+}
+
+// loadSnapshotHeaders function - stores and indexes by height the snapshot's main chain headers, which must
+// run from genesis with each one on the last. Returns the last header.
+func (chain *BlockChain) loadSnapshotHeaders(r io.Reader, count int) (*BlockHeader, error) {
+	batchSize := MaxHeadersPerMsg
+	var batch []*BlockHeader
+	var last *BlockHeader
+
+	for i := 0; i < count; i++ {
+		data, err := readChunk(r, MaxBlockSize)
+		if err != nil {
+			return nil, ErrBadUTXOSnapshot
+		}
+		header, err := DeserializeHeader(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if last == nil {
+			// AddHeaders needs a chain to build on, so the genesis header is stored on its own
+			if err := chain.loadGenesisHeader(header); err != nil {
+				return nil, err
+			}
+		} else {
+			if !bytes.Equal(header.PrevHash, last.Hash) {
+				return nil, fmt.Errorf("%w: headers do not form a chain", ErrBadUTXOSnapshot)
+			}
+			batch = append(batch, header)
+		}
+		last = header
+
+		if len(batch) == batchSize || i == count-1 {
+			if err := chain.AddHeaders(batch); err != nil {
+				return nil, err
+			}
+			err := chain.Store.Update(func(txn StoreTx) error {
+				for _, header := range batch {
+					if err := txn.Set(heightKey(header.Height), header.Hash); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			batch = nil
+		}
+	}
+
+	if last == nil {
+		return nil, ErrBadUTXOSnapshot
+	}
+
+	return last, nil
+}
+
+// loadGenesisHeader function
+func (chain *BlockChain) loadGenesisHeader(header *BlockHeader) error {
+	if err := CheckHeader(header); err != nil {
+		return err
+	}
+	if len(header.PrevHash) != 0 {
+		return fmt.Errorf("%w: first header is not a genesis block", ErrBadUTXOSnapshot)
+	}
+	if err := chain.checkHeaderContext(header); err != nil {
+		return err
+	}
+
+	err := chain.Store.Update(func(txn StoreTx) error {
+		if err := txn.Set(append(headerPrefix, header.Hash...), header.Serialize()); err != nil {
+			return err
+		}
+		if err := txn.Set(append(workPrefix, header.Hash...), BlockWork(header.Difficulty).Bytes()); err != nil {
+			return err
+		}
+		return txn.Set(heightKey(0), header.Hash)
+	})
+	if err != nil {
+		return err
+	}
+	chain.LastHash = header.Hash
+
+	return nil
+}
+
+// loadSnapshotEntries function - stores the UTXO entries, in batches so no one write gets too big, and
+// returns their commitment
+func (chain *BlockChain) loadSnapshotEntries(r io.Reader, blockHash []byte) ([]byte, error) {
+	batchSize := 10000
+	hash := sha256.New()
+	hash.Write(blockHash)
+
+	for done := false; !done; {
+		var records [][]byte
+		for len(records) < batchSize {
+			record, err := readChunk(r, MaxBlockSize)
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err != nil {
+				return nil, ErrBadUTXOSnapshot
+			}
+			records = append(records, record)
+		}
+
+		err := chain.Store.Update(func(txn StoreTx) error {
+			for _, record := range records {
+				txID, vout, entry, err := decodeUTXO(record)
+				if err != nil {
+					return err
+				}
+				hash.Write(record)
+
+				if err := txn.Set(utxoKey(txID, vout), entry.Serialize()); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return hash.Sum(nil), nil
+}
+
+// SnapshotStatus function - nil if the chain was not started from a UTXO snapshot
+func (chain *BlockChain) SnapshotStatus() (*SnapshotState, error) {
+	var state *SnapshotState
+
+	err := chain.Store.View(func(txn StoreTx) error {
+		data, err := txn.Get(snapshotKey)
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		found, err := DeserializeSnapshotState(data)
+		state = &found
+		return err
+	})
+
+	return state, err
+}
+
+// historyVerified function - false while the chain was started from a UTXO snapshot whose history is missing
+func (chain *BlockChain) historyVerified() (bool, error) {
+	state, err := chain.SnapshotStatus()
+	if err != nil {
+		return false, err
+	}
+
+	return state == nil || state.Verified, nil
+}
+
+// SetSnapshotHistoryFile function - records the bootstrap file a node should verify the snapshot's history from
+func (chain *BlockChain) SetSnapshotHistoryFile(path string) error {
+	state, err := chain.SnapshotStatus()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return ErrNoSnapshot
+	}
+
+	state.HistoryFile = path
+	return chain.Store.Update(func(txn StoreTx) error {
+		return txn.Set(snapshotKey, state.Serialize())
+	})
+}
+
+// VerifySnapshotHistory function - checks the UTXO snapshot the chain was started from against the blocks
+// before it, read from a bootstrap file. The blocks are validated on a chain of their own in the scratch store,
+// which is deleted afterwards, and once the UTXO set they give at the snapshot block matches the commitment
+// they are copied in with their undo records, so the chain is then the same as one synced from genesis.
+// It can run while the chain is in use; lock, if not nil, is held while the chain is written to.
+func (chain *BlockChain) VerifySnapshotHistory(r io.Reader, scratch Config, lock sync.Locker) error {
+	state, err := chain.SnapshotStatus()
+	if err != nil {
+		return err
+	}
+	if state == nil || state.Verified {
+		return nil
+	}
+
+	reader, err := NewBootstrapReader(r)
+	if err != nil {
+		return err
+	}
+
+	genesis, err := reader.Next()
+	if err != nil {
+		return err
+	}
+	if genesis == nil {
+		return ErrBadBootstrap
+	}
+	if err := chain.checkSnapshotHistory(genesis); err != nil {
+		return err
+	}
+	if err := CheckBlock(genesis); err != nil {
+		return err
+	}
+
+	// left over if an earlier check was cut short
+	if err := removeStore(scratch); err != nil {
+		return err
+	}
+	history, err := initBlockChain(genesis, scratch)
+	if err != nil {
+		return err
+	}
+	defer removeStore(scratch)
+	defer history.Close()
+
+	for !bytes.Equal(history.LastHash, state.BlockHash) {
+		block, err := reader.Next()
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			return fmt.Errorf("%w: the file ends before height %d", ErrSnapshotHistory, state.Height)
+		}
+		if err := chain.checkSnapshotHistory(block); err != nil {
+			return err
+		}
+		if _, err := history.importBlock(block); err != nil {
+			return fmt.Errorf("block %d: %w", block.Height, err)
+		}
+	}
+
+	commitment, err := UTXOSet{history}.Commitment()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(commitment, state.Commitment) {
+		return fmt.Errorf("%w: the blocks give %x", ErrCommitmentMismatch, commitment)
+	}
+
+	if lock != nil {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	if err := chain.copyHistory(history, state.Height); err != nil {
+		return err
+	}
+
+	state.Verified = true
+	return chain.Store.Update(func(txn StoreTx) error {
+		return txn.Set(snapshotKey, state.Serialize())
+	})
+}
+
+// checkSnapshotHistory function - the block must be the main chain block at its height, up to the snapshot
+func (chain *BlockChain) checkSnapshotHistory(block *Block) error {
+	hash, err := chain.GetBlockHash(block.Height)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, block.Hash) {
+		return fmt.Errorf("%w: block %x at height %d", ErrSnapshotHistory, block.Hash, block.Height)
+	}
+
+	return nil
+}
+
+// copyHistory function - copies the blocks up to the height, their transaction index entries and undo records
+// from history, one block per write. Their address index entries are added too if the index is kept.
+func (chain *BlockChain) copyHistory(history *BlockChain, height int) error {
+	addrIndexed, err := chain.AddressIndexEnabled()
+	if err != nil {
+		return err
+	}
+
+	for h := 0; h <= height; h++ {
+		block, err := history.GetBlockByHeight(h)
+		if err != nil {
+			return err
+		}
+
+		var undo []byte
+		err = history.Store.View(func(txn StoreTx) error {
+			var err error
+			undo, err = txn.Get(append(undoPrefix, block.Hash...))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		err = chain.Store.Update(func(txn StoreTx) error {
+			if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+				return err
+			}
+			if err := indexTransactions(txn, &block); err != nil {
+				return err
+			}
+			if addrIndexed {
+				if err := indexAddresses(txn, &block); err != nil {
+					return err
+				}
+			}
+			return txn.Set(append(undoPrefix, block.Hash...), undo)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FindInputTransaction function - the transaction an input spends from. Transactions from before a UTXO
+// snapshot are missing until its history is verified, so for those only the outputs still in the UTXO set are
+// filled in, which is all that is needed to check a spend. On any other chain a missing transaction is
+// ErrTxNotFound.
+func (chain *BlockChain) FindInputTransaction(ID []byte) (Transaction, error) {
+	tx, err := chain.FindTransaction(ID)
+	if err != ErrTxNotFound {
+		return tx, err
+	}
+
+	verified, err := chain.historyVerified()
+	if err != nil {
+		return Transaction{}, err
+	}
+	if verified {
+		return Transaction{}, ErrTxNotFound
+	}
+
+	tx = Transaction{ID: ID}
+	err = chain.Store.View(func(txn StoreTx) error {
+		return txn.Iterate(append(append([]byte{}, utxoPrefix...), ID...), false, func(k, v []byte) error {
+			entry, err := DeserializeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+			_, vout := splitUTXOKey(k)
+
+			for len(tx.Outputs) <= vout {
+				tx.Outputs = append(tx.Outputs, TxOutput{})
+			}
+			tx.Outputs[vout] = entry.Output
+			return nil
+		})
+	})
+	if err != nil {
+		return Transaction{}, err
+	}
+	if len(tx.Outputs) == 0 {
+		return Transaction{}, ErrTxNotFound
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,119 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestUTXOSnapshot(t *testing.T) {
+	chain, blocks := testChainBlocks(t)
+	payment := blocks[2].Transactions[1]
+	pubKeyHash := payment.Outputs[0].AddressHash()
+
+	var snapshot bytes.Buffer
+	commitment, err := chain.WriteUTXOSnapshot(&snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := UTXOSet{chain}.Commitment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(commitment, expected) {
+		t.Fatal("the snapshot commitment is not the UTXO set's")
+	}
+
+	wrong := append([]byte{}, commitment...)
+	wrong[0] ^= 1
+	if _, err := LoadUTXOSnapshot(bytes.NewReader(snapshot.Bytes()), wrong, Config{Backend: MemoryBackend}); !errors.Is(err, ErrCommitmentMismatch) {
+		t.Fatalf("expected %v, got %v", ErrCommitmentMismatch, err)
+	}
+
+	loaded, err := LoadUTXOSnapshot(bytes.NewReader(snapshot.Bytes()), commitment, Config{Backend: MemoryBackend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+
+	if !bytes.Equal(loaded.LastHash, chain.LastHash) {
+		t.Fatal("the loaded chain has a different tip")
+	}
+	state, err := loaded.SnapshotStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state == nil || state.Verified || state.Height != len(blocks)-1 {
+		t.Fatalf("expected an unverified snapshot at height %d, got %+v", len(blocks)-1, state)
+	}
+	if loadedCommitment, err := (UTXOSet{loaded}).Commitment(); err != nil || !bytes.Equal(loadedCommitment, commitment) {
+		t.Fatalf("the loaded UTXO set does not match the commitment: %v", err)
+	}
+
+	// the blocks before the snapshot are missing, so nothing may be rebuilt from them
+	if err := (UTXOSet{loaded}).Reindex(); !errors.Is(err, ErrHistoryNotVerified) {
+		t.Fatalf("expected %v, got %v", ErrHistoryNotVerified, err)
+	}
+	if err := loaded.ReindexAddresses(); !errors.Is(err, ErrHistoryNotVerified) {
+		t.Fatalf("expected %v, got %v", ErrHistoryNotVerified, err)
+	}
+	if _, err := loaded.ForwardIterator(0).Next(); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("expected a missing block error, got %v", err)
+	}
+
+	// only the unspent outputs of transactions from before the snapshot can be looked up
+	input, err := loaded.FindInputTransaction(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(input.Outputs) != 1 || input.Outputs[0].Value != payment.Outputs[0].Value {
+		t.Fatal("the input transaction's outputs are not filled in from the UTXO set")
+	}
+
+	// a node keeping the address index has the history indexed as it is copied in
+	err = loaded.Store.Update(func(txn StoreTx) error {
+		return txn.Set(addrIndexKey, []byte{1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := testChainBlocks(t)
+	var otherHistory bytes.Buffer
+	if _, err := other.ExportChain(&otherHistory); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.VerifySnapshotHistory(&otherHistory, Config{Backend: MemoryBackend}, nil); !errors.Is(err, ErrSnapshotHistory) {
+		t.Fatalf("expected another chain's history to give %v, got %v", ErrSnapshotHistory, err)
+	}
+
+	var history bytes.Buffer
+	if _, err := chain.ExportChain(&history); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.VerifySnapshotHistory(&history, Config{Backend: MemoryBackend}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if state, err = loaded.SnapshotStatus(); err != nil || !state.Verified {
+		t.Fatalf("expected the snapshot to be verified: %v", err)
+	}
+	if _, err := loaded.FindTransaction(payment.ID); err != nil {
+		t.Fatalf("the history's transactions are not indexed: %v", err)
+	}
+	addrHistory, err := loaded.GetAddressHistory(pubKeyHash, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the payment, and the coinbase of the last block
+	if len(addrHistory) != 2 || !bytes.Equal(addrHistory[1].TxID, payment.ID) {
+		t.Fatalf("the history's transactions are not in the address index, got %d", len(addrHistory))
+	}
+
+	if err := (UTXOSet{loaded}).Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	if reindexed, err := (UTXOSet{loaded}).Commitment(); err != nil || !bytes.Equal(reindexed, commitment) {
+		t.Fatalf("rebuilding the UTXO set from the verified history changed it: %v", err)
+	}
+}
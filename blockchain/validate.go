@@ -0,0 +1,288 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// MaxBlockSize is the largest serialized block we accept, in bytes
+	MaxBlockSize = 1 << 20
+	// MaxFutureBlockTime is how many seconds ahead of our clock a block timestamp may be
+	MaxFutureBlockTime = 2 * 60 * 60
+	// medianTimeBlocks is the number of blocks used for the median time past
+	medianTimeBlocks = 11
+)
+
+// Consensus rule violations, wrapped in a RuleError by ValidateBlock
+var (
+	ErrBlockTooBig        = errors.New("Block is too big")
+	ErrBadProofOfWork     = errors.New("Block hash does not meet its target")
+	ErrBadBlockHash       = errors.New("Block hash does not match its header")
+	ErrBadMerkleRoot      = errors.New("Block transactions do not match its merkle root")
+	ErrTimeTooNew         = errors.New("Block timestamp is too far in the future")
+	ErrTimeTooOld         = errors.New("Block timestamp is before the median time past")
+	ErrNoTransactions     = errors.New("Block has no transactions")
+	ErrFirstTxNotCoinbase = errors.New("First transaction in block is not a coinbase")
+	ErrMultipleCoinbases  = errors.New("Block has more than one coinbase")
+	ErrBadTxID            = errors.New("Transaction ID does not match its contents")
+	ErrDuplicateTx        = errors.New("Block contains a duplicate transaction")
+	ErrBadTxOutput        = errors.New("Transaction output value is negative or more than MaxMoney")
+	ErrValueOutOfRange    = errors.New("Values add up to more than MaxMoney")
+	ErrBadHeight          = errors.New("Block height is not one more than its parent")
+	ErrMissingInput       = errors.New("Transaction input is missing or already spent")
+	ErrDoubleSpend        = errors.New("Output is spent twice in the same block")
+	ErrBadSignature       = errors.New("Transaction signature is invalid")
+	ErrSpendTooHigh       = errors.New("Transaction outputs exceed its inputs")
+	ErrBadCoinbaseValue   = errors.New("Coinbase pays more than the subsidy plus fees")
+	ErrImmatureSpend      = errors.New("Transaction spends a coinbase output that has not matured")
+	ErrLockTime           = errors.New("Transaction lock time has not passed")
+	ErrSequenceLock       = errors.New("Transaction input relative lock time has not passed")
+)
+
+// ErrOrphanBlock is returned when a block's parent is unknown. It is not a rule violation.
+var ErrOrphanBlock = errors.New("Block parent is unknown")
+
+// RuleError struct - a block broke a consensus rule. Peers that send these can be penalized.
+type RuleError struct {
+	Err         error
+	Description string
+}
+
+func (e RuleError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Err, e.Description)
+}
+
+// Unwrap function
+func (e RuleError) Unwrap() error {
+	return e.Err
+}
+
+// ruleError function
+func ruleError(err error, format string, args ...interface{}) error {
+	return RuleError{err, fmt.Sprintf(format, args...)}
+}
+
+// ValidateBlock function - runs every check that can be made against the current chain.
+// Transaction checks need the UTXO set at the block's parent, so they only run here when the block
+// extends the tip; blocks on side branches have them run as the branch is connected.
+func (chain *BlockChain) ValidateBlock(block *Block) error {
+	if err := CheckBlock(block); err != nil {
+		return err
+	}
+
+	if err := chain.checkBlockContext(block); err != nil {
+		return err
+	}
+
+	if bytes.Equal(block.PrevHash, chain.LastHash) {
+		return chain.checkBlockTransactions(block)
+	}
+
+	return nil
+}
+
+// CheckBlock function - checks that need nothing but the block itself
+func CheckBlock(block *Block) error {
+	if size := len(block.Serialize()); size > MaxBlockSize {
+		return ruleError(ErrBlockTooBig, "%d bytes", size)
+	}
+
+	if err := CheckHeader(block.Header()); err != nil {
+		return err
+	}
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return ruleError(ErrBadMerkleRoot, "block %x", block.Hash)
+	}
+
+	if len(block.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, "block %x", block.Hash)
+	}
+	if !block.Transactions[0].IsCoinbase() {
+		return ruleError(ErrFirstTxNotCoinbase, "block %x", block.Hash)
+	}
+
+	seen := make(map[string]bool)
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return ruleError(ErrMultipleCoinbases, "transaction %d", i)
+		}
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return ruleError(ErrBadTxID, "transaction %x", tx.ID)
+		}
+
+		txID := hex.EncodeToString(tx.ID)
+		if seen[txID] {
+			return ruleError(ErrDuplicateTx, "transaction %s", txID)
+		}
+		seen[txID] = true
+
+		if _, err := tx.OutputValue(); err != nil {
+			return ruleError(err, "transaction %s", txID)
+		}
+	}
+
+	return nil
+}
+
+// checkBlockContext function - checks the block against its parent
+func (chain *BlockChain) checkBlockContext(block *Block) error {
+	if len(block.PrevHash) == 0 {
+		if block.Height != 0 {
+			return ruleError(ErrBadHeight, "genesis block at height %d", block.Height)
+		}
+		return nil
+	}
+
+	parent, err := chain.GetBlock(block.PrevHash)
+	if err != nil {
+		return ErrOrphanBlock
+	}
+
+	if block.Height != parent.Height+1 {
+		return ruleError(ErrBadHeight, "height %d on parent at height %d", block.Height, parent.Height)
+	}
+
+	if err := chain.CheckDifficulty(block); err != nil {
+		if err == ErrBadDifficulty {
+			return ruleError(err, "difficulty %d", block.Difficulty)
+		}
+		return err
+	}
+
+	medianTime, err := chain.MedianTimePast(parent.Header())
+	if err != nil {
+		return err
+	}
+	if block.Timestamp < medianTime {
+		return ruleError(ErrTimeTooOld, "timestamp %d, median time past %d", block.Timestamp, medianTime)
+	}
+
+	return nil
+}
+
+// checkBlockTransactions function - checks every input against the UTXO set, which must be at the block's parent
+func (chain *BlockChain) checkBlockTransactions(block *Block) error {
+	UTXOSet := UTXOSet{chain}
+	blockTXs := make(map[string]*Transaction)
+	spent := make(map[string]bool)
+	fees := 0
+
+	var medianTime int64
+	if len(block.PrevHash) != 0 {
+		parent, err := chain.GetHeader(block.PrevHash)
+		if err != nil {
+			return err
+		}
+		if medianTime, err = chain.MedianTimePast(parent); err != nil {
+			return err
+		}
+	}
+
+	for _, tx := range block.Transactions[1:] {
+		prevTXs := make(map[string]Transaction)
+		var coinHeights []int
+
+		for _, in := range tx.Inputs {
+			outpoint := fmt.Sprintf("%x:%d", in.ID, in.Out)
+			if spent[outpoint] {
+				return ruleError(ErrDoubleSpend, "output %s", outpoint)
+			}
+			spent[outpoint] = true
+
+			inTxID := hex.EncodeToString(in.ID)
+			prevTX, inBlock := blockTXs[inTxID]
+			if !inBlock {
+				found, err := chain.FindInputTransaction(in.ID)
+				if err != nil {
+					return ruleError(ErrMissingInput, "output %s", outpoint)
+				}
+				prevTX = &found
+			}
+
+			if in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+				return ruleError(ErrMissingInput, "output %s", outpoint)
+			}
+			if inBlock {
+				if prevTX.IsCoinbase() && CoinbaseMaturity > 0 {
+					return ruleError(ErrImmatureSpend, "output %s", outpoint)
+				}
+				coinHeights = append(coinHeights, block.Height)
+			} else {
+				entry, err := UTXOSet.FindEntry(in.ID, in.Out)
+				if err != nil {
+					return err
+				}
+				if entry == nil {
+					return ruleError(ErrMissingInput, "output %s", outpoint)
+				}
+				if !entry.IsMature(block.Height) {
+					return ruleError(ErrImmatureSpend, "output %s", outpoint)
+				}
+				coinHeights = append(coinHeights, entry.Height)
+			}
+
+			prevTXs[inTxID] = *prevTX
+		}
+
+		if err := chain.CheckLockTime(tx, coinHeights, block.Height, medianTime); err != nil {
+			return err
+		}
+		if err := tx.Verify(prevTXs); err != nil {
+			return ruleError(ErrBadSignature, "transaction %x: %s", tx.ID, err)
+		}
+
+		fee, err := tx.Fee(prevTXs)
+		if err != nil {
+			return ruleError(err, "transaction %x", tx.ID)
+		}
+		if fee < 0 {
+			return ruleError(ErrSpendTooHigh, "transaction %x", tx.ID)
+		}
+		var ok bool
+		if fees, ok = addMoney(fees, fee); !ok {
+			return ruleError(ErrValueOutOfRange, "fees in block %x", block.Hash)
+		}
+
+		blockTXs[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	allowed, ok := addMoney(fees, Subsidy(block.Height))
+	if !ok {
+		return ruleError(ErrValueOutOfRange, "subsidy and fees in block %x", block.Hash)
+	}
+	coinbaseValue, err := block.Transactions[0].OutputValue()
+	if err != nil {
+		return ruleError(err, "coinbase %x", block.Transactions[0].ID)
+	}
+	if coinbaseValue > allowed {
+		return ruleError(ErrBadCoinbaseValue, "pays %d, allowed %d", coinbaseValue, allowed)
+	}
+
+	return nil
+}
+
+// MedianTimePast function - the median timestamp of the block and the ten blocks before it
+func (chain *BlockChain) MedianTimePast(header *BlockHeader) (int64, error) {
+	var timestamps []int64
+
+	for len(timestamps) < medianTimeBlocks {
+		timestamps = append(timestamps, header.Timestamp)
+
+		if len(header.PrevHash) == 0 {
+			break
+		}
+		parent, err := chain.GetHeader(header.PrevHash)
+		if err != nil {
+			return 0, err
+		}
+		header = parent
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2], nil
+}
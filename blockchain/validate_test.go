@@ -0,0 +1,276 @@
+package blockchain
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+func TestCheckBlock(t *testing.T) {
+	w := newTestWallet(t)
+
+	// block function - a block of the transactions mined at the lowest difficulty, on a made up parent
+	block := func(txs ...*Transaction) *Block {
+		return CreateBlock(txs, make([]byte, hashLength), 1, MinDifficulty)
+	}
+	// coinbase function - told apart by data
+	coinbase := func(t *testing.T, data string) *Transaction {
+		tx, err := CoinbaseTx(string(w.Address()), data, 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	tests := []struct {
+		name  string
+		block func(t *testing.T) *Block
+		err   error
+	}{
+		{
+			name: "valid",
+			block: func(t *testing.T) *Block {
+				return block(coinbase(t, ""))
+			},
+		},
+		{
+			name: "no transactions",
+			block: func(t *testing.T) *Block {
+				return block()
+			},
+			err: ErrNoTransactions,
+		},
+		{
+			name: "first transaction is not a coinbase",
+			block: func(t *testing.T) *Block {
+				cbTx := coinbase(t, "")
+				tx := &Transaction{Inputs: []TxInput{{ID: cbTx.ID, Out: 0, Sequence: SequenceFinal}}, Outputs: cbTx.Outputs}
+				tx.ID = tx.Hash()
+				return block(tx, cbTx)
+			},
+			err: ErrFirstTxNotCoinbase,
+		},
+		{
+			name: "two coinbases",
+			block: func(t *testing.T) *Block {
+				return block(coinbase(t, "first"), coinbase(t, "second"))
+			},
+			err: ErrMultipleCoinbases,
+		},
+		{
+			name: "transaction in twice",
+			block: func(t *testing.T) *Block {
+				cbTx := coinbase(t, "")
+				tx := &Transaction{Inputs: []TxInput{{ID: cbTx.ID, Out: 0, Sequence: SequenceFinal}}, Outputs: cbTx.Outputs}
+				tx.ID = tx.Hash()
+				return block(cbTx, tx, tx)
+			},
+			err: ErrDuplicateTx,
+		},
+		{
+			name: "transaction ID does not match",
+			block: func(t *testing.T) *Block {
+				cbTx := coinbase(t, "")
+				cbTx.ID = make([]byte, hashLength)
+				return block(cbTx)
+			},
+			err: ErrBadTxID,
+		},
+		{
+			name: "transactions changed after mining",
+			block: func(t *testing.T) *Block {
+				b := block(coinbase(t, ""))
+				b.Transactions[0] = coinbase(t, "changed")
+				return b
+			},
+			err: ErrBadMerkleRoot,
+		},
+		{
+			name: "header changed after mining",
+			block: func(t *testing.T) *Block {
+				b := block(coinbase(t, ""))
+				b.Timestamp++
+				return b
+			},
+			err: ErrBadBlockHash,
+		},
+		{
+			name: "hash does not meet the target",
+			block: func(t *testing.T) *Block {
+				b := block(coinbase(t, ""))
+				for NewProof(b.Header()).Validate() {
+					b.Nonce++
+					b.Hash = b.ComputeHash()
+				}
+				return b
+			},
+			err: ErrBadProofOfWork,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckBlock(test.block(t))
+			if test.err == nil && err != nil {
+				t.Fatalf("expected the block to be valid, got %v", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// forgeTestTx function - a transaction spending output 0 of prev, paid to owner, with owner's key but a
+// signature from forger
+func forgeTestTx(t *testing.T, owner, forger *wallet.Wallet, prev *Transaction, outputs ...TxOutput) *Transaction {
+	t.Helper()
+
+	tx := &Transaction{
+		Inputs:  []TxInput{{ID: prev.ID, Out: 0, Sequence: SequenceFinal}},
+		Outputs: outputs,
+	}
+	signature, err := signHash(forger.PrivateKey, tx.SigHash(0, prev.Outputs[0].Script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Inputs[0].Script = P2PKHUnlockingScript(signature, owner.PublicKey)
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+func TestCheckBlockTransactions(t *testing.T) {
+	chain, w := newTestChain(t)
+	other := newTestWallet(t)
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := genesis.Transactions[0]
+	value := coins.Outputs[0].Value
+
+	// coinbase function - the coinbase of the next block, collecting fees
+	coinbase := func(t *testing.T, fees int) *Transaction {
+		tx, err := CoinbaseTx(string(w.Address()), "", 1, fees)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	tests := []struct {
+		name string
+		txs  func(t *testing.T) []*Transaction
+		err  error
+	}{
+		{
+			name: "spend",
+			txs: func(t *testing.T) []*Transaction {
+				return []*Transaction{coinbase(t, 0), spendTestTx(t, w, coins, 0, testOutput(t, value, other))}
+			},
+		},
+		{
+			name: "spend with a fee",
+			txs: func(t *testing.T) []*Transaction {
+				return []*Transaction{coinbase(t, 1), spendTestTx(t, w, coins, 0, testOutput(t, value-1, other))}
+			},
+		},
+		{
+			name: "spend of an output created in the block",
+			txs: func(t *testing.T) []*Transaction {
+				first := spendTestTx(t, w, coins, 0, testOutput(t, value, other))
+				second := spendTestTx(t, other, first, 0, testOutput(t, value, w))
+				return []*Transaction{coinbase(t, 0), first, second}
+			},
+		},
+		{
+			name: "outputs pay more than the inputs",
+			txs: func(t *testing.T) []*Transaction {
+				return []*Transaction{coinbase(t, 0), spendTestTx(t, w, coins, 0, testOutput(t, value+1, other))}
+			},
+			err: ErrSpendTooHigh,
+		},
+		{
+			name: "negative output",
+			txs: func(t *testing.T) []*Transaction {
+				return []*Transaction{coinbase(t, 0), spendTestTx(t, w, coins, 0,
+					testOutput(t, value, other), TxOutput{Value: -value, Script: P2PKHScript(make([]byte, 20))})}
+			},
+			err: ErrBadTxOutput,
+		},
+		{
+			name: "outputs overflow",
+			txs: func(t *testing.T) []*Transaction {
+				out := TxOutput{Value: math.MaxInt64, Script: P2PKHScript(make([]byte, 20))}
+				return []*Transaction{coinbase(t, 0), spendTestTx(t, w, coins, 0, out, out)}
+			},
+			err: ErrBadTxOutput,
+		},
+		{
+			name: "outputs over MaxMoney",
+			txs: func(t *testing.T) []*Transaction {
+				out := TxOutput{Value: MaxMoney, Script: P2PKHScript(make([]byte, 20))}
+				return []*Transaction{coinbase(t, 0), spendTestTx(t, w, coins, 0, out, out)}
+			},
+			err: ErrBadTxOutput,
+		},
+		{
+			name: "signed by another key",
+			txs: func(t *testing.T) []*Transaction {
+				return []*Transaction{coinbase(t, 0), forgeTestTx(t, w, other, coins, testOutput(t, value, other))}
+			},
+			err: ErrBadSignature,
+		},
+		{
+			name: "output spent twice",
+			txs: func(t *testing.T) []*Transaction {
+				return []*Transaction{coinbase(t, 0),
+					spendTestTx(t, w, coins, 0, testOutput(t, value, other)),
+					spendTestTx(t, w, coins, 0, testOutput(t, value, w))}
+			},
+			err: ErrDoubleSpend,
+		},
+		{
+			name: "output that does not exist",
+			txs: func(t *testing.T) []*Transaction {
+				tx := &Transaction{
+					Inputs:  []TxInput{{ID: coins.ID, Out: 1, Sequence: SequenceFinal}},
+					Outputs: []TxOutput{testOutput(t, value, other)},
+				}
+				tx.ID = tx.Hash()
+				return []*Transaction{coinbase(t, 0), tx}
+			},
+			err: ErrMissingInput,
+		},
+		{
+			name: "coinbase pays more than subsidy and fees",
+			txs: func(t *testing.T) []*Transaction {
+				return []*Transaction{coinbase(t, 2), spendTestTx(t, w, coins, 0, testOutput(t, value-1, other))}
+			},
+			err: ErrBadCoinbaseValue,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block := &Block{BlockHeader{PrevHash: chain.LastHash, Height: 1}, test.txs(t)}
+
+			err := chain.checkBlockTransactions(block)
+			if test.err == nil && err != nil {
+				t.Fatalf("expected the block to be valid, got %v", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+
+			var ruleErr RuleError
+			if err != nil && !errors.As(err, &ruleErr) {
+				t.Fatalf("expected a RuleError, got %T", err)
+			}
+		})
+	}
+}
@@ -1,81 +1,281 @@
 package theBlockchain
 
 import (
+	"bufio"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"os"
 
 	// "runtime/debug"
 	"strconv"
+	"strings"
 
 	"github.com/jlynch25/golang-blockchain/blockchain"
+	"github.com/jlynch25/golang-blockchain/mempool"
 	network "github.com/jlynch25/golang-blockchain/noise_network"
 	"github.com/jlynch25/golang-blockchain/wallet"
 )
 
+var errNoPeers = errors.New("No peers to send the transaction to, start the node first")
+
 // FIXME
 func StartNode(nodeID, minerAddress, basePath string) (output string) { // TODO - allow for bootstrap Addresses as extra params (no flag) or with a flagh but allow for multiple addresses
 
-	defer func() {
-		if err := recover(); err != nil {
-			log.Println(err)
-			output = fmt.Sprintf("%v", err)
-		}
-	}()
-
 	fmt.Printf("Starting Node %s\n", nodeID)
 
 	if len(minerAddress) > 0 {
-		if wallet.ValidateAddress(minerAddress) {
-			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
-		} else {
-			log.Panic("Wrong miner address!")
+		if err := wallet.ValidateAddress(minerAddress); err != nil {
+			return "Wrong miner address!"
 		}
+		fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
 	}
 	// network.StartServer(nodeID, minerAddress)
 
 	host, err := network.ExternalIP()
-	network.HandleError(err)
+	if err != nil {
+		return err.Error()
+	}
 	address := ""
 	port, err := strconv.Atoi(nodeID)
-	network.HandleError(err)
+	if err != nil {
+		return err.Error()
+	}
 	bootstrapAddresses := []string{}
 	// FIXME - temp server node .. always connected .. needed for other to join the network. (bootstrap)
 	if nodeID != "4000" {
 		bootstrapAddresses = []string{"[2a02:8084:a5bf:f680:1cfd:d24c:82aa:834]:2000"} //[]string{"[2a02:8084:a5bf:f680:1cfd:d24c:82aa:834]:4000"}
 	}
-	network.StartServer(host, uint16(port), address, minerAddress, basePath, bootstrapAddresses)
+	if err := network.StartServer(host, uint16(port), address, minerAddress, basePath, bootstrapAddresses); err != nil {
+		return err.Error()
+	}
 
 	return "Success!"
 }
 
-func ReindexUTXO(nodeID, basePath string) (output string) {
+func StartLightNode(nodeID, basePath string) (output string) {
 
-	defer func() {
-		if err := recover(); err != nil {
-			log.Println(err)
-			output = fmt.Sprintf("%v", err)
-		}
-	}()
+	fmt.Printf("Starting light Node %s\n", nodeID)
+
+	wallets, err := wallet.CreateWallets(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+
+	host, err := network.ExternalIP()
+	if err != nil {
+		return err.Error()
+	}
+	address := ""
+	port, err := strconv.Atoi(nodeID)
+	if err != nil {
+		return err.Error()
+	}
+	bootstrapAddresses := []string{}
+	// FIXME - temp server node .. always connected .. needed for other to join the network. (bootstrap)
+	if nodeID != "4000" {
+		bootstrapAddresses = []string{"[2a02:8084:a5bf:f680:1cfd:d24c:82aa:834]:2000"}
+	}
+	if err := network.StartLightServer(host, uint16(port), address, basePath, wallets.GetAllAddresses(), bootstrapAddresses); err != nil {
+		return err.Error()
+	}
+
+	return "Success!"
+}
+
+func ReindexUTXO(nodeID, basePath string) (output string) {
 
-	chain := blockchain.ContinueBlockChain(nodeID, basePath)
-	defer chain.Database.Close()
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
+	if err := UTXOSet.Reindex(); err != nil {
+		return err.Error()
+	}
 
-	count := UTXOSet.CountTransactions()
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		return err.Error()
+	}
 	return ("Done! There are " + strconv.Itoa(count) + " transactions in the UTXO set.")
 }
 
-func ListAddresses(nodeID, basePath string) (output string) {
+func ReindexTransactions(nodeID, basePath string) (output string) {
+
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+	if err := chain.ReindexTransactions(); err != nil {
+		return err.Error()
+	}
+
+	count, err := chain.CountIndexedTransactions()
+	if err != nil {
+		return err.Error()
+	}
+	return ("Done! There are " + strconv.Itoa(count) + " transactions in the transaction index.")
+}
+
+func EnableAddressIndex(nodeID, basePath string) (output string) {
+
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+	if err := chain.ReindexAddresses(); err != nil {
+		return err.Error()
+	}
+
+	return ("Done! The address index is built and will be kept up to date.")
+}
+
+func DisableAddressIndex(nodeID, basePath string) (output string) {
+
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+	if err := chain.DropAddressIndex(); err != nil {
+		return err.Error()
+	}
+
+	return ("Done! The address index is deleted.")
+}
+
+func ExportChain(nodeID, basePath, outFile string) (output string) {
+
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+
+	file, err := os.Create(outFile)
+	if err != nil {
+		return err.Error()
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	count, err := chain.ExportChain(w)
+	if err != nil {
+		return err.Error()
+	}
+	if err := w.Flush(); err != nil {
+		return err.Error()
+	}
+
+	return ("Done! Exported " + strconv.Itoa(count) + " blocks to " + outFile)
+}
+
+func ImportChain(inFile, nodeID, basePath string) (output string) {
+
+	file, err := os.Open(inFile)
+	if err != nil {
+		return err.Error()
+	}
+	defer file.Close()
+
+	chain, count, err := blockchain.ImportChain(file, blockchain.DefaultConfig(nodeID, basePath))
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+
+	return ("Done! Imported " + strconv.Itoa(count) + " blocks from " + inFile)
+}
+
+func ExportUTXOSnapshot(nodeID, basePath, outFile string) (output string) {
+
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+
+	file, err := os.Create(outFile)
+	if err != nil {
+		return err.Error()
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	commitment, err := chain.WriteUTXOSnapshot(w)
+	if err != nil {
+		return err.Error()
+	}
+	if err := w.Flush(); err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("Done! The UTXO snapshot commitment is %x", commitment)
+}
+
+func LoadUTXOSnapshot(inFile, commitment, historyFile, nodeID, basePath string) (output string) {
+
+	trusted, err := hex.DecodeString(commitment)
+	if err != nil {
+		return err.Error()
+	}
+	file, err := os.Open(inFile)
+	if err != nil {
+		return err.Error()
+	}
+	defer file.Close()
 
-	defer func() {
-		if err := recover(); err != nil {
-			log.Println(err)
-			output = fmt.Sprintf("%v", err)
+	chain, err := blockchain.LoadUTXOSnapshot(file, trusted, blockchain.DefaultConfig(nodeID, basePath))
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+
+	// the node verifies the history in the background once it starts
+	if historyFile != "" {
+		if err := chain.SetSnapshotHistoryFile(historyFile); err != nil {
+			return err.Error()
 		}
-	}()
+	}
 
-	wallets, _ := wallet.CreateWallets(nodeID, basePath)
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return err.Error()
+	}
+	return ("Done! The chain starts from the UTXO snapshot at height " + strconv.Itoa(bestHeight))
+}
+
+func VerifySnapshotHistory(historyFile, nodeID, basePath string) (output string) {
+
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+
+	file, err := os.Open(historyFile)
+	if err != nil {
+		return err.Error()
+	}
+	defer file.Close()
+
+	if err := chain.VerifySnapshotHistory(file, blockchain.HistoryConfig(nodeID, basePath), nil); err != nil {
+		return err.Error()
+	}
+
+	return ("Done! The history before the UTXO snapshot is verified.")
+}
+
+func ListAddresses(nodeID, basePath string) (output string) {
+
+	wallets, err := wallet.CreateWallets(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
 	addresses := wallets.GetAllAddresses()
 
 	result := " "
@@ -89,49 +289,50 @@ func ListAddresses(nodeID, basePath string) (output string) {
 
 func CreateWallet(nodeID, basePath string) (output string) {
 
-	defer func() {
-		if err := recover(); err != nil {
-			log.Println(err)
-			output = fmt.Sprintf("%v", err)
-		}
-	}()
-
-	wallets, _ := wallet.CreateWallets(nodeID, basePath)
-	address := wallets.AddWallet()
-	wallets.SaveFile(nodeID, basePath)
+	// the first wallet creates the file
+	wallets, err := wallet.CreateWallets(nodeID, basePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err.Error()
+	}
+	address, err := wallets.AddWallet()
+	if err != nil {
+		return err.Error()
+	}
+	if err := wallets.SaveFile(nodeID, basePath); err != nil {
+		return err.Error()
+	}
 
 	return address
 }
 
-func PrintChain(nodeID, basePath string) (output string) {
+func PrintChain(nodeID, basePath string, from, to int) (output string) {
 
-	defer func() {
-		if err := recover(); err != nil {
-			log.Println(err)
-			output = fmt.Sprintf("%v", err)
-		}
-	}()
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
 
-	chain := blockchain.ContinueBlockChain(nodeID, basePath)
-	defer chain.Database.Close()
-	iter := chain.Iterator()
+	// a negative end runs to the tip
+	if to < 0 {
+		if to, err = chain.GetBestHeight(); err != nil {
+			return err.Error()
+		}
+	}
+	blocks, err := chain.GetBlockRange(from, to)
+	if err != nil {
+		return err.Error()
+	}
 
 	result := ""
 
-	for {
-		block := iter.Next()
-
-		result += ("Hash: " + string(block.Hash) + "\n")
-		result += ("Prev. hash: " + string(block.PrevHash) + "\n")
-		pow := blockchain.NewProof(block)
+	for _, block := range blocks {
+		result += (block.BlockHeader.String() + "\n")
+		pow := blockchain.NewProof(&block.BlockHeader)
 		result += ("PoW: " + strconv.FormatBool(pow.Validate()) + "\n")
 		for _, tx := range block.Transactions {
 			result += (tx.String() + "\n")
 		}
-
-		if len(block.PrevHash) == 0 {
-			break
-		}
 	}
 
 	return result
@@ -139,46 +340,104 @@ func PrintChain(nodeID, basePath string) (output string) {
 
 func CreateBlockChain(address, nodeID, basePath string) (output string) {
 
-	defer func() {
-		if err := recover(); err != nil {
-			log.Println(err)
-			output = fmt.Sprintf("%v", err)
-		}
-	}()
-
-	if !wallet.ValidateAddress(address) {
-		return ("Address is not Valid")
+	if err := wallet.ValidateAddress(address); err != nil {
+		return err.Error()
 	}
-	chain := blockchain.InitBlockChain(address, nodeID, basePath)
-	defer chain.Database.Close()
-
-	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
+	chain, err := blockchain.InitBlockChain(address, nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
 
 	return ("Finished!")
 }
 
 func GetBalance(address, nodeID, basePath string) (output string) {
 
-	defer func() {
-		if err := recover(); err != nil {
-			log.Println(err)
-			output = fmt.Sprintf("%v", err)
-		}
-	}()
+	pubKeyHash, err := wallet.AddressPubKeyHash(address)
+	if err != nil {
+		return err.Error()
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+
+	balance, _, err := chain.GetBalance(pubKeyHash)
+	if err != nil {
+		return err.Error()
+	}
 
-	if !wallet.ValidateAddress(address) {
-		return ("Address is not Valid")
+	return strconv.Itoa(balance)
+}
+
+func GetImmatureBalance(address, nodeID, basePath string) (output string) {
+
+	pubKeyHash, err := wallet.AddressPubKeyHash(address)
+	if err != nil {
+		return err.Error()
 	}
-	chain := blockchain.ContinueBlockChain(nodeID, basePath)
-	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	defer chain.Database.Close()
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
 
-	balance := 0
-	pubKeyHash := wallet.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUnspentTransactions(pubKeyHash)
+	_, immature, err := chain.GetBalance(pubKeyHash)
+	if err != nil {
+		return err.Error()
+	}
 
+	return strconv.Itoa(immature)
+}
+
+func GetAddressHistory(address, nodeID, basePath string, page, pageSize int) (output string) {
+
+	pubKeyHash, err := wallet.AddressPubKeyHash(address)
+	if err != nil {
+		return err.Error()
+	}
+	if page < 0 || pageSize <= 0 {
+		return "Page must be 0 or more and page size more than 0"
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+
+	history, err := chain.GetAddressHistory(pubKeyHash, page*pageSize, pageSize)
+	if err != nil {
+		return err.Error()
+	}
+
+	result := ""
+	for _, atx := range history {
+		result += fmt.Sprintf("Height %d Tx %x Received %d Sent %d\n", atx.Height, atx.TxID, atx.Received, atx.Sent)
+	}
+
+	return result
+}
+
+func GetLightBalance(address, nodeID, basePath string) (output string) {
+
+	pubKeyHash, err := wallet.AddressPubKeyHash(address)
+	if err != nil {
+		return err.Error()
+	}
+	headers, err := blockchain.OpenHeaderChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer headers.Close()
+
+	UTXOs, err := headers.FindUnspentOutputs(pubKeyHash)
+	if err != nil {
+		return err.Error()
+	}
+
+	balance := 0
 	for _, out := range UTXOs {
 		balance += out.Value
 	}
@@ -186,44 +445,508 @@ func GetBalance(address, nodeID, basePath string) (output string) {
 	return strconv.Itoa(balance)
 }
 
-func Send(from, to string, amount int, nodeID, basePath string, mineNow bool) (output string) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Println(err)
-			output = fmt.Sprintf("%v", err)
-		}
-	}()
+func GetSupply(nodeID, basePath string) (output string) {
 
-	if !wallet.ValidateAddress(to) {
-		return ("Address is not Valid")
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return err.Error()
+	}
+
+	return strconv.Itoa(blockchain.TotalSupply(bestHeight))
+}
+
+func Send(from, to string, amount, fee int, nodeID, basePath string, mineNow bool) (output string) {
+
+	if err := wallet.ValidateAddress(to); err != nil {
+		return err.Error()
 	}
-	if !wallet.ValidateAddress(from) {
-		return ("Address is not Valid")
+	if err := wallet.ValidateAddress(from); err != nil {
+		return err.Error()
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
 	}
-	chain := blockchain.ContinueBlockChain(nodeID, basePath)
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	defer chain.Database.Close()
+	defer chain.Close()
 
 	wallets, err := wallet.CreateWallets(nodeID, basePath)
 	if err != nil {
-		log.Panic(err)
+		return err.Error()
+	}
+	wallet, err := wallets.GetWallet(from)
+	if err != nil {
+		return err.Error()
 	}
-	wallet := wallets.GetWallet(from)
 
-	tx := blockchain.NewTransaction(&wallet, to, amount, &UTXOSet)
+	tx, err := blockchain.NewTransaction(&wallet, to, amount, fee, &UTXOSet)
+	if err != nil {
+		return err.Error()
+	}
+	if err := submitTransaction(chain, tx, from, fee, mineNow); err != nil {
+		return err.Error()
+	}
+
+	return ("Success!")
+}
+
+// lockTime is a block height, or a unix time from 500000000 on. The transaction cannot be mined before it.
+func SendTimeLocked(from, to string, amount, fee, lockTime int, nodeID, basePath string, mineNow bool) (output string) {
+
+	if err := wallet.ValidateAddress(to); err != nil {
+		return err.Error()
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	wallets, err := wallet.CreateWallets(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	w, err := wallets.GetWallet(from)
+	if err != nil {
+		return err.Error()
+	}
+
+	tx, err := blockchain.NewTimeLockedTransaction(&w, to, amount, fee, int64(lockTime), &UTXOSet)
+	if err != nil {
+		return err.Error()
+	}
+	if err := submitTransaction(chain, tx, from, fee, mineNow); err != nil {
+		return err.Error()
+	}
+
+	return ("Success!")
+}
+
+// Pays to can only spend once the lock has passed. An absolute lock is a block height, or a unix time from
+// 500000000 on. A relative lock is a number of blocks after the payment is mined, or 4194304 plus a number of
+// 512 second units. The redeem script returned is needed to spend the payment.
+func SendTimeLockedPayment(from, to string, amount, fee, lock int, relative bool, nodeID, basePath string, mineNow bool) (output string) {
+
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	wallets, err := wallet.CreateWallets(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	w, err := wallets.GetWallet(from)
+	if err != nil {
+		return err.Error()
+	}
+
+	tx, redeemScript, err := blockchain.NewTimeLockedPayment(&w, to, amount, fee, int64(lock), relative, &UTXOSet)
+	if err != nil {
+		return err.Error()
+	}
+	if err := submitTransaction(chain, tx, from, fee, mineNow); err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("Redeem script: %x", redeemScript)
+}
+
+func SpendTimeLockedPayment(redeemScript, address, to string, amount, fee int, nodeID, basePath string, mineNow bool) (output string) {
+
+	if err := wallet.ValidateAddress(to); err != nil {
+		return err.Error()
+	}
+	script, err := hex.DecodeString(redeemScript)
+	if err != nil {
+		return err.Error()
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	wallets, err := wallet.CreateWallets(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		return err.Error()
+	}
+
+	tx, err := blockchain.SpendTimeLocked(&w, script, to, amount, fee, &UTXOSet)
+	if err != nil {
+		return err.Error()
+	}
+	if err := submitTransaction(chain, tx, address, fee, mineNow); err != nil {
+		return err.Error()
+	}
+
+	return ("Success!")
+}
+
+// txID is a transaction still waiting in the memory pool. The replacement takes the extra fee out of the change
+// and pays enough more to evict the original and everything spending from it. Without mineNow it goes through
+// the running node's pool to reach the network; with it, the chain is opened here and the pool loaded from it.
+func BumpFee(txID, nodeID, basePath string, mineNow bool) (output string) {
+
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		return err.Error()
+	}
+	wallets, err := wallet.CreateWallets(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+
+	if !mineNow {
+		tx, err := network.BumpFee(id, wallets)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("Replaced by %x", tx.ID)
+	}
+
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	pool, err := mempool.New(chain, mempool.DefaultConfig)
+	if err != nil {
+		return err.Error()
+	}
+	original, ok := pool.Get(id)
+	if !ok {
+		return mempool.ErrNotInPool.Error()
+	}
+	extra, err := pool.ReplacementCost(id, len(original.Serialize()))
+	if err != nil {
+		return err.Error()
+	}
+	tx, address, err := blockchain.BumpFeeFromWallets(wallets, &original, blockchain.ChangeOutput, extra, &UTXOSet)
+	if err != nil {
+		return err.Error()
+	}
+	if err := pool.Add(tx); err != nil {
+		return err.Error()
+	}
+	_, fee := chain.SelectTransactions([]*blockchain.Transaction{tx})
+	block, err := mineTransaction(chain, tx, address, fee)
+	if err != nil {
+		return err.Error()
+	}
+	if err := pool.BlockConnected(block); err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("Replaced by %x", tx.ID)
+}
+
+// submitTransaction - mines the transaction straight away with the coinbase paying minerAddress, or sends it on
+func submitTransaction(chain *blockchain.BlockChain, tx *blockchain.Transaction, minerAddress string, fee int, mineNow bool) error {
 	if mineNow {
-		cbTx := blockchain.CoinbaseTx(from, "")
-		txs := []*blockchain.Transaction{cbTx, tx}
-		block := chain.MineBlock(txs)
-		UTXOSet.Update(block)
-	} else {
-		network.SendTx(network.Overlay.Table().Peers()[0].Address, tx) // FIXME possibly - replace Overlay.Table().Peers()[0].Address... with a mining bucket kademlia ??
-		// fmt.Println("send tx")
+		_, err := mineTransaction(chain, tx, minerAddress, fee)
+		return err
+	}
+
+	if network.Overlay == nil || len(network.Overlay.Table().Peers()) == 0 {
+		return errNoPeers
+	}
+	// fmt.Println("send tx")
+	return network.SendTx(network.Overlay.Table().Peers()[0].Address, tx) // FIXME possibly - replace Overlay.Table().Peers()[0].Address... with a mining bucket kademlia ??
+}
+
+// mineTransaction - mines the transaction into a block on the tip with the coinbase paying minerAddress
+func mineTransaction(chain *blockchain.BlockChain, tx *blockchain.Transaction, minerAddress string, fee int) (*blockchain.Block, error) {
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	cbTx, err := blockchain.CoinbaseTx(minerAddress, "", bestHeight+1, fee)
+	if err != nil {
+		return nil, err
+	}
+
+	return chain.MineBlock([]*blockchain.Transaction{cbTx, tx})
+}
+
+func GetPublicKey(address, nodeID, basePath string) (output string) {
+
+	wallets, err := wallet.CreateWallets(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		return err.Error()
+	}
+
+	return hex.EncodeToString(w.PublicKey)
+}
+
+// pubKeys is a comma separated list of hex public keys, in the order the signatures must come in
+func CreateMultiSigAddress(required int, pubKeys string) (output string) {
+
+	var keys [][]byte
+	for _, pubKey := range strings.Split(pubKeys, ",") {
+		key, err := hex.DecodeString(strings.TrimSpace(pubKey))
+		if err != nil {
+			return err.Error()
+		}
+		keys = append(keys, key)
+	}
+
+	redeemScript, err := blockchain.MultiSigScript(required, keys)
+	if err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("Address: %s\nRedeem script: %x", wallet.ScriptAddress(redeemScript), redeemScript)
+}
+
+func CreateMultiSigTransaction(redeemScript, to string, amount, fee int, nodeID, basePath string) (output string) {
+
+	if err := wallet.ValidateAddress(to); err != nil {
+		return err.Error()
+	}
+	script, err := hex.DecodeString(redeemScript)
+	if err != nil {
+		return err.Error()
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	tx, err := blockchain.NewMultiSigTransaction(script, to, amount, fee, &UTXOSet)
+	if err != nil {
+		return err.Error()
+	}
+
+	return hex.EncodeToString(tx.Serialize())
+}
+
+func SignMultiSigTransaction(rawTx, redeemScript, address, nodeID, basePath string) (output string) {
+
+	tx, err := decodeRawTransaction(rawTx)
+	if err != nil {
+		return err.Error()
+	}
+	script, err := hex.DecodeString(redeemScript)
+	if err != nil {
+		return err.Error()
+	}
+	wallets, err := wallet.CreateWallets(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		return err.Error()
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+
+	if err := chain.SignMultiSigTransaction(tx, w.PrivateKey, script); err != nil {
+		return err.Error()
+	}
+
+	return hex.EncodeToString(tx.Serialize())
+}
+
+// rawTxs is a comma separated list of partly signed copies of the transaction
+func CombineMultiSigTransactions(redeemScript, rawTxs, nodeID, basePath string) (output string) {
+
+	script, err := hex.DecodeString(redeemScript)
+	if err != nil {
+		return err.Error()
+	}
+	var partials []*blockchain.Transaction
+	for _, rawTx := range strings.Split(rawTxs, ",") {
+		tx, err := decodeRawTransaction(strings.TrimSpace(rawTx))
+		if err != nil {
+			return err.Error()
+		}
+		partials = append(partials, tx)
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+
+	tx, err := chain.CombineMultiSigTransactions(script, partials)
+	if err != nil {
+		return err.Error()
+	}
+
+	return hex.EncodeToString(tx.Serialize())
+}
+
+func SendRawTransaction(rawTx, nodeID, basePath string) (output string) {
+
+	tx, err := decodeRawTransaction(rawTx)
+	if err != nil {
+		return err.Error()
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	defer chain.Close()
+
+	if err := chain.VerifyTransaction(tx); err != nil {
+		return err.Error()
+	}
+	if err := submitTransaction(chain, tx, "", 0, false); err != nil {
+		return err.Error()
 	}
 
 	return ("Success!")
 }
 
+// redeemScript is only needed when from is a multisig address. Works on a node with no wallets.
+func CreatePartialTransaction(from, to string, amount, fee int, redeemScript, nodeID, basePath string) (output string) {
+
+	if err := wallet.ValidateAddress(to); err != nil {
+		return err.Error()
+	}
+	if err := wallet.ValidateAddress(from); err != nil {
+		return err.Error()
+	}
+	script, err := hex.DecodeString(redeemScript)
+	if err != nil {
+		return err.Error()
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	if len(script) == 0 {
+		script = nil
+	}
+	p, err := blockchain.NewPartialTx(from, to, amount, fee, script, &UTXOSet)
+	if err != nil {
+		return err.Error()
+	}
+
+	return hex.EncodeToString(p.Serialize())
+}
+
+func DecodePartialTransaction(rawPartial string) (output string) {
+
+	p, err := decodePartialTransaction(rawPartial)
+	if err != nil {
+		return err.Error()
+	}
+
+	return p.String()
+}
+
+// needs only the wallet file, not the chain
+func SignPartialTransaction(rawPartial, address, nodeID, basePath string) (output string) {
+
+	p, err := decodePartialTransaction(rawPartial)
+	if err != nil {
+		return err.Error()
+	}
+	wallets, err := wallet.CreateWallets(nodeID, basePath)
+	if err != nil {
+		return err.Error()
+	}
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		return err.Error()
+	}
+
+	if err := p.Sign(w.PrivateKey); err != nil {
+		return err.Error()
+	}
+
+	return hex.EncodeToString(p.Serialize())
+}
+
+// rawPartials is a comma separated list of copies of the partially signed transaction
+func CombinePartialTransactions(rawPartials string) (output string) {
+
+	var partials []*blockchain.PartialTx
+	for _, rawPartial := range strings.Split(rawPartials, ",") {
+		p, err := decodePartialTransaction(strings.TrimSpace(rawPartial))
+		if err != nil {
+			return err.Error()
+		}
+		partials = append(partials, p)
+	}
+
+	p, err := blockchain.CombinePartialTxs(partials)
+	if err != nil {
+		return err.Error()
+	}
+
+	return hex.EncodeToString(p.Serialize())
+}
+
+// the raw transaction it returns is broadcast with SendRawTransaction
+func FinalizePartialTransaction(rawPartial string) (output string) {
+
+	p, err := decodePartialTransaction(rawPartial)
+	if err != nil {
+		return err.Error()
+	}
+
+	tx, err := p.Finalize()
+	if err != nil {
+		return err.Error()
+	}
+
+	return hex.EncodeToString(tx.Serialize())
+}
+
+func decodePartialTransaction(rawPartial string) (*blockchain.PartialTx, error) {
+	data, err := hex.DecodeString(rawPartial)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockchain.DeserializePartialTx(data)
+}
+
+func decodeRawTransaction(rawTx string) (*blockchain.Transaction, error) {
+	data, err := hex.DecodeString(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := blockchain.DeserializeTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
 // func StartNodeStream(nodeID, minerAddress string) (output string)  { // TODO - allow for bootstrap Addresses as extra params (no flag) or with a flagh but allow for multiple addresses
 
 // 	defer func() {
@@ -0,0 +1,607 @@
+package mempool
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jlynch25/golang-blockchain/blockchain"
+)
+
+var poolPrefix = []byte("mempool-")
+
+// MaxReplacements is the most pool transactions, descendants included, one replacement may evict
+const MaxReplacements = 100
+
+var (
+	// ErrAlreadyHave is returned for a transaction that is already in the pool
+	ErrAlreadyHave = errors.New("Transaction is already in the pool")
+	// ErrCoinbase is returned for coinbase transactions, which only belong in blocks
+	ErrCoinbase = errors.New("Coinbase transactions are not accepted into the pool")
+	// ErrMissingInputs is returned when an input is not in the UTXO set or the pool
+	ErrMissingInputs = errors.New("Transaction spends an output that is missing or already spent")
+	// ErrConflict is returned when an input is already spent by a pool transaction that cannot be replaced
+	ErrConflict = errors.New("Transaction spends an output another pool transaction already spends")
+	// ErrNotInPool is returned when a transaction is not in the pool
+	ErrNotInPool = errors.New("Transaction is not in the memory pool")
+	// ErrReplacementFee is returned for a replacement that does not pay enough more than what it would evict
+	ErrReplacementFee = errors.New("Replacement does not pay enough more than the transactions it replaces")
+	// ErrTooManyReplacements is returned for a replacement that would evict more than MaxReplacements transactions
+	ErrTooManyReplacements = errors.New("Replacement would evict too many transactions")
+	// ErrInvalidSignature is returned when a signature does not check out
+	ErrInvalidSignature = errors.New("Transaction signature is invalid")
+	// ErrNegativeFee is returned when the outputs pay out more than the inputs bring in
+	ErrNegativeFee = errors.New("Transaction outputs exceed its inputs")
+	// ErrPoolFull is returned when the pool is full and the transaction pays too little to evict anything
+	ErrPoolFull = errors.New("Pool is full")
+)
+
+// Config struct
+type Config struct {
+	MaxCount       int           // most transactions held at once
+	MaxBytes       int           // most serialized bytes held at once
+	Expiry         time.Duration // how long a transaction may wait before it is dropped
+	Persist        bool          // keep the pool in the chain database across restarts
+	ReplaceFeeRate int           // fee per 1000 bytes a replacement must add on top of what it evicts
+}
+
+// DefaultConfig is the configuration nodes use unless told otherwise
+var DefaultConfig = Config{
+	MaxCount:       5000,
+	MaxBytes:       5 << 20,
+	Expiry:         72 * time.Hour,
+	Persist:        true,
+	ReplaceFeeRate: 1,
+}
+
+// ReplacementFee function - the least a replacement of size bytes must pay on top of the fees of the
+// transactions it evicts, so that relaying it is paid for. Always at least 1.
+func (config Config) ReplacementFee(size int) int {
+	fee := (size*config.ReplaceFeeRate + 999) / 1000
+	if fee < 1 {
+		return 1
+	}
+
+	return fee
+}
+
+// entry struct - a pool transaction with what was worked out when it was admitted
+type entry struct {
+	Tx    blockchain.Transaction
+	Fee   int
+	Size  int
+	Added int64
+}
+
+// Mempool struct - transactions waiting to be mined. Safe for concurrent use.
+type Mempool struct {
+	mutex   sync.RWMutex
+	chain   *blockchain.BlockChain
+	config  Config
+	entries map[string]*entry
+	spent   map[string]string // outpoint -> ID of the pool transaction spending it
+	size    int
+}
+
+// New function - loads any persisted transactions back in, dropping those no longer valid
+func New(chain *blockchain.BlockChain, config Config) (*Mempool, error) {
+	pool := &Mempool{
+		chain:   chain,
+		config:  config,
+		entries: make(map[string]*entry),
+		spent:   make(map[string]string),
+	}
+
+	if config.Persist {
+		if err := pool.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return pool, nil
+}
+
+// outpoint function
+func outpoint(txID []byte, out int) string {
+	return fmt.Sprintf("%x:%d", txID, out)
+}
+
+// Add function - admits a transaction after checking its signatures, that its lock times let it into the next
+// block and that every input is unspent in the UTXO set or created by another pool transaction. An input
+// already spent in the pool is only allowed for a replacement: every transaction it conflicts with must signal
+// replace-by-fee, and it must pay more than them and their descendants, which it then evicts.
+func (pool *Mempool) Add(tx *blockchain.Transaction) error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	return pool.add(tx, time.Now().Unix())
+}
+
+// add function - the pool must be locked
+func (pool *Mempool) add(tx *blockchain.Transaction, added int64) error {
+	txID := hex.EncodeToString(tx.ID)
+
+	if tx.IsCoinbase() {
+		return ErrCoinbase
+	}
+	if _, ok := pool.entries[txID]; ok {
+		return ErrAlreadyHave
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return blockchain.ErrBadTxID
+	}
+	if _, err := tx.OutputValue(); err != nil {
+		return err
+	}
+
+	height, medianTime, err := pool.chain.SpendContext()
+	if err != nil {
+		return err
+	}
+
+	prevTXs, coinHeights, conflicts, err := pool.prevTransactions(tx, height)
+	if err != nil {
+		return err
+	}
+
+	if err := pool.chain.CheckLockTime(tx, coinHeights, height, medianTime); err != nil {
+		return err
+	}
+	if err := tx.Verify(prevTXs); err != nil {
+		return ErrInvalidSignature
+	}
+
+	fee, err := tx.Fee(prevTXs)
+	if err != nil {
+		return err
+	}
+	if fee < 0 {
+		return ErrNegativeFee
+	}
+
+	e := &entry{*tx, fee, len(tx.Serialize()), added}
+	evicted := make(map[string]bool)
+	if len(conflicts) > 0 {
+		if evicted, err = pool.checkReplacement(e, conflicts); err != nil {
+			return err
+		}
+	}
+	if err := pool.makeRoom(e, evicted); err != nil {
+		return err
+	}
+
+	// nothing leaves the pool until e is sure to go in
+	for txID := range evicted {
+		if err := pool.remove(txID, false); err != nil {
+			return err
+		}
+	}
+
+	pool.entries[txID] = e
+	pool.size += e.Size
+	for _, in := range tx.Inputs {
+		pool.spent[outpoint(in.ID, in.Out)] = txID
+	}
+
+	return pool.persist(e)
+}
+
+// prevTransactions function - finds the transactions tx spends, from the pool or the chain, and checks the
+// outputs are still unspent and can be spent in a block at the height. Also returns the height each output was
+// created at, outputs of pool transactions counting as created at the height, and the pool transactions
+// already spending any of the outputs.
+func (pool *Mempool) prevTransactions(tx *blockchain.Transaction, height int) (map[string]blockchain.Transaction, []int, map[string]bool, error) {
+	prevTXs := make(map[string]blockchain.Transaction)
+	UTXOSet := blockchain.UTXOSet{Blockchain: pool.chain}
+	seen := make(map[string]bool)
+	var coinHeights []int
+	conflicts := make(map[string]bool)
+
+	for _, in := range tx.Inputs {
+		key := outpoint(in.ID, in.Out)
+		if seen[key] {
+			return nil, nil, nil, blockchain.ErrDoubleSpend
+		}
+		seen[key] = true
+
+		if conflict, ok := pool.spent[key]; ok {
+			conflicts[conflict] = true
+		}
+
+		inTxID := hex.EncodeToString(in.ID)
+		if parent, ok := pool.entries[inTxID]; ok {
+			if in.Out < 0 || in.Out >= len(parent.Tx.Outputs) {
+				return nil, nil, nil, ErrMissingInputs
+			}
+			prevTXs[inTxID] = parent.Tx
+			coinHeights = append(coinHeights, height)
+			continue
+		}
+
+		prevTX, err := pool.chain.FindInputTransaction(in.ID)
+		if err == blockchain.ErrTxNotFound {
+			return nil, nil, nil, ErrMissingInputs
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return nil, nil, nil, ErrMissingInputs
+		}
+
+		entry, err := UTXOSet.FindEntry(in.ID, in.Out)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if entry == nil {
+			return nil, nil, nil, ErrMissingInputs
+		}
+		if !entry.IsMature(height) {
+			return nil, nil, nil, blockchain.ErrImmatureSpend
+		}
+		prevTXs[inTxID] = prevTX
+		coinHeights = append(coinHeights, entry.Height)
+	}
+
+	return prevTXs, coinHeights, conflicts, nil
+}
+
+// checkReplacement function - e may replace the conflicting transactions if each signals replace-by-fee, e
+// spends nothing they or their descendants create, and e pays more than all of them together by at least
+// the replacement fee for its size. Returns the transactions e would evict.
+func (pool *Mempool) checkReplacement(e *entry, conflicts map[string]bool) (map[string]bool, error) {
+	evicted := make(map[string]bool)
+	for txID := range conflicts {
+		if !pool.entries[txID].Tx.SignalsReplacement() {
+			return nil, ErrConflict
+		}
+		pool.descendants(txID, evicted)
+	}
+
+	if len(evicted) > MaxReplacements {
+		return nil, ErrTooManyReplacements
+	}
+	for _, in := range e.Tx.Inputs {
+		if evicted[hex.EncodeToString(in.ID)] {
+			return nil, ErrConflict
+		}
+	}
+
+	if e.Fee-pool.fees(evicted) < pool.config.ReplacementFee(e.Size) {
+		return nil, ErrReplacementFee
+	}
+
+	return evicted, nil
+}
+
+// fees function - the fees the pool transactions pay together
+func (pool *Mempool) fees(txIDs map[string]bool) int {
+	total := 0
+	for txID := range txIDs {
+		total += pool.entries[txID].Fee
+	}
+
+	return total
+}
+
+// descendants function - adds the transaction and every pool transaction spending its outputs, directly or
+// not, to the set. The pool must be locked.
+func (pool *Mempool) descendants(txID string, set map[string]bool) {
+	e, ok := pool.entries[txID]
+	if !ok || set[txID] {
+		return
+	}
+	set[txID] = true
+
+	for outIdx := range e.Tx.Outputs {
+		if child, ok := pool.spent[outpoint(e.Tx.ID, outIdx)]; ok {
+			pool.descendants(child, set)
+		}
+	}
+}
+
+// makeRoom function - adds the lowest fee rate transactions and their descendants to evicted until e fits
+// once everything in it is gone, as long as they pay less than e. e's own pool ancestors are never picked.
+// Nothing is taken out of the pool here.
+func (pool *Mempool) makeRoom(e *entry, evicted map[string]bool) error {
+	ancestors := make(map[string]bool)
+	pool.ancestors(&e.Tx, ancestors)
+
+	count, size := len(pool.entries), pool.size
+	for txID := range evicted {
+		count--
+		size -= pool.entries[txID].Size
+	}
+
+	for count+1 > pool.config.MaxCount || size+e.Size > pool.config.MaxBytes {
+		var lowest *entry
+		lowestID := ""
+		for txID, other := range pool.entries {
+			if evicted[txID] || ancestors[txID] {
+				continue
+			}
+			if lowest == nil || other.Fee*lowest.Size < lowest.Fee*other.Size {
+				lowest, lowestID = other, txID
+			}
+		}
+
+		if lowest == nil || lowest.Fee*e.Size >= e.Fee*lowest.Size {
+			return ErrPoolFull
+		}
+
+		victims := make(map[string]bool)
+		pool.descendants(lowestID, victims)
+		for txID := range victims {
+			if !evicted[txID] {
+				evicted[txID] = true
+				count--
+				size -= pool.entries[txID].Size
+			}
+		}
+	}
+
+	return nil
+}
+
+// ancestors function - adds every pool transaction tx spends from, directly or not, to the set. The pool must
+// be locked.
+func (pool *Mempool) ancestors(tx *blockchain.Transaction, set map[string]bool) {
+	for _, in := range tx.Inputs {
+		parentID := hex.EncodeToString(in.ID)
+		parent, ok := pool.entries[parentID]
+		if !ok || set[parentID] {
+			continue
+		}
+		set[parentID] = true
+		pool.ancestors(&parent.Tx, set)
+	}
+}
+
+// remove function - takes a transaction out of the pool, along with everything spending its outputs
+// if withDescendants is set. The pool must be locked.
+func (pool *Mempool) remove(txID string, withDescendants bool) error {
+	e, ok := pool.entries[txID]
+	if !ok {
+		return nil
+	}
+
+	if withDescendants {
+		for outIdx := range e.Tx.Outputs {
+			if child, ok := pool.spent[outpoint(e.Tx.ID, outIdx)]; ok {
+				if err := pool.remove(child, true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, in := range e.Tx.Inputs {
+		delete(pool.spent, outpoint(in.ID, in.Out))
+	}
+	delete(pool.entries, txID)
+	pool.size -= e.Size
+
+	return pool.unpersist(e.Tx.ID)
+}
+
+// ReplacementCost function - the least a replacement of size bytes for the pool transaction must pay on top of
+// the transaction's own fee: the fees of its descendants, which it evicts too, and the replacement fee
+func (pool *Mempool) ReplacementCost(txID []byte, size int) (int, error) {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+
+	id := hex.EncodeToString(txID)
+	if _, ok := pool.entries[id]; !ok {
+		return 0, ErrNotInPool
+	}
+
+	descendants := make(map[string]bool)
+	pool.descendants(id, descendants)
+	delete(descendants, id)
+
+	return pool.fees(descendants) + pool.config.ReplacementFee(size), nil
+}
+
+// Remove function - drops a transaction and everything spending its outputs
+func (pool *Mempool) Remove(txID []byte) error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	return pool.remove(hex.EncodeToString(txID), true)
+}
+
+// Has function
+func (pool *Mempool) Has(txID []byte) bool {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+
+	_, ok := pool.entries[hex.EncodeToString(txID)]
+	return ok
+}
+
+// Get function
+func (pool *Mempool) Get(txID []byte) (blockchain.Transaction, bool) {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+
+	e, ok := pool.entries[hex.EncodeToString(txID)]
+	if !ok {
+		return blockchain.Transaction{}, false
+	}
+	return e.Tx, true
+}
+
+// Count function
+func (pool *Mempool) Count() int {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+
+	return len(pool.entries)
+}
+
+// Transactions function - a copy of every transaction in the pool
+func (pool *Mempool) Transactions() []*blockchain.Transaction {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+
+	var txs []*blockchain.Transaction
+	for _, e := range pool.entries {
+		tx := e.Tx
+		txs = append(txs, &tx)
+	}
+
+	return txs
+}
+
+// Expire function - drops transactions that have waited longer than the configured expiry
+func (pool *Mempool) Expire() error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	cutoff := time.Now().Add(-pool.config.Expiry).Unix()
+	for txID, e := range pool.entries {
+		if e.Added < cutoff {
+			if err := pool.remove(txID, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// BlockConnected function - drops the block's transactions and any pool transactions that conflict with them
+func (pool *Mempool) BlockConnected(block *blockchain.Block) error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for _, tx := range block.Transactions {
+		// mined transactions leave, but their pool children are still valid
+		if err := pool.remove(hex.EncodeToString(tx.ID), false); err != nil {
+			return err
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if conflict, ok := pool.spent[outpoint(in.ID, in.Out)]; ok {
+				if err := pool.remove(conflict, true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// BlockDisconnected function - puts the block's transactions back in the pool. The chain must already have been
+// moved off the block. Transactions that are no longer valid are left out.
+func (pool *Mempool) BlockDisconnected(block *blockchain.Block) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	now := time.Now().Unix()
+	for _, tx := range block.Transactions {
+		if err := pool.add(tx, now); err != nil && err != ErrCoinbase {
+			fmt.Printf("Dropped transaction %x from disconnected block: %s\n", tx.ID, err)
+		}
+	}
+}
+
+// persist function
+func (pool *Mempool) persist(e *entry) error {
+	if !pool.config.Persist {
+		return nil
+	}
+
+	var buff bytes.Buffer
+	if err := gob.NewEncoder(&buff).Encode(e); err != nil {
+		return err
+	}
+
+	return pool.chain.Store.Update(func(txn blockchain.StoreTx) error {
+		return txn.Set(append(poolPrefix, e.Tx.ID...), buff.Bytes())
+	})
+}
+
+// unpersist function
+func (pool *Mempool) unpersist(txID []byte) error {
+	if !pool.config.Persist {
+		return nil
+	}
+
+	return pool.chain.Store.Update(func(txn blockchain.StoreTx) error {
+		return txn.Delete(append(poolPrefix, txID...))
+	})
+}
+
+// load function - readmits persisted transactions in the order they were added, so parents go in before their
+// children, retrying those added in the same second whose parent comes later. Whatever is not readmitted is
+// deleted.
+func (pool *Mempool) load() error {
+	var saved []*entry
+	var undecodable [][]byte
+
+	err := pool.chain.Store.View(func(txn blockchain.StoreTx) error {
+		return txn.Iterate(poolPrefix, false, func(k, v []byte) error {
+			var e entry
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&e); err != nil {
+				undecodable = append(undecodable, append([]byte{}, k[len(poolPrefix):]...))
+				return nil
+			}
+			saved = append(saved, &e)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(saved, func(i, j int) bool {
+		return saved[i].Added < saved[j].Added
+	})
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for _, txID := range undecodable {
+		if err := pool.unpersist(txID); err != nil {
+			return err
+		}
+	}
+
+	for admitted := true; admitted && len(saved) > 0; {
+		admitted = false
+		var waiting []*entry
+
+		for _, e := range saved {
+			err := pool.add(&e.Tx, e.Added)
+			if err == ErrMissingInputs {
+				waiting = append(waiting, e)
+				continue
+			}
+			if err != nil {
+				if err := pool.unpersist(e.Tx.ID); err != nil {
+					return err
+				}
+				continue
+			}
+			admitted = true
+		}
+		saved = waiting
+	}
+
+	for _, e := range saved {
+		if err := pool.unpersist(e.Tx.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,344 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/jlynch25/golang-blockchain/blockchain"
+	"github.com/jlynch25/golang-blockchain/wallet"
+)
+
+// newTestChain function - an in-memory chain of three blocks whose coinbases pay a new wallet, returned with the
+// coinbases. Coinbase outputs can be spent straight away for the length of the test.
+func newTestChain(t *testing.T) (*blockchain.BlockChain, *wallet.Wallet, []*blockchain.Transaction) {
+	t.Helper()
+
+	maturity := blockchain.CoinbaseMaturity
+	blockchain.CoinbaseMaturity = 0
+	t.Cleanup(func() { blockchain.CoinbaseMaturity = maturity })
+
+	w := newTestWallet(t)
+	chain, err := blockchain.InitBlockChainWithConfig(string(w.Address()), blockchain.Config{Backend: blockchain.MemoryBackend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Close() })
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := []*blockchain.Transaction{genesis.Transactions[0]}
+
+	for height := 1; height <= 2; height++ {
+		cbTx, err := blockchain.CoinbaseTx(string(w.Address()), "", height, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := chain.MineBlock([]*blockchain.Transaction{cbTx}); err != nil {
+			t.Fatal(err)
+		}
+		coins = append(coins, cbTx)
+	}
+
+	return chain, w, coins
+}
+
+// newTestWallet function
+func newTestWallet(t *testing.T) *wallet.Wallet {
+	t.Helper()
+
+	w, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+// newTestPool function
+func newTestPool(t *testing.T, chain *blockchain.BlockChain, config Config) *Mempool {
+	t.Helper()
+
+	pool, err := New(chain, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pool
+}
+
+// testConfig function - the default configuration without persistence, holding at most count transactions
+func testConfig(count int) Config {
+	config := DefaultConfig
+	config.Persist = false
+	config.MaxCount = count
+
+	return config
+}
+
+// spendTestTx function - a transaction spending output 0 of prev, signed by w, paying value to the wallet and
+// leaving the rest as the fee
+func spendTestTx(t *testing.T, w *wallet.Wallet, prev *blockchain.Transaction, sequence uint32, value int, to *wallet.Wallet) *blockchain.Transaction {
+	t.Helper()
+
+	out, err := blockchain.NewTxOutput(value, string(to.Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := &blockchain.Transaction{
+		Inputs:  []blockchain.TxInput{{ID: prev.ID, Out: 0, Sequence: sequence}},
+		Outputs: []blockchain.TxOutput{*out},
+	}
+	tx.ID = tx.Hash()
+	if err := tx.Sign(w.PrivateKey, map[string]blockchain.Transaction{hex.EncodeToString(prev.ID): *prev}); err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+func TestAdd(t *testing.T) {
+	chain, w, coins := newTestChain(t)
+	other := newTestWallet(t)
+	pool := newTestPool(t, chain, testConfig(10))
+	value := coins[0].Outputs[0].Value
+
+	tx := spendTestTx(t, w, coins[0], blockchain.SequenceFinal, value-1, other)
+	if err := pool.Add(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	// changing an output after signing leaves the signature for other outputs
+	tampered := spendTestTx(t, w, coins[1], blockchain.SequenceFinal, value-1, other)
+	tampered.Outputs[0].Value--
+	tampered.ID = tampered.Hash()
+	unknown := spendTestTx(t, w, coins[2], blockchain.SequenceFinal, value-1, other)
+	cbTx, err := blockchain.CoinbaseTx(string(w.Address()), "", 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		tx   *blockchain.Transaction
+		err  error
+	}{
+		{"already in the pool", tx, ErrAlreadyHave},
+		{"coinbase", cbTx, ErrCoinbase},
+		{"spends an output the pool already spends", spendTestTx(t, w, coins[0], blockchain.SequenceFinal, value-2, w), ErrConflict},
+		{"outputs exceed the inputs", spendTestTx(t, w, coins[1], blockchain.SequenceFinal, value+1, other), ErrNegativeFee},
+		{"changed after signing", tampered, ErrInvalidSignature},
+		{"spends an output that does not exist", spendTestTx(t, other, unknown, blockchain.SequenceFinal, 1, other), ErrMissingInputs},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := pool.Add(test.tx); !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	if pool.Count() != 1 {
+		t.Fatalf("expected only the valid transaction in the pool, got %d", pool.Count())
+	}
+}
+
+func TestBlockConnected(t *testing.T) {
+	chain, w, coins := newTestChain(t)
+	other := newTestWallet(t)
+	pool := newTestPool(t, chain, testConfig(10))
+	value := coins[0].Outputs[0].Value
+
+	mined := spendTestTx(t, w, coins[0], blockchain.SequenceFinal, value-1, other)
+	child := spendTestTx(t, other, mined, blockchain.SequenceFinal, value-2, other)
+	spent := spendTestTx(t, w, coins[1], blockchain.SequenceFinal, value-1, other)
+	spentChild := spendTestTx(t, other, spent, blockchain.SequenceFinal, value-2, other)
+	for _, tx := range []*blockchain.Transaction{mined, child, spent, spentChild} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the block holds one pool transaction and another spending the same output as a second one
+	cbTx, err := blockchain.CoinbaseTx(string(w.Address()), "", 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflict := spendTestTx(t, w, coins[1], blockchain.SequenceFinal, value-1, w)
+	block, err := chain.MineBlock([]*blockchain.Transaction{cbTx, mined, conflict})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.BlockConnected(block); err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.Count() != 1 || !pool.Has(child.ID) {
+		t.Fatalf("expected only the mined transaction's child to be left, got %d", pool.Count())
+	}
+}
+
+func TestReplacement(t *testing.T) {
+	tests := []struct {
+		name     string
+		sequence uint32 // of the original
+		fee      int    // of the replacement, the original and its child pay 1 each
+		err      error
+	}{
+		{name: "pays for what it evicts", sequence: blockchain.SequenceReplaceable, fee: 3},
+		{name: "pays no more than what it evicts", sequence: blockchain.SequenceReplaceable, fee: 2, err: ErrReplacementFee},
+		{name: "original does not signal", sequence: blockchain.SequenceFinal, fee: 3, err: ErrConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain, w, coins := newTestChain(t)
+			other := newTestWallet(t)
+			pool := newTestPool(t, chain, testConfig(10))
+			value := coins[0].Outputs[0].Value
+
+			original := spendTestTx(t, w, coins[0], test.sequence, value-1, other)
+			child := spendTestTx(t, other, original, blockchain.SequenceReplaceable, value-2, other)
+			for _, tx := range []*blockchain.Transaction{original, child} {
+				if err := pool.Add(tx); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			replacement := spendTestTx(t, w, coins[0], blockchain.SequenceReplaceable, value-test.fee, w)
+			err := pool.Add(replacement)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+
+			replaced := test.err == nil
+			if pool.Has(replacement.ID) != replaced || pool.Has(original.ID) == replaced || pool.Has(child.ID) == replaced {
+				t.Fatalf("expected the original and its child to be replaced: %t", replaced)
+			}
+		})
+	}
+}
+
+func TestReplacementCost(t *testing.T) {
+	chain, w, coins := newTestChain(t)
+	other := newTestWallet(t)
+	pool := newTestPool(t, chain, testConfig(10))
+	value := coins[0].Outputs[0].Value
+
+	original := spendTestTx(t, w, coins[0], blockchain.SequenceReplaceable, value-1, other)
+	child := spendTestTx(t, other, original, blockchain.SequenceReplaceable, value-3, other)
+	for _, tx := range []*blockchain.Transaction{original, child} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	size := len(original.Serialize())
+	cost, err := pool.ReplacementCost(original.ID, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2 + pool.config.ReplacementFee(size); cost != want {
+		t.Fatalf("expected a replacement cost of %d, got %d", want, cost)
+	}
+
+	// a replacement paying the original's fee plus the cost is enough
+	replacement := spendTestTx(t, w, coins[0], blockchain.SequenceReplaceable, value-1-cost, w)
+	if err := pool.Add(replacement); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.ReplacementCost(original.ID, size); err != ErrNotInPool {
+		t.Fatalf("expected %v, got %v", ErrNotInPool, err)
+	}
+}
+
+func TestFullPoolKeepsAncestors(t *testing.T) {
+	chain, w, coins := newTestChain(t)
+	other := newTestWallet(t)
+	pool := newTestPool(t, chain, testConfig(2))
+	value := coins[0].Outputs[0].Value
+
+	parent := spendTestTx(t, w, coins[0], blockchain.SequenceReplaceable, value-1, other)
+	// the child pays a higher fee rate so the parent is the one picked for eviction
+	child := spendTestTx(t, other, parent, blockchain.SequenceReplaceable, value-4, other)
+	for _, tx := range []*blockchain.Transaction{parent, child} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the only transactions it could evict are the ones it spends from
+	grandchild := spendTestTx(t, other, child, blockchain.SequenceReplaceable, 1, other)
+	if err := pool.Add(grandchild); err != ErrPoolFull {
+		t.Fatalf("expected %v, got %v", ErrPoolFull, err)
+	}
+	if !pool.Has(parent.ID) || !pool.Has(child.ID) || pool.Count() != 2 {
+		t.Fatal("a refused transaction evicted its ancestors")
+	}
+
+	// an unrelated transaction paying a higher fee rate evicts the parent and so its child
+	unrelated := spendTestTx(t, w, coins[1], blockchain.SequenceReplaceable, 1, other)
+	if err := pool.Add(unrelated); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Has(parent.ID) || pool.Has(child.ID) || pool.Count() != 1 {
+		t.Fatal("expected the parent and its child to be evicted")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	chain, w, coins := newTestChain(t)
+	other := newTestWallet(t)
+	config := testConfig(10)
+	config.Persist = true
+	pool := newTestPool(t, chain, config)
+	value := coins[0].Outputs[0].Value
+
+	parent := spendTestTx(t, w, coins[0], blockchain.SequenceReplaceable, value-1, other)
+	child := spendTestTx(t, other, parent, blockchain.SequenceReplaceable, value-2, other)
+	unrelated := spendTestTx(t, w, coins[1], blockchain.SequenceReplaceable, value-1, other)
+	for _, tx := range []*blockchain.Transaction{parent, child, unrelated} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reloaded := newTestPool(t, chain, config)
+	if reloaded.Count() != 3 || !reloaded.Has(parent.ID) || !reloaded.Has(child.ID) || !reloaded.Has(unrelated.ID) {
+		t.Fatalf("expected every transaction to be reloaded, got %d", reloaded.Count())
+	}
+
+	// a block spending the parent's input leaves the parent and child invalid
+	cbTx, err := blockchain.CoinbaseTx(string(w.Address()), "", 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflict := spendTestTx(t, w, coins[0], blockchain.SequenceFinal, value-1, w)
+	if _, err := chain.MineBlock([]*blockchain.Transaction{cbTx, conflict}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded = newTestPool(t, chain, config)
+	if reloaded.Count() != 1 || !reloaded.Has(unrelated.ID) {
+		t.Fatalf("expected only the unrelated transaction to be reloaded, got %d", reloaded.Count())
+	}
+
+	persisted := 0
+	err = chain.Store.View(func(txn blockchain.StoreTx) error {
+		return txn.Iterate(poolPrefix, false, func(_, _ []byte) error {
+			persisted++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if persisted != 1 {
+		t.Fatalf("expected the rejected transactions to be deleted, %d are still persisted", persisted)
+	}
+}
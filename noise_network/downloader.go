@@ -0,0 +1,170 @@
+package noisenetwork
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	maxBlocksInTransit = 128              // most block bodies requested at once across all peers
+	maxInFlightPerPeer = 16               // most block bodies requested from one peer at once
+	blockTimeout       = 30 * time.Second // how long a peer has to answer a block request
+	timeoutInterval    = 5 * time.Second
+)
+
+// blockRequest struct - a block body we have asked a peer for
+type blockRequest struct {
+	hash []byte
+	peer string
+	sent time.Time
+}
+
+// downloader struct - spreads block body requests over the peers that sent us headers, a few at a time each,
+// and moves a request to another peer when it is not answered in time
+type downloader struct {
+	mutex    sync.Mutex
+	peers    map[string]int           // sync peer -> number of requests in flight
+	inFlight map[string]*blockRequest // block hash -> request
+	stalled  map[string]string        // block hash -> the peer that last failed to send it
+}
+
+var blockDownloader = &downloader{
+	peers:    make(map[string]int),
+	inFlight: make(map[string]*blockRequest),
+	stalled:  make(map[string]string),
+}
+
+// addPeer function - a peer that has sent us headers, and so should have the bodies
+func (d *downloader) addPeer(addr string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.peers[addr]; !ok {
+		d.peers[addr] = 0
+	}
+}
+
+// removePeer function - stops using a peer, its requests go to the others
+func (d *downloader) removePeer(addr string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.peers, addr)
+	for key, req := range d.inFlight {
+		if req.peer == addr {
+			delete(d.inFlight, key)
+		}
+	}
+}
+
+// received function - a block body has arrived, so its request is done
+func (d *downloader) received(hash []byte) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := hex.EncodeToString(hash)
+	if req, ok := d.inFlight[key]; ok {
+		if _, ok := d.peers[req.peer]; ok {
+			d.peers[req.peer]--
+		}
+		delete(d.inFlight, key)
+	}
+	delete(d.stalled, key)
+}
+
+// fetch function - requests the next missing block bodies on the best header chain from the least busy peers
+func (d *downloader) fetch() error {
+	chainMutex.Lock()
+	missing, err := chain.MissingBlocks(maxBlocksInTransit)
+	chainMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	var requests []*blockRequest
+	for _, hash := range missing {
+		key := hex.EncodeToString(hash)
+		if _, ok := d.inFlight[key]; ok {
+			continue
+		}
+
+		peer := d.pickPeer(d.stalled[key])
+		if peer == "" {
+			break
+		}
+
+		req := &blockRequest{hash, peer, time.Now()}
+		d.inFlight[key] = req
+		d.peers[peer]++
+		requests = append(requests, req)
+	}
+	d.mutex.Unlock()
+
+	for _, req := range requests {
+		if err := SendGetData(req.peer, "block", req.hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pickPeer function - the sync peer with the fewest requests in flight, avoiding the one that stalled on the
+// block if there is any other choice. Returns "" when every peer is busy. The downloader must be locked.
+func (d *downloader) pickPeer(avoid string) string {
+	best, fallback := "", ""
+
+	for addr, count := range d.peers {
+		if count >= maxInFlightPerPeer || isBanned(addr) {
+			continue
+		}
+		if addr == avoid {
+			fallback = addr
+			continue
+		}
+		if best == "" || count < d.peers[best] {
+			best = addr
+		}
+	}
+
+	if best == "" {
+		return fallback
+	}
+	return best
+}
+
+// expire function - takes back requests that have not been answered in time so they are sent elsewhere
+func (d *downloader) expire() {
+	d.mutex.Lock()
+	cutoff := time.Now().Add(-blockTimeout)
+	expired := 0
+
+	for key, req := range d.inFlight {
+		if req.sent.Before(cutoff) {
+			fmt.Printf("Block %s from %s timed out, retrying\n", key, req.peer)
+			if _, ok := d.peers[req.peer]; ok {
+				d.peers[req.peer]--
+			}
+			d.stalled[key] = req.peer
+			delete(d.inFlight, key)
+			expired++
+		}
+	}
+	d.mutex.Unlock()
+
+	if expired > 0 {
+		if err := d.fetch(); err != nil {
+			fmt.Printf("Failed to request blocks: %s\n", err)
+		}
+	}
+}
+
+// expireRequests function - checks for timed out block requests until the program ends
+func expireRequests() {
+	for range time.Tick(timeoutInterval) {
+		blockDownloader.expire()
+	}
+}
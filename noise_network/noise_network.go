@@ -4,28 +4,31 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"os/signal"
-	"runtime"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/jlynch25/golang-blockchain/blockchain"
+	"github.com/jlynch25/golang-blockchain/mempool"
+	"github.com/jlynch25/golang-blockchain/wallet"
 	"github.com/perlin-network/noise"
 	"github.com/perlin-network/noise/kademlia"
-	"gopkg.in/vrecan/death.v3"
 )
 
 const (
-	printedLength = 8 // printedLength is the total prefix length of a public key associated to a chat users ID.
-	version       = 1
-	commandLength = 12
+	printedLength  = 8 // printedLength is the total prefix length of a public key associated to a chat users ID.
+	version        = 1
+	commandLength  = 12
+	banThreshold   = 100 // banThreshold is the misbehaviour score at which a peer is dropped and ignored.
+	invalidTxScore = 10  // invalidTxScore is the misbehaviour score for relaying a transaction that can never be valid.
+	expiryInterval = time.Minute
+	maxFilterKeys  = 100 // maxFilterKeys is the most public key hashes a light node may filter a block by.
 )
 
 var (
@@ -38,10 +41,19 @@ var (
 
 	minerAddress string
 
-	blocksInTransit = [][]byte{}
-	memoryPool      = make(map[string]blockchain.Transaction)
+	// chainMutex stops handler goroutines changing the chain underneath each other
+	chainMutex sync.Mutex
+	// the transactions waiting to be mined
+	pool *mempool.Mempool
+
+	banScores   = make(map[string]int)
+	bannedPeers = make(map[string]bool)
+	banMutex    sync.Mutex
 )
 
+// ErrNodeNotRunning is returned when something needs the running node's pool before the node is started
+var ErrNodeNotRunning = errors.New("The node is not running, start it first")
+
 type commandMessage struct {
 	cmdType  string
 	contents []byte
@@ -58,9 +70,17 @@ type Block struct {
 	Block    []byte
 }
 
-// GetBlocks struct
-type GetBlocks struct {
+// GetHeaders struct - Locator lists hashes from our best header back to genesis, see BlockChain.BlockLocator
+type GetHeaders struct {
+	AddrFrom string
+	Locator  [][]byte
+	StopHash []byte
+}
+
+// Headers struct
+type Headers struct {
 	AddrFrom string
+	Headers  [][]byte
 }
 
 // GetData struct
@@ -70,6 +90,21 @@ type GetData struct {
 	ID       []byte
 }
 
+// GetFiltered struct - asks for the transactions in a block that pay to or spend from the public key hashes
+type GetFiltered struct {
+	AddrFrom     string
+	BlockHash    []byte
+	PubKeyHashes [][]byte
+}
+
+// MerkleBlock struct - the answer to GetFiltered, Proofs[i] proves Transactions[i] is in the block
+type MerkleBlock struct {
+	AddrFrom     string
+	BlockHash    []byte
+	Transactions [][]byte
+	Proofs       []blockchain.MerkleProof
+}
+
 // Inv struct
 type Inv struct {
 	AddrFrom string
@@ -91,32 +126,77 @@ type Version struct {
 }
 
 // StartServer function
-func StartServer(hostFlag net.IP, portFlag uint16, addressFlag, minerAddressNew, basePath string, bootstrapAddresses []string) {
+func StartServer(hostFlag net.IP, portFlag uint16, addressFlag, minerAddressNew, basePath string, bootstrapAddresses []string) error {
+	minerAddress = minerAddressNew //TODO - miners pool
+
+	var err error
+	chain, err = blockchain.ContinueBlockChain(fmt.Sprint(portFlag), basePath) //FIXME Node.ID().Port??? //uint16 to string
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+
+	pool, err = mempool.New(chain, mempool.DefaultConfig)
+	if err != nil {
+		return err
+	}
+	go expirePool()
+	go expireRequests()
+	go verifySnapshot(blockchain.HistoryConfig(fmt.Sprint(portFlag), basePath))
+
+	// Keep the memory pool in step with the main chain across reorganizations.
+	chain.Events = blockchain.ChainEvents{
+		OnBlockConnected: func(block *blockchain.Block) {
+			if err := pool.BlockConnected(block); err != nil {
+				fmt.Printf("Failed to update memory pool: %s\n", err)
+			}
+		},
+		OnBlockDisconnected: pool.BlockDisconnected,
+	}
+
+	node, err := startNode(hostFlag, portFlag, addressFlag, handle, bootstrapAddresses)
+	if err != nil {
+		return err
+	}
+
+	// Release resources associated to Node at the end of the program.
+	defer node.Close()
+
+	//TODO - check if kademlia auto finds closest, else, use FindClosest(target noise.PublicKey, k int)
+	peers := Overlay.Table().Peers()
+	if len(peers) > 0 {
+		// TODO - ping node to check if its accessable, if not move on to next closest peers[1]
+		if err := SendVersion(peers[0].Address, chain); err != nil {
+			return err
+		}
+	}
+
+	WaitForCtrlC()
+	fmt.Printf("\n")
+
+	return nil
+}
 
+// startNode function - creates the node, joins the network through the bootstrap addresses and hands the
+// messages that arrive to handler
+func startNode(hostFlag net.IP, portFlag uint16, addressFlag string, handler noise.Handler, bootstrapAddresses []string) (*noise.Node, error) {
 	// Create a new configured node.
 	node, err := noise.NewNode(
 		noise.WithNodeBindHost(hostFlag),
 		noise.WithNodeBindPort(portFlag),
 		noise.WithNodeAddress(addressFlag),
 	)
-	HandleError(err)
-
-	// Release resources associated to Node at the end of the program.
-	defer node.Close()
+	if err != nil {
+		return nil, err
+	}
 
 	Node = node
 
-	minerAddress = minerAddressNew //TODO - miners pool
-
-	chain = blockchain.ContinueBlockChain(fmt.Sprint(portFlag), basePath) //FIXME Node.ID().Port??? //uint16 to string
-	defer chain.Database.Close()
-	go CloseDB(chain)
-
 	// Register the X/Y/Z Go type to the Node with an associated unmarshal function.
 	node.RegisterMessage(commandMessage{}, unmarshalCommandMessage)
 
 	// Register a X/Y/Z handler to the Node.
-	node.Handle(handle)
+	node.Handle(handler)
 
 	// Instantiate Kademlia.
 	events := kademlia.Events{
@@ -125,6 +205,7 @@ func StartServer(hostFlag net.IP, portFlag uint16, addressFlag, minerAddressNew,
 		},
 		OnPeerEvicted: func(id noise.ID) {
 			fmt.Printf("Forgotten a peer %s(%s).\n", id.Address, id.ID.String()[:printedLength])
+			blockDownloader.removePeer(id.Address)
 		},
 	}
 
@@ -134,85 +215,185 @@ func StartServer(hostFlag net.IP, portFlag uint16, addressFlag, minerAddressNew,
 	node.Bind(Overlay.Protocol())
 
 	// Have the Node start listening for new peers.
-	HandleError(node.Listen())
+	if err := node.Listen(); err != nil {
+		node.Close()
+		return nil, err
+	}
 
 	// Print out the nodes ID and a help message comprised of commands.
 	help(node)
 
 	// Ping nodes to initially bootstrap and discover peers from.
-	bootstrap(node, bootstrapAddresses...) // FIXME addressFlag????
+	if err := bootstrap(node, bootstrapAddresses...); err != nil { // FIXME addressFlag????
+		node.Close()
+		return nil, err
+	}
 
 	// Attempt to discover peers if we are bootstrapped to any nodes.
 	discover(Overlay)
 
-	//TODO - check if kademlia auto finds closest, else, use FindClosest(target noise.PublicKey, k int)
-	peers := Overlay.Table().Peers()
-	if len(peers) > 0 {
-		// TODO - ping node to check if its accessable, if not move on to next closest peers[1]
-		SendVersion(peers[0].Address, chain)
-	}
-
-	WaitForCtrlC()
-	fmt.Printf("\n")
-
+	return node, nil
 }
 
-// RequestBlocks function
-func RequestBlocks() {
+// RequestBlocks function - asks every peer for the headers we are missing
+func RequestBlocks() error {
 	for _, id := range Overlay.Table().Peers() {
-		SendGetBlocks(id.Address)
+		if err := SendGetHeaders(id.Address); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 // SendBlock function
-func SendBlock(addr string, b *blockchain.Block) {
+func SendBlock(addr string, b *blockchain.Block) error {
 	data := Block{Node.ID().Address, b.Serialize()}
-	payload := GobEncode(data)
+	payload, err := GobEncode(data)
+	if err != nil {
+		return err
+	}
 	request := commandMessage{cmdType: "block", contents: payload}
 
 	SendDataToOne(addr, request)
+
+	return nil
 }
 
 // SendInv function
-func SendInv(address, kind string, items [][]byte) {
+func SendInv(address, kind string, items [][]byte) error {
 	inventory := Inv{Node.ID().Address, kind, items}
-	payload := GobEncode(inventory)
+	payload, err := GobEncode(inventory)
+	if err != nil {
+		return err
+	}
 	request := commandMessage{cmdType: "inv", contents: payload}
 
 	SendDataToOne(address, request)
+
+	return nil
 }
 
 // SendTx function
-func SendTx(addr string, tnx *blockchain.Transaction) {
+func SendTx(addr string, tnx *blockchain.Transaction) error {
 	data := Tx{Node.ID().Address, tnx.Serialize()}
-	payload := GobEncode(data)
+	payload, err := GobEncode(data)
+	if err != nil {
+		return err
+	}
 	request := commandMessage{cmdType: "tx", contents: payload}
 
 	SendDataToOne(addr, request)
+
+	return nil
 }
 
 // SendVersion function
-func SendVersion(addr string, chain *blockchain.BlockChain) {
-	bestHeight := chain.GetBestHeight()
-	payload := GobEncode(Version{version, bestHeight, Node.ID().Address})
+func SendVersion(addr string, chain *blockchain.BlockChain) error {
+	chainMutex.Lock()
+	bestHeight, err := chain.GetBestHeight()
+	chainMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	payload, err := GobEncode(Version{version, bestHeight, Node.ID().Address})
+	if err != nil {
+		return err
+	}
 	request := commandMessage{cmdType: "version", contents: payload}
 	SendDataToOne(addr, request)
+
+	return nil
+}
+
+// SendGetHeaders function
+func SendGetHeaders(address string) error {
+	var locator [][]byte
+	var err error
+
+	chainMutex.Lock()
+	if headerChain != nil {
+		locator, err = headerChain.BlockLocator()
+	} else {
+		locator, err = chain.BlockLocator()
+	}
+	chainMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	payload, err := GobEncode(GetHeaders{Node.ID().Address, locator, nil})
+	if err != nil {
+		return err
+	}
+	request := commandMessage{cmdType: "getheaders", contents: payload}
+
+	SendDataToOne(address, request)
+
+	return nil
+}
+
+// SendHeaders function
+func SendHeaders(address string, headers []*blockchain.BlockHeader) error {
+	var items [][]byte
+	for _, header := range headers {
+		items = append(items, header.Serialize())
+	}
+
+	payload, err := GobEncode(Headers{Node.ID().Address, items})
+	if err != nil {
+		return err
+	}
+	request := commandMessage{cmdType: "headers", contents: payload}
+
+	SendDataToOne(address, request)
+
+	return nil
 }
 
-// SendGetBlocks function
-func SendGetBlocks(address string) {
-	payload := GobEncode(GetBlocks{Node.ID().Address})
-	request := commandMessage{cmdType: "getblocks", contents: payload}
+// SendGetFiltered function
+func SendGetFiltered(address string, blockHash []byte, pubKeyHashes [][]byte) error {
+	payload, err := GobEncode(GetFiltered{Node.ID().Address, blockHash, pubKeyHashes})
+	if err != nil {
+		return err
+	}
+	request := commandMessage{cmdType: "getfiltered", contents: payload}
 
 	SendDataToOne(address, request)
+
+	return nil
+}
+
+// SendMerkleBlock function
+func SendMerkleBlock(address string, blockHash []byte, txs []*blockchain.Transaction, proofs []*blockchain.MerkleProof) error {
+	data := MerkleBlock{AddrFrom: Node.ID().Address, BlockHash: blockHash}
+	for i, tx := range txs {
+		data.Transactions = append(data.Transactions, tx.Serialize())
+		data.Proofs = append(data.Proofs, *proofs[i])
+	}
+
+	payload, err := GobEncode(data)
+	if err != nil {
+		return err
+	}
+	request := commandMessage{cmdType: "merkleblock", contents: payload}
+
+	SendDataToOne(address, request)
+
+	return nil
 }
 
 // SendGetData function
-func SendGetData(address, kind string, id []byte) {
-	payload := GobEncode(GetData{Node.ID().Address, kind, id})
+func SendGetData(address, kind string, id []byte) error {
+	payload, err := GobEncode(GetData{Node.ID().Address, kind, id})
+	if err != nil {
+		return err
+	}
 	request := commandMessage{cmdType: "getdata", contents: payload}
 
 	SendDataToOne(address, request)
+
+	return nil
 }
 
 // SendData function send data to all ... use case ?
@@ -249,246 +430,546 @@ func SendDataToOne(addr string, msg noise.Serializable) {
 	}
 }
 
-// handle handles valid command messages from peers.
-func handle(ctx noise.HandlerContext) error {
+// decodeCommand function - false for anything that is not a command message from a peer we listen to
+func decodeCommand(ctx noise.HandlerContext) (commandMessage, bool) {
 	if ctx.IsRequest() {
-		return nil
+		return commandMessage{}, false
 	}
 
 	obj, err := ctx.DecodeMessage()
 	if err != nil {
-		return nil
+		return commandMessage{}, false
 	}
 
 	msg, ok := obj.(commandMessage)
 	if !ok {
-		return nil
+		return commandMessage{}, false
 	}
 
 	if len(msg.cmdType) == 0 || len(msg.contents) == 0 {
-		return nil
+		return commandMessage{}, false
+	}
+
+	if isBanned(ctx.ID().Address) {
+		return commandMessage{}, false
 	}
 
 	fmt.Printf("Received %s command\n", msg.cmdType)
 
+	return msg, true
+}
+
+// handle handles valid command messages from peers.
+func handle(ctx noise.HandlerContext) error {
+	msg, ok := decodeCommand(ctx)
+	if !ok {
+		return nil
+	}
+
+	var err error
 	switch msg.cmdType {
 	// case "addr":
 	// 	HandleAddr(cmd.contents)
 	case "block":
-		HandleBlock(msg.contents)
+		err = HandleBlock(ctx.ID().Address, msg.contents)
 	case "inv":
-		HandleInv(msg.contents)
-	case "getblocks":
-		HandleGetBlocks(msg.contents)
+		err = HandleInv(msg.contents)
+	case "getheaders":
+		err = HandleGetHeaders(msg.contents)
+	case "headers":
+		err = HandleHeaders(ctx.ID().Address, msg.contents)
 	case "getdata":
-		HandleGetData(msg.contents)
+		err = HandleGetData(msg.contents)
+	case "getfiltered":
+		err = HandleGetFiltered(ctx.ID().Address, msg.contents)
 	case "tx":
-		HandleTx(msg.contents)
+		err = HandleTx(ctx.ID().Address, msg.contents)
 	case "version":
-		HandleVersion(msg.contents)
+		err = HandleVersion(msg.contents)
 	default:
 		fmt.Println("Unknown command")
 	}
 
+	if err != nil {
+		fmt.Printf("Failed to handle %s command from %s: %s\n", msg.cmdType, ctx.ID().Address, err)
+	}
+
 	return nil
 }
 
 // HandleInv function
-func HandleInv(request []byte) {
+func HandleInv(request []byte) error {
 	var buff bytes.Buffer
 	var payload Inv
 
 	buff.Write(request)
 	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	HandleError(err)
+	if err := dec.Decode(&payload); err != nil {
+		return err
+	}
 
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
-	if payload.Type == "block" {
-		blocksInTransit = payload.Items
+	if len(payload.Items) == 0 {
+		return nil
+	}
 
-		blockHash := payload.Items[0]
-		SendGetData(payload.AddrFrom, "block", blockHash)
+	if payload.Type == "block" {
+		// new blocks are announced by hash, fetch the headers leading to them so they can be checked first
+		for _, blockHash := range payload.Items {
+			chainMutex.Lock()
+			_, err := chain.GetHeader(blockHash)
+			chainMutex.Unlock()
 
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+			if err != nil {
+				return SendGetHeaders(payload.AddrFrom)
 			}
 		}
-		blocksInTransit = newInTransit
 	}
 
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if memoryPool[hex.EncodeToString(txID)].ID == nil {
-			SendGetData(payload.AddrFrom, "tx", txID)
+		if !pool.Has(txID) {
+			if err := SendGetData(payload.AddrFrom, "tx", txID); err != nil {
+				return err
+			}
 		}
 	}
+
+	return nil
 }
 
 // HandleBlock function
-func HandleBlock(request []byte) {
+func HandleBlock(peer string, request []byte) error {
 	var buff bytes.Buffer
 	var payload Block
 
 	buff.Write(request)
 	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	HandleError(err)
+	if err := dec.Decode(&payload); err != nil {
+		return err
+	}
 
 	blockData := payload.Block
-	block := blockchain.Deserialize(blockData)
+	block, err := blockchain.Deserialize(blockData)
+	if err != nil {
+		return err
+	}
 
 	fmt.Println("Recevid a new block!")
-	chain.AddBlock(block)
+	chainMutex.Lock()
+	err = chain.AddBlock(block)
+	chainMutex.Unlock()
+	blockDownloader.received(block.Hash)
+
+	if err != nil {
+		Misbehaving(peer, misbehaviourScore(err))
+		return fmt.Errorf("rejected block %x: %w", block.Hash, err)
+	}
 
 	fmt.Printf("Added block %x\n", block.Hash)
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		SendGetData(payload.AddrFrom, "block", blockHash)
+	return blockDownloader.fetch()
+}
 
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-		UTXOSet.Reindex()
+// HandleGetHeaders function - answers with our main chain headers from where it splits from the peer's
+func HandleGetHeaders(request []byte) error {
+	var buff bytes.Buffer
+	var payload GetHeaders
+
+	buff.Write(request)
+	dec := gob.NewDecoder(&buff)
+	if err := dec.Decode(&payload); err != nil {
+		return err
 	}
+
+	chainMutex.Lock()
+	headers, err := chain.LocateHeaders(payload.Locator, payload.StopHash, blockchain.MaxHeadersPerMsg)
+	chainMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if len(headers) > 0 {
+		if err := SendHeaders(payload.AddrFrom, headers); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-// HandleGetBlocks function
-func HandleGetBlocks(request []byte) {
+// HandleHeaders function - checks the headers' proof of work, then starts downloading the bodies
+func HandleHeaders(peer string, request []byte) error {
 	var buff bytes.Buffer
-	var payload GetBlocks
+	var payload Headers
 
 	buff.Write(request)
 	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	HandleError(err)
+	if err := dec.Decode(&payload); err != nil {
+		return err
+	}
+
+	if len(payload.Headers) > blockchain.MaxHeadersPerMsg {
+		Misbehaving(peer, banThreshold)
+		return fmt.Errorf("%d headers is more than %d", len(payload.Headers), blockchain.MaxHeadersPerMsg)
+	}
+
+	var headers []*blockchain.BlockHeader
+	for _, data := range payload.Headers {
+		header, err := blockchain.DeserializeHeader(data)
+		if err != nil {
+			return err
+		}
+		headers = append(headers, header)
+	}
+
+	fmt.Printf("Recevied %d headers\n", len(headers))
+
+	chainMutex.Lock()
+	err := chain.AddHeaders(headers)
+	chainMutex.Unlock()
+	if err != nil {
+		Misbehaving(peer, misbehaviourScore(err))
+		return fmt.Errorf("rejected headers: %w", err)
+	}
+
+	blockDownloader.addPeer(payload.AddrFrom)
 
-	blocks := chain.GetBlockHashes()
-	SendInv(payload.AddrFrom, "block", blocks)
+	// a full message means the peer has more
+	if len(headers) == blockchain.MaxHeadersPerMsg {
+		if err := SendGetHeaders(payload.AddrFrom); err != nil {
+			return err
+		}
+	}
+
+	return blockDownloader.fetch()
 }
 
 // HandleGetData function
-func HandleGetData(request []byte) {
+func HandleGetData(request []byte) error {
 	var buff bytes.Buffer
 	var payload GetData
 
 	buff.Write(request)
 	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	HandleError(err)
+	if err := dec.Decode(&payload); err != nil {
+		return err
+	}
 
 	if payload.Type == "block" {
+		chainMutex.Lock()
 		block, err := chain.GetBlock([]byte(payload.ID))
+		chainMutex.Unlock()
 		if err != nil {
-			return
+			return err
 		}
 
-		SendBlock(payload.AddrFrom, &block)
+		if err := SendBlock(payload.AddrFrom, &block); err != nil {
+			return err
+		}
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := memoryPool[txID]
+		tx, ok := pool.Get(payload.ID)
+		if !ok {
+			return blockchain.ErrTxNotFound
+		}
 
-		SendTx(payload.AddrFrom, &tx)
+		if err := SendTx(payload.AddrFrom, &tx); err != nil {
+			return err
+		}
 	}
+
+	return nil
+}
+
+// HandleGetFiltered function - answers a light node with the block's transactions that touch its wallet,
+// each with a merkle proof, instead of the whole block
+func HandleGetFiltered(peer string, request []byte) error {
+	var buff bytes.Buffer
+	var payload GetFiltered
+
+	buff.Write(request)
+	dec := gob.NewDecoder(&buff)
+	if err := dec.Decode(&payload); err != nil {
+		return err
+	}
+
+	if len(payload.PubKeyHashes) > maxFilterKeys {
+		Misbehaving(peer, banThreshold)
+		return fmt.Errorf("%d keys is more than %d", len(payload.PubKeyHashes), maxFilterKeys)
+	}
+
+	chainMutex.Lock()
+	block, err := chain.GetBlock(payload.BlockHash)
+	chainMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	txs, proofs, err := blockchain.FilterBlock(&block, payload.PubKeyHashes)
+	if err != nil {
+		return err
+	}
+
+	return SendMerkleBlock(payload.AddrFrom, block.Hash, txs, proofs)
 }
 
 // HandleTx function
-func HandleTx(request []byte) {
+func HandleTx(peer string, request []byte) error {
 	var buff bytes.Buffer
 	var payload Tx
 
 	buff.Write(request)
 	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	HandleError(err)
+	if err := dec.Decode(&payload); err != nil {
+		return err
+	}
 
 	txData := payload.Transaction
-	tx := blockchain.DeserializeTransaction(txData)
-	memoryPool[hex.EncodeToString(tx.ID)] = tx
+	tx, err := blockchain.DeserializeTransaction(txData)
+	if err != nil {
+		return err
+	}
+	chainMutex.Lock()
+	err = pool.Add(&tx)
+	chainMutex.Unlock()
+	if err != nil {
+		if !txDependsOnContext(err) {
+			Misbehaving(peer, invalidTxScore)
+		}
+		return fmt.Errorf("rejected transaction %x: %w", tx.ID, err)
+	}
 
-	fmt.Printf("%s, %d\n", Node.ID().Address, len(memoryPool))
+	fmt.Printf("%s, %d\n", Node.ID().Address, pool.Count())
 
-	if Node.ID().Address == Overlay.Table().Peers()[0].Address { //FIXME - look into
-		for _, id := range Overlay.Table().Peers() {
+	peers := Overlay.Table().Peers()
+	if len(peers) > 0 && Node.ID().Address == peers[0].Address { //FIXME - look into
+		for _, id := range peers {
 			if id.Address != Node.ID().Address && id.Address != payload.AddrFrom {
-				SendInv(id.Address, "tx", [][]byte{tx.ID})
+				if err := SendInv(id.Address, "tx", [][]byte{tx.ID}); err != nil {
+					return err
+				}
 			}
 		}
 	} else {
-		if len(memoryPool) >= 2 && len(minerAddress) > 0 {
-			MineTx()
+		if pool.Count() >= 2 && len(minerAddress) > 0 {
+			return MineTx()
+		}
+	}
+
+	return nil
+}
+
+// BumpFee function - replaces a transaction waiting in the running node's pool with one from the wallets paying
+// enough more to evict it and its descendants, then sends the replacement to our peers
+func BumpFee(txID []byte, wallets *wallet.Wallets) (*blockchain.Transaction, error) {
+	if pool == nil {
+		return nil, ErrNodeNotRunning
+	}
+
+	chainMutex.Lock()
+	original, ok := pool.Get(txID)
+	if !ok {
+		chainMutex.Unlock()
+		return nil, mempool.ErrNotInPool
+	}
+	extra, err := pool.ReplacementCost(txID, len(original.Serialize()))
+	if err != nil {
+		chainMutex.Unlock()
+		return nil, err
+	}
+	tx, _, err := blockchain.BumpFeeFromWallets(wallets, &original, blockchain.ChangeOutput, extra, &blockchain.UTXOSet{Blockchain: chain})
+	if err == nil {
+		err = pool.Add(tx)
+	}
+	chainMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range Overlay.Table().Peers() {
+		if id.Address != Node.ID().Address {
+			if err := SendTx(id.Address, tx); err != nil {
+				return nil, err
+			}
 		}
 	}
+
+	return tx, nil
 }
 
-// MineTx function
-func MineTx() {
-	var txs []*blockchain.Transaction
+// MineTx function - mines the best paying transactions in the memory pool into a block
+func MineTx() error {
+	newBlock, err := mineBlock()
+	if err != nil || newBlock == nil {
+		return err
+	}
 
-	for id := range memoryPool {
-		fmt.Printf("tx: %s\n", memoryPool[id].ID)
-		tx := memoryPool[id]
-		if chain.VerifyTransaction(&tx) {
-			txs = append(txs, &tx)
+	fmt.Println("New BLock mined")
+
+	for _, id := range Overlay.Table().Peers() {
+		if id.Address != Node.ID().Address {
+			if err := SendInv(id.Address, "block", [][]byte{newBlock.Hash}); err != nil {
+				return err
+			}
 		}
 	}
 
+	if pool.Count() > 0 {
+		return MineTx()
+	}
+
+	return nil
+}
+
+// mineBlock function - returns nil if there is nothing worth mining
+func mineBlock() (*blockchain.Block, error) {
+	chainMutex.Lock()
+	defer chainMutex.Unlock()
+
+	txs, fees := chain.SelectTransactions(pool.Transactions())
 	if len(txs) == 0 {
 		fmt.Println("All Transactions are invalid")
-		return
+		return nil, nil
 	}
 
-	cbTx := blockchain.CoinbaseTx(minerAddress, "")
-	txs = append(txs, cbTx)
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
 
-	newBlock := chain.MineBlock(txs)
-	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
+	cbTx, err := blockchain.CoinbaseTx(minerAddress, "", bestHeight+1, fees)
+	if err != nil {
+		return nil, err
+	}
+	txs = append([]*blockchain.Transaction{cbTx}, txs...)
 
-	fmt.Println("New BLock mined")
+	// the UTXO set is updated as the block is connected, and the chain's OnBlockConnected event takes its
+	// transactions out of the pool
+	return chain.MineBlock(txs)
+}
 
-	for _, tx := range txs {
-		txID := hex.EncodeToString(tx.ID)
-		delete(memoryPool, txID)
+// expirePool function - drops long waiting transactions from the memory pool
+func expirePool() {
+	for range time.Tick(expiryInterval) {
+		if err := pool.Expire(); err != nil {
+			fmt.Printf("Failed to expire memory pool: %s\n", err)
+		}
 	}
+}
 
-	for _, id := range Overlay.Table().Peers() {
-		if id.Address != Node.ID().Address {
-			SendInv(id.Address, "block", [][]byte{newBlock.Hash})
-		}
+// verifySnapshot function - checks the history of a chain started from a UTXO snapshot while the node runs,
+// rebuilding it in the scratch store
+func verifySnapshot(scratch blockchain.Config) {
+	state, err := chain.SnapshotStatus()
+	if err != nil {
+		fmt.Printf("Failed to read the snapshot state: %s\n", err)
+		return
+	}
+	if state == nil || state.Verified || state.HistoryFile == "" {
+		return
 	}
 
-	if len(memoryPool) > 0 {
-		MineTx()
+	file, err := os.Open(state.HistoryFile)
+	if err != nil {
+		fmt.Printf("Failed to open the snapshot history: %s\n", err)
+		return
 	}
+	defer file.Close()
+
+	fmt.Printf("Verifying the history before the UTXO snapshot at height %d\n", state.Height)
+	if err := chain.VerifySnapshotHistory(file, scratch, &chainMutex); err != nil {
+		fmt.Printf("Snapshot history failed verification: %s\n", err)
+		return
+	}
+	fmt.Println("Snapshot history verified")
 }
 
-//HandleVersion function
-func HandleVersion(request []byte) {
+// HandleVersion function
+func HandleVersion(request []byte) error {
 	var buff bytes.Buffer
 	var payload Version
 
 	buff.Write(request)
 	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	HandleError(err)
+	if err := dec.Decode(&payload); err != nil {
+		return err
+	}
 
-	bestHeight := chain.GetBestHeight()
+	chainMutex.Lock()
+	bestHeight, err := chain.GetBestHeight()
+	chainMutex.Unlock()
+	if err != nil {
+		return err
+	}
 	otherHeight := payload.BestHeight
 
 	if bestHeight < otherHeight {
-		SendGetBlocks(payload.AddrFrom)
+		if err := SendGetHeaders(payload.AddrFrom); err != nil {
+			return err
+		}
 	} else if bestHeight > otherHeight {
-		SendVersion(payload.AddrFrom, chain)
+		if err := SendVersion(payload.AddrFrom, chain); err != nil {
+			return err
+		}
 	}
 
+	return nil
+}
+
+// misbehaviourScore function - what a peer is penalized for sending a block or headers rejected with err.
+// Only rule errors count, and not those that depend on our own clock rather than on the data.
+func misbehaviourScore(err error) int {
+	var ruleErr blockchain.RuleError
+	if !errors.As(err, &ruleErr) || ruleErr.Err == blockchain.ErrTimeTooNew {
+		return 0
+	}
+
+	return banThreshold
+}
+
+// txDependsOnContext function - true if a transaction was rejected for what is in our pool or chain right now
+// rather than for being invalid, so the peer that relayed it is not to blame
+func txDependsOnContext(err error) bool {
+	for _, contextErr := range []error{mempool.ErrAlreadyHave, mempool.ErrMissingInputs, mempool.ErrConflict,
+		mempool.ErrPoolFull, mempool.ErrReplacementFee, mempool.ErrTooManyReplacements,
+		blockchain.ErrMissingInput, blockchain.ErrImmatureSpend, blockchain.ErrLockTime,
+		blockchain.ErrSequenceLock} {
+		if errors.Is(err, contextErr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Misbehaving function - raises a peer's misbehaviour score, dropping and ignoring it once it reaches banThreshold
+func Misbehaving(addr string, score int) {
+	if score == 0 {
+		return
+	}
+
+	banMutex.Lock()
+	defer banMutex.Unlock()
+
+	banScores[addr] += score
+	if banScores[addr] >= banThreshold && !bannedPeers[addr] {
+		bannedPeers[addr] = true
+		Overlay.Table().DeleteByAddress(addr)
+		blockDownloader.removePeer(addr)
+		fmt.Printf("Banned peer %s\n", addr)
+	}
+}
+
+// isBanned function
+func isBanned(addr string) bool {
+	banMutex.Lock()
+	defer banMutex.Unlock()
+
+	return bannedPeers[addr]
 }
 
 func (m commandMessage) Marshal() []byte {
@@ -496,6 +977,9 @@ func (m commandMessage) Marshal() []byte {
 }
 
 func unmarshalCommandMessage(buf []byte) (commandMessage, error) {
+	if len(buf) < commandLength {
+		return commandMessage{}, errors.New("command message is too short")
+	}
 	return commandMessage{cmdType: BytesToCmd(buf[:commandLength]), contents: buf[commandLength:]}, nil
 }
 
@@ -509,7 +993,7 @@ func help(node *noise.Node) {
 }
 
 // bootstrap pings and dials an array of network addresses which we may interact with and  discover peers from.
-func bootstrap(node *noise.Node, addresses ...string) {
+func bootstrap(node *noise.Node, addresses ...string) error {
 	failed := true
 	fmt.Printf("Addresses: %s \n", addresses)
 	for _, addr := range addresses {
@@ -524,9 +1008,11 @@ func bootstrap(node *noise.Node, addresses ...string) {
 			failed = false
 		}
 	}
-	if failed {
-		log.Panic("Failed to ping bootstrap nodes")
+	if failed && len(addresses) > 0 {
+		return errors.New("Failed to ping bootstrap nodes")
 	}
+
+	return nil
 }
 
 // discover uses Kademlia to discover new peers from nodes we already are aware of.
@@ -582,32 +1068,15 @@ func BytesToCmd(bytes []byte) string {
 }
 
 // GobEncode function
-func GobEncode(data interface{}) []byte {
+func GobEncode(data interface{}) ([]byte, error) {
 	var buff bytes.Buffer
 
 	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(data)
-	HandleError(err)
-
-	return buff.Bytes()
-}
-
-// CloseDB function
-func CloseDB(chain *blockchain.BlockChain) {
-	d := death.NewDeath(syscall.SIGINT, syscall.SIGTERM, os.Interrupt) //linux, mac, windows
-
-	d.WaitForDeathWithFunc(func() {
-		defer os.Exit(1)
-		defer runtime.Goexit()
-		chain.Database.Close()
-	})
-}
-
-// HandleError function
-func HandleError(err error) {
-	if err != nil {
-		log.Panic(err)
+	if err := enc.Encode(data); err != nil {
+		return nil, err
 	}
+
+	return buff.Bytes(), nil
 }
 
 // WaitForCtrlC function
@@ -616,7 +1085,7 @@ func WaitForCtrlC() {
 	endWaiter.Add(1)
 	var signalChannel chan os.Signal
 	signalChannel = make(chan os.Signal, 1)
-	signal.Notify(signalChannel, os.Interrupt)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-signalChannel
 		endWaiter.Done()
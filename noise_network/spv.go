@@ -0,0 +1,255 @@
+package noisenetwork
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net"
+
+	"github.com/jlynch25/golang-blockchain/blockchain"
+	"github.com/jlynch25/golang-blockchain/wallet"
+	"github.com/perlin-network/noise"
+)
+
+var (
+	// the headers a light node keeps in place of the blockchain
+	headerChain *blockchain.HeaderChain
+	// the public key hashes of the wallet a light node asks for transactions about
+	watchList [][]byte
+)
+
+// StartLightServer function - runs a light (SPV) node. It keeps only block headers and asks full nodes for the
+// transactions touching watchAddresses, checking each against its block's merkle root.
+func StartLightServer(hostFlag net.IP, portFlag uint16, addressFlag, basePath string, watchAddresses, bootstrapAddresses []string) error {
+	watchList = nil
+	for _, address := range watchAddresses {
+		pubKeyHash, err := wallet.AddressPubKeyHash(address)
+		if err != nil {
+			return err
+		}
+		watchList = append(watchList, pubKeyHash)
+	}
+	if len(watchList) > maxFilterKeys {
+		return fmt.Errorf("a light node can watch at most %d addresses", maxFilterKeys)
+	}
+
+	var err error
+	headerChain, err = blockchain.OpenHeaderChain(fmt.Sprint(portFlag), basePath)
+	if err != nil {
+		return err
+	}
+	defer headerChain.Close()
+
+	node, err := startNode(hostFlag, portFlag, addressFlag, handleLight, bootstrapAddresses)
+	if err != nil {
+		return err
+	}
+
+	// Release resources associated to Node at the end of the program.
+	defer node.Close()
+
+	peers := Overlay.Table().Peers()
+	if len(peers) > 0 {
+		if err := sendLightVersion(peers[0].Address); err != nil {
+			return err
+		}
+	}
+
+	WaitForCtrlC()
+	fmt.Printf("\n")
+
+	return nil
+}
+
+// handleLight handles the command messages a light node cares about.
+func handleLight(ctx noise.HandlerContext) error {
+	msg, ok := decodeCommand(ctx)
+	if !ok {
+		return nil
+	}
+
+	var err error
+	switch msg.cmdType {
+	case "headers":
+		err = handleLightHeaders(ctx.ID().Address, msg.contents)
+	case "inv":
+		err = handleLightInv(msg.contents)
+	case "merkleblock":
+		err = HandleMerkleBlock(ctx.ID().Address, msg.contents)
+	case "version":
+		err = handleLightVersion(msg.contents)
+	default:
+		fmt.Printf("Ignoring %s command, this is a light node\n", msg.cmdType)
+	}
+
+	if err != nil {
+		fmt.Printf("Failed to handle %s command from %s: %s\n", msg.cmdType, ctx.ID().Address, err)
+	}
+
+	return nil
+}
+
+// lightHeight function - the height of our best header, -1 before we have any
+func lightHeight() (int, error) {
+	chainMutex.Lock()
+	defer chainMutex.Unlock()
+
+	best, err := headerChain.BestHeader()
+	if err != nil || best == nil {
+		return -1, err
+	}
+
+	return best.Height, nil
+}
+
+// sendLightVersion function
+func sendLightVersion(addr string) error {
+	height, err := lightHeight()
+	if err != nil {
+		return err
+	}
+
+	payload, err := GobEncode(Version{version, height, Node.ID().Address})
+	if err != nil {
+		return err
+	}
+	request := commandMessage{cmdType: "version", contents: payload}
+	SendDataToOne(addr, request)
+
+	return nil
+}
+
+// handleLightVersion function
+func handleLightVersion(request []byte) error {
+	var buff bytes.Buffer
+	var payload Version
+
+	buff.Write(request)
+	dec := gob.NewDecoder(&buff)
+	if err := dec.Decode(&payload); err != nil {
+		return err
+	}
+
+	height, err := lightHeight()
+	if err != nil {
+		return err
+	}
+
+	if height < payload.BestHeight {
+		if err := SendGetHeaders(payload.AddrFrom); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleLightInv function - a new block has been announced, fetch its header
+func handleLightInv(request []byte) error {
+	var buff bytes.Buffer
+	var payload Inv
+
+	buff.Write(request)
+	dec := gob.NewDecoder(&buff)
+	if err := dec.Decode(&payload); err != nil {
+		return err
+	}
+
+	if payload.Type == "block" && len(payload.Items) > 0 {
+		if err := SendGetHeaders(payload.AddrFrom); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleLightHeaders function - stores the headers, then asks for the wallet's transactions in each block
+func handleLightHeaders(peer string, request []byte) error {
+	var buff bytes.Buffer
+	var payload Headers
+
+	buff.Write(request)
+	dec := gob.NewDecoder(&buff)
+	if err := dec.Decode(&payload); err != nil {
+		return err
+	}
+
+	if len(payload.Headers) > blockchain.MaxHeadersPerMsg {
+		Misbehaving(peer, banThreshold)
+		return fmt.Errorf("%d headers is more than %d", len(payload.Headers), blockchain.MaxHeadersPerMsg)
+	}
+
+	var headers []*blockchain.BlockHeader
+	for _, data := range payload.Headers {
+		header, err := blockchain.DeserializeHeader(data)
+		if err != nil {
+			return err
+		}
+		headers = append(headers, header)
+	}
+
+	chainMutex.Lock()
+	err := headerChain.AddHeaders(headers)
+	chainMutex.Unlock()
+	if err != nil {
+		Misbehaving(peer, misbehaviourScore(err))
+		return fmt.Errorf("rejected headers: %w", err)
+	}
+
+	if len(watchList) > 0 {
+		for _, header := range headers {
+			if err := SendGetFiltered(payload.AddrFrom, header.Hash, watchList); err != nil {
+				return err
+			}
+		}
+	}
+
+	// a full message means the peer has more
+	if len(headers) == blockchain.MaxHeadersPerMsg {
+		if err := SendGetHeaders(payload.AddrFrom); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HandleMerkleBlock function - keeps the wallet transactions that prove out against their block's header
+func HandleMerkleBlock(peer string, request []byte) error {
+	var buff bytes.Buffer
+	var payload MerkleBlock
+
+	buff.Write(request)
+	dec := gob.NewDecoder(&buff)
+	if err := dec.Decode(&payload); err != nil {
+		return err
+	}
+
+	var txs []*blockchain.Transaction
+	var proofs []*blockchain.MerkleProof
+	for i, data := range payload.Transactions {
+		tx, err := blockchain.DeserializeTransaction(data)
+		if err != nil {
+			return err
+		}
+		txs = append(txs, &tx)
+		if i < len(payload.Proofs) {
+			proofs = append(proofs, &payload.Proofs[i])
+		}
+	}
+
+	chainMutex.Lock()
+	err := headerChain.AddMerkleBlock(payload.BlockHash, txs, proofs)
+	chainMutex.Unlock()
+	if err != nil {
+		Misbehaving(peer, misbehaviourScore(err))
+		return fmt.Errorf("rejected merkle block %x: %w", payload.BlockHash, err)
+	}
+
+	if len(txs) > 0 {
+		fmt.Printf("Proved %d wallet transaction(s) in block %x\n", len(txs), payload.BlockHash)
+	}
+
+	return nil
+}
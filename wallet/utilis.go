@@ -12,11 +12,8 @@ func Base58Encode(input []byte) []byte {
 }
 
 // Base58Decode function
-func Base58Decode(input []byte) []byte {
-	decode, err := base58.Decode(string(input[:]))
-	Handle(err)
-
-	return decode
+func Base58Decode(input []byte) ([]byte, error) {
+	return base58.Decode(string(input[:]))
 }
 
 // the missing chanacters are 		O 0 l I + /
@@ -6,14 +6,27 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"log"
+	"encoding/gob"
+	"errors"
+	"math/big"
 
 	"golang.org/x/crypto/ripemd160"
 )
 
 const (
 	checksumLength = 4
-	version        = byte(0x00)
+	hashLength     = 20
+	// PubKeyHashVersion is the version byte of addresses paying one public key
+	PubKeyHashVersion = byte(0x00)
+	// ScriptHashVersion is the version byte of addresses paying a redeem script, such as a multisig one
+	ScriptHashVersion = byte(0x05)
+)
+
+var (
+	// ErrInvalidAddress is returned for addresses that do not decode, fail their checksum or have an unknown version
+	ErrInvalidAddress = errors.New("Address is not Valid")
+	// ErrInvalidKey is returned when a saved wallet's public key is not a P256 point
+	ErrInvalidKey = errors.New("Wallet key is not Valid")
 )
 
 // Wallet struct
@@ -22,40 +35,100 @@ type Wallet struct {
 	PublicKey  []byte
 }
 
+// walletData struct - a wallet as it is saved. The curve is always P256, which gob cannot encode, so only the
+// private scalar and the public key are kept.
+type walletData struct {
+	D         []byte
+	PublicKey []byte
+}
+
+// GobEncode function
+func (w Wallet) GobEncode() ([]byte, error) {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	if err := enc.Encode(walletData{w.PrivateKey.D.Bytes(), w.PublicKey}); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// GobDecode function - rebuilds the private key on P256
+func (w *Wallet) GobDecode(data []byte) error {
+	var saved walletData
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&saved); err != nil {
+		return err
+	}
+
+	curve := elliptic.P256()
+	if len(saved.PublicKey) != 64 {
+		return ErrInvalidKey
+	}
+	x := new(big.Int).SetBytes(saved.PublicKey[:32])
+	y := new(big.Int).SetBytes(saved.PublicKey[32:])
+	if !curve.IsOnCurve(x, y) {
+		return ErrInvalidKey
+	}
+
+	w.PrivateKey = ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: new(big.Int).SetBytes(saved.D)}
+	w.PublicKey = saved.PublicKey
+
+	return nil
+}
+
 // Address function
 func (w Wallet) Address() []byte {
-	pubHash := PublicKeyHash(w.PublicKey)
+	return encodeAddress(PubKeyHashVersion, PublicKeyHash(w.PublicKey))
+}
+
+// ScriptAddress function - the address paying whoever can satisfy the redeem script
+func ScriptAddress(redeemScript []byte) []byte {
+	return encodeAddress(ScriptHashVersion, PublicKeyHash(redeemScript))
+}
 
-	versionedHash := append([]byte{version}, pubHash...)
+// encodeAddress function
+func encodeAddress(version byte, hash []byte) []byte {
+	versionedHash := append([]byte{version}, hash...)
 	checksum := Checksum(versionedHash)
 
 	fullHash := append(versionedHash, checksum...)
-	address := Base58Encode(fullHash)
-
-	// fmt.Printf("pub key: %x\n", w.PublicKey)
-	// fmt.Printf("pub hash: %x\n", pubHash)
-	// fmt.Printf("address: %x\n", address)
 
-	return address
+	return Base58Encode(fullHash)
 }
 
 // NewKeyPair function
-func NewKeyPair() (ecdsa.PrivateKey, []byte) {
+func NewKeyPair() (ecdsa.PrivateKey, []byte, error) {
 	curve := elliptic.P256()
 
 	private, err := ecdsa.GenerateKey(curve, rand.Reader)
-	Handle(err)
+	if err != nil {
+		return ecdsa.PrivateKey{}, nil, err
+	}
 
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-	return *private, pub
+	return *private, PublicKeyBytes(&private.PublicKey), nil
+}
+
+// PublicKeyBytes function - x then y, each padded to 32 bytes so the two halves can always be split apart
+func PublicKeyBytes(pub *ecdsa.PublicKey) []byte {
+	key := make([]byte, 64)
+	pub.X.FillBytes(key[:32])
+	pub.Y.FillBytes(key[32:])
+
+	return key
 }
 
 // MakeWallet function
-func MakeWallet() *Wallet {
-	private, public := NewKeyPair()
+func MakeWallet() (*Wallet, error) {
+	private, public, err := NewKeyPair()
+	if err != nil {
+		return nil, err
+	}
 	wallet := Wallet{private, public}
 
-	return &wallet
+	return &wallet, nil
 }
 
 // PublicKeyHash function
@@ -63,8 +136,7 @@ func PublicKeyHash(pubKey []byte) []byte {
 	pubHash := sha256.Sum256(pubKey)
 
 	hasher := ripemd160.New()
-	_, err := hasher.Write(pubHash[:])
-	Handle(err)
+	hasher.Write(pubHash[:]) // writing to a hash never returns an error
 
 	publicRipMD := hasher.Sum(nil)
 
@@ -79,20 +151,38 @@ func Checksum(payload []byte) []byte {
 	return secondHash[:checksumLength]
 }
 
-// ValidateAddress funtion
-func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
+// AddressPubKeyHash function - the hash an address pays to, of a public key or for a script address of the
+// redeem script
+func AddressPubKeyHash(address string) ([]byte, error) {
+	_, hash, err := DecodeAddress(address)
+
+	return hash, err
+}
+
+// DecodeAddress function - the version byte and hash of an address
+func DecodeAddress(address string) (byte, []byte, error) {
+	pubKeyHash, err := Base58Decode([]byte(address))
+	if err != nil || len(pubKeyHash) != 1+hashLength+checksumLength {
+		return 0, nil, ErrInvalidAddress
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
 	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
 	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
 
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+	if bytes.Compare(actualChecksum, targetChecksum) != 0 {
+		return 0, nil, ErrInvalidAddress
+	}
+	if version != PubKeyHashVersion && version != ScriptHashVersion {
+		return 0, nil, ErrInvalidAddress
+	}
+
+	return version, pubKeyHash, nil
 }
 
-// Handle function
-func Handle(err error) {
-	if err != nil {
-		log.Panic(err)
-	}
+// ValidateAddress funtion
+func ValidateAddress(address string) error {
+	_, _, err := DecodeAddress(address)
+
+	return err
 }
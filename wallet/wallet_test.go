@@ -0,0 +1,79 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestAddress(t *testing.T) {
+	w, err := MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, hash, err := DecodeAddress(string(w.Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != PubKeyHashVersion {
+		t.Fatalf("expected version %d, got %d", PubKeyHashVersion, version)
+	}
+	if !bytes.Equal(hash, PublicKeyHash(w.PublicKey)) {
+		t.Fatal("the address does not hold the public key hash")
+	}
+}
+
+func TestDecodeAddress(t *testing.T) {
+	w, err := MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.Address())
+
+	// tampered function - the address with its last character swapped for another base58 one
+	tampered := func(address string) string {
+		last := byte('1')
+		if address[len(address)-1] == last {
+			last = '2'
+		}
+		return address[:len(address)-1] + string(last)
+	}
+
+	tests := []struct {
+		name    string
+		address string
+	}{
+		{"empty", ""},
+		{"not base58", "0OIl"},
+		{"too short", address[:len(address)-4]},
+		{"bad checksum", tampered(address)},
+		{"unknown version", string(encodeAddress(0x42, PublicKeyHash(w.PublicKey)))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := DecodeAddress(test.address); !errors.Is(err, ErrInvalidAddress) {
+				t.Fatalf("expected %v, got %v", ErrInvalidAddress, err)
+			}
+			if err := ValidateAddress(test.address); err == nil {
+				t.Fatal("expected the address to be rejected")
+			}
+		})
+	}
+}
+
+func TestScriptAddress(t *testing.T) {
+	redeem := []byte("a redeem script")
+
+	version, hash, err := DecodeAddress(string(ScriptAddress(redeem)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != ScriptHashVersion {
+		t.Fatalf("expected version %d, got %d", ScriptHashVersion, version)
+	}
+	if !bytes.Equal(hash, PublicKeyHash(redeem)) {
+		t.Fatal("the address does not hold the redeem script hash")
+	}
+}
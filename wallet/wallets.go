@@ -2,11 +2,10 @@ package wallet
 
 import (
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 )
 
@@ -18,6 +17,9 @@ const (
 	// walletFile = "/tmp/wallets_%s.data"
 )
 
+// ErrWalletNotFound is returned when an address has no wallet on this device
+var ErrWalletNotFound = errors.New("Wallet does not exist on this device")
+
 // Wallets struct
 type Wallets struct {
 	Wallets map[string]*Wallet
@@ -34,13 +36,16 @@ func CreateWallets(nodeID, basePath string) (*Wallets, error) {
 }
 
 // AddWallet function
-func (ws *Wallets) AddWallet() string {
-	wallet := MakeWallet()
+func (ws *Wallets) AddWallet() (string, error) {
+	wallet, err := MakeWallet()
+	if err != nil {
+		return "", err
+	}
 	address := fmt.Sprintf("%s", wallet.Address())
 
 	ws.Wallets[address] = wallet
 
-	return address
+	return address, nil
 }
 
 // GetAllAddresses function
@@ -55,11 +60,11 @@ func (ws *Wallets) GetAllAddresses() []string {
 }
 
 // GetWallet function
-func (ws *Wallets) GetWallet(address string) Wallet {
+func (ws *Wallets) GetWallet(address string) (Wallet, error) {
 	if _, ok := ws.Wallets[address]; !ok {
-		log.Panic("Wallet does not exist on this device")
+		return Wallet{}, ErrWalletNotFound
 	}
-	return *ws.Wallets[address]
+	return *ws.Wallets[address], nil
 }
 
 // LoadFile function
@@ -75,11 +80,14 @@ func (ws *Wallets) LoadFile(nodeID, basePath string) error {
 	var wallets Wallets
 
 	fileContent, err := ioutil.ReadFile(walletFile)
+	if err != nil {
+		return err
+	}
 
-	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&wallets)
-	Handle(err)
+	if err := decoder.Decode(&wallets); err != nil {
+		return err
+	}
 
 	ws.Wallets = wallets.Wallets
 
@@ -87,17 +95,14 @@ func (ws *Wallets) LoadFile(nodeID, basePath string) error {
 }
 
 // SaveFile function
-func (ws *Wallets) SaveFile(nodeID, basePath string) {
+func (ws *Wallets) SaveFile(nodeID, basePath string) error {
 	var content bytes.Buffer
 	walletFile := fmt.Sprintf(basePath+walletFile, nodeID)
 
-	gob.Register(elliptic.P256())
-
 	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
-	Handle(err)
+	if err := encoder.Encode(ws); err != nil {
+		return err
+	}
 
-	os.Create(walletFile)
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
-	Handle(err)
+	return ioutil.WriteFile(walletFile, content.Bytes(), 0644)
 }
@@ -0,0 +1,41 @@
+package wallet
+
+import (
+	"os"
+	"testing"
+)
+
+func TestWalletsFile(t *testing.T) {
+	basePath := t.TempDir() + "/"
+
+	wallets, err := CreateWallets("3000", basePath)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected no wallet file yet, got %v", err)
+	}
+	address, err := wallets.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.SaveFile("3000", basePath); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := CreateWallets("3000", basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := loaded.GetWallet(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(w.Address()) != address {
+		t.Fatal("the loaded wallet has a different address")
+	}
+	if w.PrivateKey.D.Cmp(wallets.Wallets[address].PrivateKey.D) != 0 || !w.PrivateKey.PublicKey.Equal(&wallets.Wallets[address].PrivateKey.PublicKey) {
+		t.Fatal("the loaded wallet has a different key")
+	}
+
+	if _, err := loaded.GetWallet(address[1:]); err != ErrWalletNotFound {
+		t.Fatalf("expected %v, got %v", ErrWalletNotFound, err)
+	}
+}